@@ -21,11 +21,72 @@ func InitDB() (err error) {
 		return err
 	}
 
-	err = db.AutoMigrate(&ProvinceSetting{}, &NationalSetting{}, &Road{})
+	err = db.AutoMigrate(&ProvinceSetting{}, &NationalSetting{}, &Road{}, &Report{}, &StyleTheme{}, &ReportTheme{}, &UploadSession{}, &Dataset{}, &DatasetFile{}, &Blob{}, &Campaign{}, &SettingRevision{}, &PlanningPeriod{}, &ChainBreak{}, &RoadGeometry{}, &Disease{})
 	if err != nil {
 		logger.Logger.Errorf("failed to AutoMigrate: %v", err)
 		return err
 	}
+	if err = migrateLegacyRoads(); err != nil {
+		return err
+	}
+	return seedPlanningPeriods()
+}
+
+// migrateLegacyRoads 清理旧版路网表遗留数据：旧表带软删除字段，已软删除的行在去掉软删除后
+// 会重新出现在路网列表和路线登记中，这里将其删除并移除 deleted_at 列。旧表只有路线名称，
+// 未填路线编号的行保留待补全，不参与里程统计和数据检查
+func migrateLegacyRoads() error {
+	migrator := db.Migrator()
+	if migrator.HasColumn(&Road{}, "deleted_at") {
+		result := db.Exec("DELETE FROM roads WHERE deleted_at IS NOT NULL")
+		if result.Error != nil {
+			logger.Logger.Errorf("failed to remove soft-deleted roads: %v", result.Error)
+			return result.Error
+		}
+		logger.Logger.Infof("removed %d soft-deleted legacy roads", result.RowsAffected)
+		if migrator.HasIndex(&Road{}, "idx_roads_deleted_at") {
+			if err := migrator.DropIndex(&Road{}, "idx_roads_deleted_at"); err != nil {
+				logger.Logger.Errorf("failed to drop roads deleted_at index: %v", err)
+				return err
+			}
+		}
+		// 驱动重建表时无法识别旧表未加引号的列定义，直接用 ALTER TABLE 删除列
+		if err := db.Exec("ALTER TABLE roads DROP COLUMN deleted_at").Error; err != nil {
+			logger.Logger.Errorf("failed to drop roads deleted_at column: %v", err)
+			return err
+		}
+	}
+
+	if err := db.Exec("UPDATE roads SET route_code = '' WHERE route_code IS NULL").Error; err != nil {
+		logger.Logger.Errorf("failed to normalize legacy road route codes: %v", err)
+		return err
+	}
+	var pending int64
+	if err := db.Model(&Road{}).Where("TRIM(route_code) = ''").Count(&pending).Error; err != nil {
+		logger.Logger.Errorf("failed to count roads without route code: %v", err)
+		return err
+	}
+	if pending > 0 {
+		logger.Logger.Warnf("%d roads have no route code and are excluded from network totals and quality checks until completed", pending)
+	}
+	return nil
+}
+
+// seedPlanningPeriods 首次启动时写入五年规划期，之后以数据库中的配置为准
+func seedPlanningPeriods() error {
+	var count int64
+	if err := db.Model(&PlanningPeriod{}).Count(&count).Error; err != nil || count > 0 {
+		return err
+	}
+	periods := []PlanningPeriod{
+		{Name: "十三五", StartYear: 2016, EndYear: 2020},
+		{Name: "十四五", StartYear: 2021, EndYear: 2025},
+		{Name: "十五五", StartYear: 2026, EndYear: 2030},
+	}
+	if err := db.Create(&periods).Error; err != nil {
+		logger.Logger.Errorf("failed to seed planning periods: %v", err)
+		return err
+	}
 	return nil
 }
 
@@ -1,26 +1,146 @@
 package dao
 
-import "gorm.io/gorm"
+import (
+	"encoding/json"
+	"gorm.io/gorm"
+	"time"
+)
 
+// Road 路网登记，一条路线可按方向、管养单位分段登记。桩号和里程单位为 km
 type Road struct {
-	gorm.Model `json:"-"`
-	Name       string `json:"name" gorm:"unique"`
+	ID           uint      `json:"id" gorm:"primaryKey"`
+	RouteCode    string    `json:"routeCode" gorm:"index" binding:"required"` // 路线编号，如 G6、S101、X301、Y001
+	Name         string    `json:"name" binding:"required"`
+	AdminClass   string    `json:"adminClass"`                   // 行政等级，由路线编号首字母确定
+	TechGrade    string    `json:"techGrade" binding:"required"` // 技术等级：高速公路、一级公路…等外公路
+	Direction    string    `json:"direction"`                    // up / down / both
+	StartStake   float64   `json:"startStake"`
+	EndStake     float64   `json:"endStake"`
+	Length       float64   `json:"length"` // 未填写时按起止桩号计算
+	ManagingUnit string    `json:"managingUnit"`
+	Region       string    `json:"region"` // 所在地市或区县
+	CreatedAt    time.Time `json:"createdAt"`
+	UpdatedAt    time.Time `json:"updatedAt"`
+}
+
+// RoadGeometry 路线中心线，坐标为 WGS84 经纬度，点序与桩号增大方向一致。
+// StartStake、EndStake（km）为中心线首末点对应的桩号，用于由桩号线性定位坐标
+type RoadGeometry struct {
+	ID          uint         `json:"id" gorm:"primaryKey"`
+	RouteCode   string       `json:"routeCode" gorm:"index"`
+	Direction   string       `json:"direction"` // up / down / both，与路网登记一致
+	StartStake  float64      `json:"startStake"`
+	EndStake    float64      `json:"endStake"`
+	Length      float64      `json:"length"` // 按坐标计算的长度（米）
+	Coordinates [][2]float64 `json:"coordinates" gorm:"serializer:json"`
+	Source      string       `json:"source"` // 导入的文件名
+	CreatedAt   time.Time    `json:"createdAt"`
+}
+
+// Disease 路面病害记录，由抽检批次数据集中的病害数据表导入。Stake 以米表示
+type Disease struct {
+	ID         uint      `json:"id" gorm:"primaryKey"`
+	CampaignID uint      `json:"campaignId" gorm:"index"`
+	DatasetID  string    `json:"datasetId" gorm:"index"`
+	Year       int       `json:"year" gorm:"index"` // 检测年度，上年病害数据为批次年度减一
+	RouteCode  string    `json:"routeCode" gorm:"index"`
+	Direction  string    `json:"direction"` // up / down / both
+	Stake      float64   `json:"stake"`
+	Lane       string    `json:"lane"`
+	Type       string    `json:"type"`     // 病害名称，如横向裂缝
+	Category   string    `json:"category"` // 病害类别：crack、pothole、rutting 等
+	Severity   string    `json:"severity"` // 轻、中、重
+	Length     float64   `json:"length"`   // m
+	Width      float64   `json:"width"`    // m
+	Area       float64   `json:"area"`     // m²
+	Image      string    `json:"image"`
+	Source     string    `json:"source"` // 来源文件及行号
+	CreatedAt  time.Time `json:"createdAt"`
+}
+
+// ChainBreak 路线断链，Back 为断前桩号、Ahead 为断后桩号，均以米表示
+type ChainBreak struct {
+	ID        uint      `json:"id" gorm:"primaryKey"`
+	RouteCode string    `json:"routeCode" gorm:"index"`
+	Back      float64   `json:"back"`
+	Ahead     float64   `json:"ahead"`
+	Note      string    `json:"note"`
+	CreatedAt time.Time `json:"createdAt"`
+}
+
+// Report 报告目录，记录每份生成报告的元数据
+type Report struct {
+	gorm.Model      `json:"-"`
+	Filename        string   `json:"filename" gorm:"unique"` // 报告基础名，不含扩展名
+	ReportType      string   `json:"reportType"`
+	Year            int      `json:"year"`
+	Unit            string   `json:"unit"`
+	TemplateVersion string   `json:"templateVersion"`
+	DatasetIDs      []string `json:"datasetIds" gorm:"serializer:json"` // 计算所用的上传数据集
+	ContentHash     string   `json:"contentHash"`                       // 报告 Markdown 内容的 SHA-256
+	Status          string   `json:"status"`                            // active / withdrawn
+	RevisedBy       string   `json:"revisedBy"`                         // 被哪份新报告修订替代
+	CampaignID      *uint    `json:"campaignId" gorm:"index"`           // 所属抽检批次
+	// 生成报告时使用的指标配置副本，之后修改配置不影响已生成的报告
+	SettingsSnapshot *SettingsSnapshot `json:"settingsSnapshot" gorm:"serializer:json"`
+}
+
+// SettingsSnapshot 报告生成时冻结的省厅和交通部指标配置，未配置时为 null
+type SettingsSnapshot struct {
+	Year     int              `json:"year"`
+	Plan     string           `json:"plan"`
+	Province *ProvinceSetting `json:"province"`
+	National *NationalSetting `json:"national"`
+	// National 为规划期内该年度的阶段目标（插值或指定），而非规划期末目标
+	Milestone bool      `json:"milestone"`
+	TakenAt   time.Time `json:"takenAt"`
+}
+
+// Campaign 抽检批次，关联该批次上传的数据集、适用的指标配置和生成的报告
+type Campaign struct {
+	ID          uint      `json:"id" gorm:"primaryKey"`
+	Name        string    `json:"name" gorm:"unique" binding:"required"`
+	Year        int       `json:"year" binding:"required"`
+	District    string    `json:"district"`  // 抽检区域，如吴忠分中心辖区
+	StartDate   string    `json:"startDate"` // 2006-01-02
+	EndDate     string    `json:"endDate"`
+	Description string    `json:"description"`
+	CreatedAt   time.Time `json:"createdAt"`
+	UpdatedAt   time.Time `json:"updatedAt"`
 }
 
 type ProvinceSetting struct {
 	gorm.Model        `json:"-"`
-	Year              int     `json:"year" gorm:"unique"`
+	Year              int     `json:"year" gorm:"unique" binding:"required"`
 	Expressway        float64 `json:"expressway"`
 	NationalHighway   float64 `json:"nationalHighway"`
 	ProvincialHighway float64 `json:"provincialHighway"`
 	RuralRoad         float64 `json:"ruralRoad"`
 }
 
+// PlanningPeriod 规划期，如十四五为 2021-2025 年，交通部指标通过 Plan 与名称关联
+type PlanningPeriod struct {
+	ID        uint   `json:"id" gorm:"primaryKey"`
+	Name      string `json:"name" gorm:"unique" binding:"required"`
+	StartYear int    `json:"startYear" binding:"required"`
+	EndYear   int    `json:"endYear" binding:"required"`
+	// 年度插值的起点，一般为上一规划期末的实际值，键为交通部指标的 JSON 字段名。为空时各年度直接使用规划期目标
+	Baseline   map[string]float64 `json:"baseline" gorm:"serializer:json"`
+	Milestones []PlanMilestone    `json:"milestones" gorm:"serializer:json"` // 指定年度的阶段目标，优先于插值结果
+	CreatedAt  time.Time          `json:"createdAt"`
+	UpdatedAt  time.Time          `json:"updatedAt"`
+}
+
+type PlanMilestone struct {
+	Year   int                `json:"year"`
+	Values map[string]float64 `json:"values"`
+}
+
 type NationalSetting struct {
 	gorm.Model           `json:"-"`
-	Plan                 string  `json:"plan" gorm:"unique"`
+	Plan                 string  `json:"plan" gorm:"unique" binding:"required"`
 	MQIExcellent         float64 `json:"mqiExcellent"`
-	PQIExcellent         float64 `json:"poiExcellent"`
+	PQIExcellent         float64 `json:"pqiExcellent"`
 	BridgeRate           float64 `json:"bridgeRate"`
 	RecycleRate          float64 `json:"recycleRate"`
 	NationalMQIEast      float64 `json:"nationalMqiEast"`
@@ -38,3 +158,123 @@ type NationalSetting struct {
 	RuralMQI             float64 `json:"ruralMqi"`
 	MaintenanceRate      float64 `json:"maintenanceRate"`
 }
+
+// UnmarshalJSON 兼容旧版本使用的字段名 poiExcellent，包括已生成报告中保存的配置副本
+func (s *NationalSetting) UnmarshalJSON(data []byte) error {
+	type nationalSetting NationalSetting
+	var v struct {
+		nationalSetting
+		PoiExcellent *float64 `json:"poiExcellent"`
+	}
+	if err := json.Unmarshal(data, &v); err != nil {
+		return err
+	}
+	*s = NationalSetting(v.nationalSetting)
+	if v.PoiExcellent != nil && s.PQIExcellent == 0 {
+		s.PQIExcellent = *v.PoiExcellent
+	}
+	return nil
+}
+
+// SettingRevision 指标配置的修改记录，只追加不修改。Content 为本次修改后的完整配置，删除时为空
+type SettingRevision struct {
+	ID           uint            `json:"id" gorm:"primaryKey"`
+	Kind         string          `json:"kind" gorm:"uniqueIndex:idx_setting_revision"`                   // province / national
+	Key          string          `json:"key" gorm:"column:setting_key;uniqueIndex:idx_setting_revision"` // 省厅为年份，交通部为规划名称
+	Version      int             `json:"version" gorm:"uniqueIndex:idx_setting_revision"`
+	Action       string          `json:"action"` // create / update / delete / restore
+	RestoredFrom int             `json:"restoredFrom,omitempty"`
+	Operator     string          `json:"operator"`
+	Changes      []SettingChange `json:"changes" gorm:"serializer:json"`
+	Content      map[string]any  `json:"content" gorm:"serializer:json"`
+	CreatedAt    time.Time       `json:"createdAt"`
+}
+
+// SettingChange 单个字段的修改，新增时 Old 为空，删除时 New 为空
+type SettingChange struct {
+	Field string `json:"field"`
+	Old   any    `json:"old"`
+	New   any    `json:"new"`
+}
+
+// StyleTheme 报告导出样式主题，字号单位为 pt，页边距单位为 mm
+type StyleTheme struct {
+	gorm.Model            `json:"-"`
+	Name                  string  `json:"name" gorm:"unique"`
+	FontFamily            string  `json:"fontFamily"`
+	BodyFontSize          float64 `json:"bodyFontSize"`
+	LineHeight            float64 `json:"lineHeight"`
+	H1FontSize            float64 `json:"h1FontSize"`
+	H2FontSize            float64 `json:"h2FontSize"`
+	H3FontSize            float64 `json:"h3FontSize"`
+	TableFontSize         float64 `json:"tableFontSize"`
+	TableBorderColor      string  `json:"tableBorderColor"`
+	TableHeaderBackground string  `json:"tableHeaderBackground"`
+	TableCellPadding      float64 `json:"tableCellPadding"`
+	MarginTop             float64 `json:"marginTop"`
+	MarginRight           float64 `json:"marginRight"`
+	MarginBottom          float64 `json:"marginBottom"`
+	MarginLeft            float64 `json:"marginLeft"`
+	PaperSize             string  `json:"paperSize"`   // A4 / A3
+	Orientation           string  `json:"orientation"` // Portrait / Landscape
+}
+
+// ReportTheme 各报告类型默认使用的样式主题
+type ReportTheme struct {
+	gorm.Model `json:"-"`
+	ReportType string `json:"reportType" gorm:"unique"`
+	ThemeName  string `json:"themeName"`
+}
+
+// UploadSession 分片上传会话，记录已连续接收的字节数以支持断点续传
+type UploadSession struct {
+	ID        string    `json:"uploadId" gorm:"primaryKey"`
+	FieldName string    `json:"fieldName"` // 对应 /api/unzip 的表单字段名
+	FileName  string    `json:"fileName"`
+	Size      int64     `json:"size"`
+	SHA256    string    `json:"sha256"`
+	ChunkSize int64     `json:"chunkSize"`
+	Received  int64     `json:"received"`
+	Status    string    `json:"status"` // uploading / completed
+	CreatedAt time.Time `json:"createdAt"`
+	UpdatedAt time.Time `json:"updatedAt"`
+}
+
+// Dataset 一次上传形成的数据集，对外只暴露不透明ID，服务器路径不出现在接口中
+type Dataset struct {
+	ID         string        `json:"id" gorm:"primaryKey"`
+	Dir        string        `json:"-"` // 服务器上的存放目录
+	CampaignID *uint         `json:"campaignId" gorm:"index"`
+	CreatedAt  time.Time     `json:"createdAt"`
+	Files      []DatasetFile `json:"files"`
+}
+
+// DatasetFile 数据集中的文件，ZIP 解压出的每个文件单独登记
+type DatasetFile struct {
+	ID         uint   `json:"id" gorm:"primaryKey"`
+	DatasetID  string `json:"-" gorm:"index"`
+	Role       string `json:"role"` // 来源表单字段名
+	Name       string `json:"name"` // 相对数据集目录的路径
+	Path       string `json:"-"`
+	Size       int64  `json:"size"`
+	SHA256     string `json:"sha256"`
+	BlobSHA256 string `json:"-" gorm:"index"` // 来源上传文件在内容寻址存储中的摘要，ZIP 解压出的文件指向ZIP本身
+}
+
+// Blob 按 SHA-256 内容寻址存储的上传文件，相同内容只保存一份，数据集目录中的文件为其硬链接
+type Blob struct {
+	SHA256     string          `json:"sha256" gorm:"primaryKey"`
+	Size       int64           `json:"size"`
+	FileName   string          `json:"fileName"` // 首次上传时的文件名
+	Archive    bool            `json:"archive"`  // 是否为压缩包，压缩包同时保存解压结果
+	Rejected   []RejectedEntry `json:"rejected" gorm:"serializer:json"`
+	RefCount   int             `json:"refCount"` // 引用该文件的数据集数量
+	CreatedAt  time.Time       `json:"uploadedAt"`
+	LastUsedAt time.Time       `json:"lastUsedAt"`
+}
+
+// RejectedEntry 解压时因安全限制被跳过的压缩包条目
+type RejectedEntry struct {
+	Name   string `json:"name"`
+	Reason string `json:"reason"`
+}
@@ -0,0 +1,335 @@
+package handler
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bufio"
+	"bytes"
+	"compress/bzip2"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"github.com/nwaples/rardecode"
+	"github.com/ulikunitz/xz"
+	"io"
+	"io/fs"
+	"ningxia_backend/pkg/conf"
+	"ningxia_backend/pkg/logger"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+)
+
+const (
+	ArchiveFormatZip      = "zip"
+	ArchiveFormatTar      = "tar"
+	ArchiveFormatGzip     = "gzip"
+	ArchiveFormatBzip2    = "bzip2"
+	ArchiveFormatXz       = "xz"
+	ArchiveFormatRar      = "rar"
+	ArchiveFormatSevenZip = "7z"
+)
+
+// errUnsupportedArchive 无法识别或暂不支持的压缩包格式
+var errUnsupportedArchive = errors.New("不支持的压缩包格式")
+
+// archiveMagics 各格式的文件头，tar 的标识位于偏移 257 处，单独判断
+var archiveMagics = []struct {
+	format string
+	magic  []byte
+}{
+	{ArchiveFormatZip, []byte("PK\x03\x04")},
+	{ArchiveFormatZip, []byte("PK\x05\x06")}, // 空ZIP
+	{ArchiveFormatGzip, []byte{0x1f, 0x8b}},
+	{ArchiveFormatBzip2, []byte("BZh")},
+	{ArchiveFormatXz, []byte{0xfd, '7', 'z', 'X', 'Z', 0x00}},
+	{ArchiveFormatRar, []byte("Rar!\x1a\x07")},
+	{ArchiveFormatSevenZip, []byte{'7', 'z', 0xbc, 0xaf, 0x27, 0x1c}},
+}
+
+// nestedArchiveExts 只有这些扩展名的文件才作为嵌套压缩包继续解压，xlsx/docx 等本身也是ZIP结构
+var nestedArchiveExts = []string{".zip", ".tar", ".gz", ".tgz", ".bz2", ".tbz2", ".xz", ".txz", ".rar", ".7z"}
+
+// sniffArchiveFormat 根据文件头判断压缩格式，无法识别时返回空字符串
+func sniffArchiveFormat(header []byte) string {
+	for _, m := range archiveMagics {
+		if bytes.HasPrefix(header, m.magic) {
+			return m.format
+		}
+	}
+	if len(header) >= 262 && bytes.Equal(header[257:262], []byte("ustar")) {
+		return ArchiveFormatTar
+	}
+	return ""
+}
+
+func detectArchiveFormat(path string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	header := make([]byte, 512)
+	n, err := io.ReadFull(f, header)
+	if err != nil && !errors.Is(err, io.ErrUnexpectedEOF) && !errors.Is(err, io.EOF) {
+		return "", err
+	}
+	return sniffArchiveFormat(header[:n]), nil
+}
+
+// archiveExtractor 解压一个上传的压缩包及其中嵌套的压缩包，所有层级共用同一组资源限制
+type archiveExtractor struct {
+	guard    *archiveGuard
+	maxDepth int
+	files    []string
+	rejected []rejectedEntry
+}
+
+// extractArchive 按文件头识别格式解压 src 到 dest，嵌套的压缩包解压到同名 _extracted 目录后删除。
+// 返回解压出的文件和被跳过的条目；超出整体限制时返回 errArchiveLimit，格式不支持时返回 errUnsupportedArchive
+func extractArchive(src, dest string) ([]string, []rejectedEntry, error) {
+	x := &archiveExtractor{
+		guard:    newArchiveGuard(),
+		maxDepth: conf.Conf.GetInt("unzip.maxDepth"),
+		rejected: make([]rejectedEntry, 0),
+	}
+	if err := x.extract(src, dest, "", 0); err != nil {
+		return nil, nil, err
+	}
+	return x.files, x.rejected, nil
+}
+
+// extract 解压单个压缩包，prefix 为嵌套压缩包在上层中的路径，用于报告被跳过的条目
+func (x *archiveExtractor) extract(src, dest, prefix string, depth int) error {
+	format, err := detectArchiveFormat(src)
+	if err != nil {
+		return err
+	}
+
+	start := len(x.files)
+	switch format {
+	case ArchiveFormatZip:
+		err = x.extractZip(src, dest, prefix)
+	case ArchiveFormatRar:
+		err = x.extractRar(src, dest, prefix)
+	case ArchiveFormatTar, ArchiveFormatGzip, ArchiveFormatBzip2, ArchiveFormatXz:
+		err = x.extractStream(src, dest, prefix, format)
+	case ArchiveFormatSevenZip:
+		err = fmt.Errorf("%w: 暂不支持7z格式，请转换为ZIP后上传", errUnsupportedArchive)
+	default:
+		err = fmt.Errorf("%w: 无法识别文件 %s 的格式", errUnsupportedArchive, filepath.Base(src))
+	}
+	if err != nil {
+		return err
+	}
+
+	// 逐个检查本层解压出的文件，嵌套的压缩包继续解压
+	extracted := slices.Clone(x.files[start:])
+	x.files = x.files[:start]
+	for _, file := range extracted {
+		nestedFormat := ""
+		if slices.Contains(nestedArchiveExts, strings.ToLower(filepath.Ext(file))) {
+			if nestedFormat, err = detectArchiveFormat(file); err != nil {
+				return err
+			}
+		}
+		if nestedFormat == "" {
+			x.files = append(x.files, file)
+			continue
+		}
+
+		rel, _ := filepath.Rel(dest, file)
+		nestedName := prefix + filepath.ToSlash(rel)
+		if depth+1 > x.maxDepth {
+			x.reject(nestedName, fmt.Sprintf("压缩包嵌套超过 %d 层", x.maxDepth))
+			os.Remove(file)
+			continue
+		}
+		nestedDest := strings.TrimSuffix(file, filepath.Ext(file)) + "_extracted"
+		if err = os.MkdirAll(nestedDest, extractedDirMode); err != nil {
+			return err
+		}
+		if err = x.extract(file, nestedDest, nestedName+"/", depth+1); err != nil {
+			if errors.Is(err, errUnsupportedArchive) {
+				// 内层格式不支持时只跳过该文件，不影响整个上传
+				x.reject(nestedName, err.Error())
+				os.Remove(file)
+				continue
+			}
+			return err
+		}
+		if err = os.Remove(file); err != nil {
+			logger.Logger.Errorf("删除嵌套压缩包 '%s' 失败: %v", file, err)
+		}
+	}
+	return nil
+}
+
+func (x *archiveExtractor) reject(name, reason string) {
+	logger.Logger.Warnf("跳过压缩包条目 %s: %s", name, reason)
+	x.rejected = append(x.rejected, rejectedEntry{Name: name, Reason: reason})
+}
+
+// writeEntry 校验并写出一个条目，所有格式共用。isUTF8 为 false 时按 GBK/GB18030 解码文件名
+func (x *archiveExtractor) writeEntry(dest, prefix, name string, isUTF8 bool, mode fs.FileMode, size, compressedSize int64, open func() (io.ReadCloser, error)) error {
+	if !isUTF8 {
+		decodedName, err := decodeFileName(name)
+		if err != nil {
+			logger.Logger.Errorf("GBK解码失败: %v", err)
+		} else {
+			name = decodedName
+		}
+	}
+
+	fpath := filepath.Join(dest, name)
+	if !strings.HasPrefix(fpath, filepath.Clean(dest)+string(os.PathSeparator)) {
+		return fmt.Errorf("非法文件路径: %s", prefix+name)
+	}
+
+	reason, err := x.guard.checkEntry(mode, size, compressedSize)
+	if err != nil {
+		return err
+	}
+	if reason != "" {
+		x.reject(prefix+name, reason)
+		return nil
+	}
+
+	if mode.IsDir() {
+		return os.MkdirAll(fpath, extractedDirMode)
+	}
+	if err = os.MkdirAll(filepath.Dir(fpath), extractedDirMode); err != nil {
+		return err
+	}
+
+	rc, err := open()
+	if err != nil {
+		return err
+	}
+	reason, err = x.guard.extractEntry(fpath, rc)
+	rc.Close()
+	if err != nil {
+		return err
+	}
+	if reason != "" {
+		x.reject(prefix+name, reason)
+		return nil
+	}
+	x.files = append(x.files, fpath)
+	return nil
+}
+
+func (x *archiveExtractor) extractZip(src, dest, prefix string) error {
+	r, err := zip.OpenReader(src)
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+
+	for _, f := range r.File {
+		// 0x800 标志表示文件名为UTF-8，否则多为旧版Windows压缩工具生成的GBK文件名
+		if err = x.writeEntry(dest, prefix, f.Name, f.Flags&0x800 != 0, f.Mode(), int64(f.UncompressedSize64), int64(f.CompressedSize64), f.Open); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (x *archiveExtractor) extractRar(src, dest, prefix string) error {
+	r, err := rardecode.OpenReader(src, "")
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+
+	for {
+		hdr, err := r.Next()
+		if errors.Is(err, io.EOF) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		size := hdr.UnPackedSize
+		if hdr.UnKnownSize {
+			size = 0
+		}
+		open := func() (io.ReadCloser, error) { return io.NopCloser(r), nil }
+		if err = x.writeEntry(dest, prefix, hdr.Name, false, hdr.Mode(), size, hdr.PackedSize, open); err != nil {
+			return err
+		}
+	}
+}
+
+// extractStream 处理 tar 及 gzip/bzip2/xz 压缩流，解压后是 tar 则按 tar 展开，否则作为单个文件写出
+func (x *archiveExtractor) extractStream(src, dest, prefix, format string) error {
+	f, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	compressed := &countingReader{r: f}
+	var r io.Reader = compressed
+	switch format {
+	case ArchiveFormatGzip:
+		gz, err := gzip.NewReader(compressed)
+		if err != nil {
+			return err
+		}
+		defer gz.Close()
+		r = gz
+	case ArchiveFormatBzip2:
+		r = bzip2.NewReader(compressed)
+	case ArchiveFormatXz:
+		if r, err = xz.NewReader(compressed); err != nil {
+			return err
+		}
+	}
+
+	streamSize := int64(-1) // tar 条目没有单独的压缩大小，压缩比按整个流检查
+	if format != ArchiveFormatTar {
+		r = x.guard.ratioReader(r, compressed)
+		br := bufio.NewReaderSize(r, 512)
+		header, _ := br.Peek(512)
+		r = br
+		if sniffArchiveFormat(header) != ArchiveFormatTar {
+			name := strings.TrimSuffix(filepath.Base(src), filepath.Ext(src))
+			open := func() (io.ReadCloser, error) { return io.NopCloser(r), nil }
+			return x.writeEntry(dest, prefix, name, true, extractedFileMode, 0, streamSize, open)
+		}
+	}
+	return x.extractTar(r, dest, prefix)
+}
+
+func (x *archiveExtractor) extractTar(r io.Reader, dest, prefix string) error {
+	tr := tar.NewReader(r)
+	for {
+		hdr, err := tr.Next()
+		if errors.Is(err, io.EOF) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		mode := hdr.FileInfo().Mode()
+		switch hdr.Typeflag {
+		case tar.TypeReg, tar.TypeDir, tar.TypeSymlink, tar.TypeChar, tar.TypeBlock, tar.TypeFifo:
+		case tar.TypeLink:
+			x.reject(prefix+hdr.Name, "不允许硬链接")
+			continue
+		default:
+			x.reject(prefix+hdr.Name, fmt.Sprintf("不支持的条目类型 %q", hdr.Typeflag))
+			continue
+		}
+
+		// PAX 格式的文件名为UTF-8，ustar/GNU 格式的文件名可能是GBK
+		_, isPAX := hdr.PAXRecords["path"]
+		open := func() (io.ReadCloser, error) { return io.NopCloser(tr), nil }
+		if err = x.writeEntry(dest, prefix, hdr.Name, isPAX, mode, hdr.Size, -1, open); err != nil {
+			return err
+		}
+	}
+}
@@ -0,0 +1,152 @@
+package handler
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"ningxia_backend/dao"
+	"ningxia_backend/pkg/conf"
+	"os"
+)
+
+const (
+	extractedFileMode = 0644 // 解压文件统一使用的权限，不沿用压缩包中的权限位
+	extractedDirMode  = 0755
+
+	ratioCheckMinSize = 1 << 20 // 小于 1MB 的条目不检查压缩比，避免误判小文本文件
+)
+
+// errArchiveLimit 压缩包整体超出限制，整个压缩包被拒绝
+var errArchiveLimit = errors.New("压缩包超出解压限制")
+
+// rejectedEntry 被跳过的压缩包条目及原因，随压缩包的内容寻址记录一起保存
+type rejectedEntry = dao.RejectedEntry
+
+// archiveLimits 解压资源限制，来自配置 unzip 节点
+type archiveLimits struct {
+	MaxTotalSize int64   // 解压后总大小
+	MaxEntries   int     // 条目数
+	MaxEntrySize int64   // 单个条目解压后大小
+	MaxRatio     float64 // 单个条目压缩比
+}
+
+func loadArchiveLimits() archiveLimits {
+	return archiveLimits{
+		MaxTotalSize: conf.Conf.GetInt64("unzip.maxTotalSize"),
+		MaxEntries:   conf.Conf.GetInt("unzip.maxEntries"),
+		MaxEntrySize: conf.Conf.GetInt64("unzip.maxEntrySize"),
+		MaxRatio:     conf.Conf.GetFloat64("unzip.maxRatio"),
+	}
+}
+
+// archiveGuard 在解压过程中累计条目数和已写入大小，检查每个条目
+type archiveGuard struct {
+	limits  archiveLimits
+	entries int
+	written int64
+}
+
+func newArchiveGuard() *archiveGuard {
+	return &archiveGuard{limits: loadArchiveLimits()}
+}
+
+// countingReader 统计已读取的字节数
+type countingReader struct {
+	r io.Reader
+	n int64
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.n += int64(n)
+	return n, err
+}
+
+// ratioReader 按已解压和已读取的压缩数据量检查整个压缩流的压缩比，用于 gzip/bzip2/xz
+type ratioReader struct {
+	r          io.Reader
+	compressed *countingReader
+	n          int64
+	maxRatio   float64
+}
+
+func (g *archiveGuard) ratioReader(r io.Reader, compressed *countingReader) io.Reader {
+	if g.limits.MaxRatio <= 0 {
+		return r
+	}
+	return &ratioReader{r: r, compressed: compressed, maxRatio: g.limits.MaxRatio}
+}
+
+func (rr *ratioReader) Read(p []byte) (int, error) {
+	n, err := rr.r.Read(p)
+	rr.n += int64(n)
+	if rr.n >= ratioCheckMinSize && float64(rr.n) > rr.maxRatio*float64(max(rr.compressed.n, 1)) {
+		return n, fmt.Errorf("%w: 压缩比超过限制 %.0f", errArchiveLimit, rr.maxRatio)
+	}
+	return n, err
+}
+
+// checkEntry 解压前检查条目，返回非空原因表示跳过该条目；超出整体限制时返回 errArchiveLimit
+func (g *archiveGuard) checkEntry(mode fs.FileMode, size, compressedSize int64) (string, error) {
+	g.entries++
+	if g.limits.MaxEntries > 0 && g.entries > g.limits.MaxEntries {
+		return "", fmt.Errorf("%w: 条目数超过 %d", errArchiveLimit, g.limits.MaxEntries)
+	}
+
+	switch {
+	case mode&fs.ModeSymlink != 0:
+		return "不允许符号链接", nil
+	case mode&(fs.ModeDevice|fs.ModeCharDevice|fs.ModeNamedPipe|fs.ModeSocket|fs.ModeIrregular) != 0:
+		return "不允许设备或特殊文件", nil
+	case mode.IsDir():
+		return "", nil
+	}
+
+	if g.limits.MaxEntrySize > 0 && size > g.limits.MaxEntrySize {
+		return fmt.Sprintf("解压后大小 %d 字节超过单文件限制 %d 字节", size, g.limits.MaxEntrySize), nil
+	}
+	// compressedSize 为负表示格式不提供单个条目的压缩大小，由 ratioReader 按整个流检查
+	if g.limits.MaxRatio > 0 && size >= ratioCheckMinSize && compressedSize >= 0 {
+		if compressedSize == 0 || float64(size)/float64(compressedSize) > g.limits.MaxRatio {
+			return fmt.Sprintf("压缩比超过限制 %.0f", g.limits.MaxRatio), nil
+		}
+	}
+	if g.limits.MaxTotalSize > 0 && g.written+size > g.limits.MaxTotalSize {
+		return "", fmt.Errorf("%w: 解压后总大小超过 %d 字节", errArchiveLimit, g.limits.MaxTotalSize)
+	}
+	return "", nil
+}
+
+// extractEntry 将条目内容写入 path，实际写入量按单文件和总大小限制截断检查，
+// 防止条目头中声明的大小与实际内容不符。返回非空原因表示条目被拒绝，已删除写出的文件
+func (g *archiveGuard) extractEntry(path string, r io.Reader) (string, error) {
+	limit := g.limits.MaxEntrySize
+	if g.limits.MaxTotalSize > 0 && (limit <= 0 || g.limits.MaxTotalSize-g.written < limit) {
+		limit = g.limits.MaxTotalSize - g.written
+	}
+
+	outFile, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, extractedFileMode)
+	if err != nil {
+		return "", err
+	}
+	if limit > 0 {
+		r = io.LimitReader(r, limit+1)
+	}
+	n, err := io.Copy(outFile, r)
+	outFile.Close()
+	if err != nil {
+		os.Remove(path)
+		return "", err
+	}
+
+	if limit > 0 && n > limit {
+		os.Remove(path)
+		if g.limits.MaxEntrySize > 0 && n > g.limits.MaxEntrySize {
+			return fmt.Sprintf("实际解压大小超过单文件限制 %d 字节", g.limits.MaxEntrySize), nil
+		}
+		return "", fmt.Errorf("%w: 解压后总大小超过 %d 字节", errArchiveLimit, g.limits.MaxTotalSize)
+	}
+	g.written += n
+	return "", nil
+}
@@ -0,0 +1,170 @@
+package handler
+
+import (
+	"bytes"
+	"fmt"
+	"github.com/pdfcpu/pdfcpu/pkg/api"
+	"github.com/pdfcpu/pdfcpu/pkg/pdfcpu/model"
+	"github.com/pdfcpu/pdfcpu/pkg/pdfcpu/types"
+	"mime"
+	"ningxia_backend/dao"
+	"ningxia_backend/pkg/logger"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// 附件与文档的关联关系（PDF/A-3 的 AFRelationship）
+const (
+	afRelationshipSource = "Source" // 生成报告所用的原始数据
+	afRelationshipData   = "Data"   // 报告内容对应的计算结果
+)
+
+// pdfAttachment 待嵌入PDF的附件
+type pdfAttachment struct {
+	Path         string // 服务器上的文件路径
+	Name         string // PDF中显示的附件名
+	Desc         string
+	Relationship string
+}
+
+// attachmentMIMETypes 常见输入文件的 MIME 类型，系统 MIME 表中可能没有
+var attachmentMIMETypes = map[string]string{
+	".xlsx": "application/vnd.openxmlformats-officedocument.spreadsheetml.sheet",
+	".xls":  "application/vnd.ms-excel",
+	".csv":  "text/csv",
+	".json": "application/json",
+	".zip":  "application/zip",
+}
+
+func attachmentMIMEType(name string) string {
+	ext := strings.ToLower(filepath.Ext(name))
+	if t, ok := attachmentMIMETypes[ext]; ok {
+		return t
+	}
+	if t, _, err := mime.ParseMediaType(mime.TypeByExtension(ext)); err == nil {
+		return t
+	}
+	return "application/octet-stream"
+}
+
+// reportAttachments 收集报告所用数据集中的原始输入文件和计算结果JSON，
+// 已被清理的输入文件会跳过，跳过的附件名随结果返回
+func reportAttachments(report *dao.Report) (attachments []pdfAttachment, skipped []string) {
+	inputFiles, err := resolveDatasetFiles(report.DatasetIDs)
+	if err != nil {
+		logger.Logger.Warnf("报告 %s 的数据集不可用，跳过原始输入文件: %v", report.Filename, err)
+		skipped = append(skipped, report.DatasetIDs...)
+	}
+	for _, file := range inputFiles {
+		if _, err = os.Stat(file.Path); err != nil {
+			logger.Logger.Warnf("报告 %s 的输入文件 %s 不可用，跳过附件: %v", report.Filename, file.Name, err)
+			skipped = append(skipped, filepath.Base(file.Name))
+			continue
+		}
+		attachments = append(attachments, pdfAttachment{Path: file.Path, Name: filepath.Base(file.Name), Desc: "原始输入文件: " + file.Role, Relationship: afRelationshipSource})
+	}
+
+	resultFile := filepath.Join(reportsBaseDir, report.Filename, reportResultFile)
+	if _, err := os.Stat(resultFile); err == nil {
+		attachments = append(attachments, pdfAttachment{Path: resultFile, Name: reportResultFile, Desc: "计算结果", Relationship: afRelationshipData})
+	} else {
+		logger.Logger.Warnf("报告 %s 没有保存计算结果，跳过附件: %v", report.Filename, err)
+		skipped = append(skipped, reportResultFile)
+	}
+	return attachments, skipped
+}
+
+// embedAttachments 使用 pdfcpu 将文件作为附件嵌入PDF，同名附件自动加序号区分。
+// 按 PDF/A-3 的要求，附件注明 MIME 类型和关联关系，并登记到目录的 /AF 数组
+func embedAttachments(pdf []byte, attachments []pdfAttachment) ([]byte, error) {
+	if len(attachments) == 0 {
+		return nil, fmt.Errorf("没有可嵌入的附件")
+	}
+
+	cnf := model.NewDefaultConfiguration()
+	cnf.Cmd = model.ADDATTACHMENTS
+	ctx, err := api.ReadValidateAndOptimize(bytes.NewReader(pdf), cnf)
+	if err != nil {
+		return nil, fmt.Errorf("读取PDF失败: %w", err)
+	}
+	if err = ctx.LocateNameTree("EmbeddedFiles", true); err != nil {
+		return nil, fmt.Errorf("读取附件目录失败: %w", err)
+	}
+	rootDict, err := ctx.Catalog()
+	if err != nil {
+		return nil, fmt.Errorf("读取PDF目录失败: %w", err)
+	}
+	af, err := ctx.DereferenceArray(rootDict["AF"])
+	if err != nil {
+		return nil, fmt.Errorf("读取PDF目录失败: %w", err)
+	}
+
+	usedNames := make(map[string]int)
+	for _, attachment := range attachments {
+		id := attachment.Name
+		if n := usedNames[attachment.Name]; n > 0 {
+			ext := filepath.Ext(attachment.Name)
+			id = fmt.Sprintf("%s_%d%s", attachment.Name[:len(attachment.Name)-len(ext)], n, ext)
+		}
+		usedNames[attachment.Name]++
+
+		ir, err := addAssociatedFile(ctx, attachment, id)
+		if err != nil {
+			return nil, fmt.Errorf("嵌入附件 %s 失败: %w", attachment.Path, err)
+		}
+		af = append(af, *ir)
+	}
+	rootDict.Update("AF", af)
+
+	var out bytes.Buffer
+	if err = api.Write(ctx, &out, cnf); err != nil {
+		return nil, fmt.Errorf("写出PDF失败: %w", err)
+	}
+	return out.Bytes(), nil
+}
+
+// addAssociatedFile 写入附件的嵌入文件流和文件说明字典，并加入 EmbeddedFiles 名称树
+func addAssociatedFile(ctx *model.Context, attachment pdfAttachment, id string) (*types.IndirectRef, error) {
+	content, err := os.ReadFile(attachment.Path)
+	if err != nil {
+		return nil, err
+	}
+	fi, err := os.Stat(attachment.Path)
+	if err != nil {
+		return nil, err
+	}
+
+	sd, err := ctx.NewStreamDictForBuf(content)
+	if err != nil {
+		return nil, err
+	}
+	sd.InsertName("Type", "EmbeddedFile")
+	sd.InsertName("Subtype", attachmentMIMEType(attachment.Name))
+	params := types.NewDict()
+	params.InsertInt("Size", len(content))
+	params.Insert("ModDate", types.StringLiteral(types.DateString(fi.ModTime())))
+	sd.Insert("Params", params)
+	if err = sd.Encode(); err != nil {
+		return nil, err
+	}
+	streamRef, err := ctx.IndRefForNewObject(*sd)
+	if err != nil {
+		return nil, err
+	}
+
+	d, err := ctx.NewFileSpecDict(id, id, attachment.Desc, *streamRef)
+	if err != nil {
+		return nil, err
+	}
+	d.InsertName("AFRelationship", attachment.Relationship)
+	ir, err := ctx.IndRefForNewObject(d)
+	if err != nil {
+		return nil, err
+	}
+	m := model.NameMap{id: []types.Dict{d}}
+	if err = ctx.Names["EmbeddedFiles"].Add(ctx.XRefTable, id, *ir, m, []string{"F", "UF"}); err != nil {
+		return nil, err
+	}
+	return ir, nil
+}
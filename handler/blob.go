@@ -0,0 +1,264 @@
+package handler
+
+import (
+	"errors"
+	"fmt"
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+	"io"
+	"io/fs"
+	"net/http"
+	"ningxia_backend/dao"
+	"ningxia_backend/pkg/logger"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// 同一内容的入库、链接和清理需要串行
+var blobLocks sync.Map
+
+func lockBlob(sha string) func() {
+	mu, _ := blobLocks.LoadOrStore(sha, &sync.Mutex{})
+	mu.(*sync.Mutex).Lock()
+	return mu.(*sync.Mutex).Unlock
+}
+
+func blobPath(sha string) string {
+	return filepath.Join(blobDir, sha[:2], sha)
+}
+
+// blobExtractDir 压缩包的解压结果只保存一份，数据集中的文件链接到这里
+func blobExtractDir(sha string) string {
+	return blobPath(sha) + "_extracted"
+}
+
+// archiveExtractDirName 数据集中压缩包解压目录的名称，例如 data.zip、data.tar.gz -> data_extracted
+func archiveExtractDirName(fileName string) string {
+	baseName := strings.TrimSuffix(fileName, filepath.Ext(fileName))
+	return strings.TrimSuffix(baseName, ".tar") + "_extracted"
+}
+
+// loadBlob 按摘要查找已入库的文件，记录存在但文件已丢失时视为不存在
+func loadBlob(sha string) (*dao.Blob, error) {
+	var blob dao.Blob
+	if err := dao.GetDB().Where("sha256 = ?", sha).First(&blob).Error; err != nil {
+		return nil, err
+	}
+	if _, err := os.Stat(blobPath(sha)); err != nil {
+		return nil, gorm.ErrRecordNotFound
+	}
+	return &blob, nil
+}
+
+// useBlob 查找已入库的文件并更新使用时间，清理任务在上传期限内不会再删除它。调用方需持有 lockBlob(sha)
+func useBlob(sha string) (*dao.Blob, error) {
+	blob, err := loadBlob(sha)
+	if err != nil {
+		return nil, err
+	}
+	blob.LastUsedAt = time.Now()
+	if err = dao.GetDB().Model(blob).Update("last_used_at", blob.LastUsedAt).Error; err != nil {
+		return nil, err
+	}
+	return blob, nil
+}
+
+// touchBlob 引用已入库的文件：在锁内查找并更新使用时间，避免在登记到数据集之前被清理
+func touchBlob(sha string) (*dao.Blob, error) {
+	unlock := lockBlob(sha)
+	defer unlock()
+	return useBlob(sha)
+}
+
+// ingestBlob 将已接收的文件 src 移入内容寻址存储，压缩包同时解压。内容已存在时删除 src 并返回已有记录，existed 为 true。
+// 解压或登记失败时把文件移回 src，调用方可以重试或自行清理
+func ingestBlob(src, fileName, sha string, archive bool) (blob *dao.Blob, existed bool, err error) {
+	unlock := lockBlob(sha)
+	defer unlock()
+
+	if blob, err = useBlob(sha); err == nil {
+		if err = os.Remove(src); err != nil {
+			logger.Logger.Errorf("删除重复上传的文件 %s 失败: %v", src, err)
+		}
+		return blob, true, nil
+	}
+	if !errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, false, err
+	}
+
+	info, err := os.Stat(src)
+	if err != nil {
+		return nil, false, err
+	}
+	path := blobPath(sha)
+	if err = os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return nil, false, err
+	}
+	if err = os.Rename(src, path); err != nil {
+		return nil, false, err
+	}
+	defer func() {
+		if err == nil {
+			return
+		}
+		os.RemoveAll(blobExtractDir(sha))
+		if restoreErr := os.Rename(path, src); restoreErr != nil {
+			logger.Logger.Errorf("文件 '%s' 移回 %s 失败: %v", fileName, src, restoreErr)
+			os.Remove(path)
+		}
+	}()
+
+	blob = &dao.Blob{SHA256: sha, Size: info.Size(), FileName: fileName, Archive: archive, LastUsedAt: time.Now()}
+	if archive {
+		extractDir := blobExtractDir(sha)
+		os.RemoveAll(extractDir) // 清理上次失败留下的解压结果
+		if err = os.MkdirAll(extractDir, extractedDirMode); err != nil {
+			return nil, false, err
+		}
+		_, rejected, err := extractArchive(path, extractDir)
+		if err != nil {
+			logger.Logger.Errorf("解压文件 '%s' 失败: %v", fileName, err)
+			if errors.Is(err, errArchiveLimit) || errors.Is(err, errUnsupportedArchive) {
+				return nil, false, fmt.Errorf("解压文件 '%s' 失败: %w", fileName, err)
+			}
+			return nil, false, fmt.Errorf("解压文件 '%s' 失败", fileName)
+		}
+		blob.Rejected = rejected
+	}
+	if err = dao.GetDB().Save(blob).Error; err != nil {
+		return nil, false, err
+	}
+	logger.Logger.Infof("文件 %s 入库，SHA-256 %s", fileName, sha)
+	return blob, false, nil
+}
+
+// linkOrCopy 优先使用硬链接，不在同一文件系统时复制
+func linkOrCopy(src, dest string) error {
+	if err := os.Link(src, dest); err == nil {
+		return nil
+	}
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	out, err := os.OpenFile(dest, os.O_WRONLY|os.O_CREATE|os.O_EXCL, extractedFileMode)
+	if err != nil {
+		return err
+	}
+	if _, err = io.Copy(out, in); err != nil {
+		out.Close()
+		return err
+	}
+	return out.Close()
+}
+
+// linkBlobIntoDataset 把已入库的文件链接到数据集目录并登记，压缩包链接其解压结果。返回数据集中的文件路径
+func linkBlobIntoDataset(dataset *dao.Dataset, role, fileName string, blob *dao.Blob) ([]string, error) {
+	unlock := lockBlob(blob.SHA256)
+	defer unlock()
+
+	// 先标记使用时间，避免清理任务在数据集保存前删除该文件；文件已被清理时返回 gorm.ErrRecordNotFound
+	if _, err := useBlob(blob.SHA256); err != nil {
+		return nil, err
+	}
+
+	var paths []string
+	if !blob.Archive {
+		dest := filepath.Join(dataset.Dir, fileName)
+		if err := linkOrCopy(blobPath(blob.SHA256), dest); err != nil {
+			return nil, err
+		}
+		paths = append(paths, dest)
+	} else {
+		srcDir := blobExtractDir(blob.SHA256)
+		destDir := filepath.Join(dataset.Dir, archiveExtractDirName(fileName))
+		err := filepath.WalkDir(srcDir, func(path string, d fs.DirEntry, err error) error {
+			if err != nil {
+				return err
+			}
+			rel, err := filepath.Rel(srcDir, path)
+			if err != nil {
+				return err
+			}
+			dest := filepath.Join(destDir, rel)
+			if d.IsDir() {
+				return os.MkdirAll(dest, extractedDirMode)
+			}
+			if err = linkOrCopy(path, dest); err != nil {
+				return err
+			}
+			paths = append(paths, dest)
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	for _, path := range paths {
+		if err := addDatasetFile(dataset, role, path); err != nil {
+			return nil, err
+		}
+		dataset.Files[len(dataset.Files)-1].BlobSHA256 = blob.SHA256
+	}
+	return paths, nil
+}
+
+// retainBlobs 数据集保存后增加其引用文件的引用计数
+func retainBlobs(files []dao.DatasetFile) {
+	for sha := range datasetBlobs(files) {
+		if err := dao.GetDB().Model(&dao.Blob{}).Where("sha256 = ?", sha).
+			Update("ref_count", gorm.Expr("ref_count + 1")).Error; err != nil {
+			logger.Logger.Errorf("增加文件 %s 引用计数失败: %v", sha, err)
+		}
+	}
+}
+
+// releaseBlobs 删除数据集前减少其引用文件的引用计数，文件本身由清理任务删除
+func releaseBlobs(files []dao.DatasetFile) {
+	for sha := range datasetBlobs(files) {
+		if err := dao.GetDB().Model(&dao.Blob{}).Where("sha256 = ? AND ref_count > 0", sha).
+			Update("ref_count", gorm.Expr("ref_count - 1")).Error; err != nil {
+			logger.Logger.Errorf("减少文件 %s 引用计数失败: %v", sha, err)
+		}
+	}
+}
+
+func datasetBlobs(files []dao.DatasetFile) map[string]bool {
+	shas := make(map[string]bool)
+	for _, file := range files {
+		if file.BlobSHA256 != "" {
+			shas[file.BlobSHA256] = true
+		}
+	}
+	return shas
+}
+
+// blobDuplicate 告知客户端该文件此前已上传过
+func blobDuplicate(blob *dao.Blob) gin.H {
+	return gin.H{
+		"sha256":     blob.SHA256,
+		"fileName":   blob.FileName,
+		"uploadedAt": blob.CreatedAt,
+		"message":    fmt.Sprintf("该文件已于 %s 上传过", blob.CreatedAt.Format("2006-01-02 15:04:05")),
+	}
+}
+
+// GetBlobHandler 按 SHA-256 查询文件是否已上传，客户端据此决定是否跳过发送
+func GetBlobHandler(c *gin.Context) {
+	blob, err := loadBlob(strings.ToLower(c.Param("sha256")))
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "文件未上传过", "exists": false})
+			return
+		}
+		logger.Logger.Errorf("查询文件失败: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "数据库查询失败"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"exists": true, "blob": blob, "duplicate": blobDuplicate(blob)})
+}
@@ -0,0 +1,271 @@
+package handler
+
+import (
+	"errors"
+	"fmt"
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+	"net/http"
+	"ningxia_backend/dao"
+	"ningxia_backend/pkg/logger"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const campaignDateLayout = "2006-01-02"
+
+func validateCampaign(campaign *dao.Campaign) error {
+	if campaign.Year < 2000 || campaign.Year > 2100 {
+		return fmt.Errorf("年份应在 2000 到 2100 之间")
+	}
+	var start, end time.Time
+	var err error
+	if campaign.StartDate != "" {
+		if start, err = time.Parse(campaignDateLayout, campaign.StartDate); err != nil {
+			return fmt.Errorf("开始日期格式应为 YYYY-MM-DD")
+		}
+	}
+	if campaign.EndDate != "" {
+		if end, err = time.Parse(campaignDateLayout, campaign.EndDate); err != nil {
+			return fmt.Errorf("结束日期格式应为 YYYY-MM-DD")
+		}
+	}
+	if !start.IsZero() && !end.IsZero() && end.Before(start) {
+		return fmt.Errorf("结束日期不能早于开始日期")
+	}
+	return nil
+}
+
+// loadCampaign 按路径参数 id 获取批次，未找到或出错时直接写出应答
+func loadCampaign(c *gin.Context) (*dao.Campaign, bool) {
+	id, err := strconv.ParseUint(c.Param("id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "无效的批次ID"})
+		return nil, false
+	}
+	var campaign dao.Campaign
+	if err = dao.GetDB().First(&campaign, id).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "抽检批次不存在"})
+			return nil, false
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "数据库查询失败"})
+		return nil, false
+	}
+	return &campaign, true
+}
+
+// parseCampaignRef 解析上传或生成报告时附带的批次ID，为空表示不关联批次
+func parseCampaignRef(value string) (*uint, error) {
+	if value == "" {
+		return nil, nil
+	}
+	id, err := strconv.ParseUint(value, 10, 64)
+	if err != nil {
+		return nil, fmt.Errorf("无效的批次ID")
+	}
+	if err = dao.GetDB().First(&dao.Campaign{}, id).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, fmt.Errorf("抽检批次 %d 不存在", id)
+		}
+		return nil, err
+	}
+	campaignID := uint(id)
+	return &campaignID, nil
+}
+
+// datasetsCampaign 数据集共同所属的批次，数据集未关联或分属不同批次时返回 nil
+func datasetsCampaign(ids []string) *uint {
+	var datasets []dao.Dataset
+	if len(ids) == 0 || dao.GetDB().Where("id IN ?", ids).Find(&datasets).Error != nil {
+		return nil
+	}
+	var campaignID *uint
+	for _, dataset := range datasets {
+		if dataset.CampaignID == nil || (campaignID != nil && *campaignID != *dataset.CampaignID) {
+			return nil
+		}
+		campaignID = dataset.CampaignID
+	}
+	return campaignID
+}
+
+// reportCampaign 生成报告时使用的批次：请求中指定的批次优先，否则沿用数据集共同所属的批次。
+// 两者都没有时返回 nil，指定的批次不存在时返回错误
+func reportCampaign(campaignID *uint, datasetIDs []string) (*dao.Campaign, error) {
+	if campaignID == nil {
+		campaignID = datasetsCampaign(datasetIDs)
+	}
+	if campaignID == nil {
+		return nil, nil
+	}
+	var campaign dao.Campaign
+	if err := dao.GetDB().First(&campaign, *campaignID).Error; err != nil {
+		return nil, fmt.Errorf("抽检批次 %d 不存在", *campaignID)
+	}
+	return &campaign, nil
+}
+
+func GetCampaigns(c *gin.Context) {
+	query := dao.GetDB().Order("year DESC, id DESC")
+	if year := c.Query("year"); year != "" {
+		query = query.Where("year = ?", year)
+	}
+	campaigns := make([]dao.Campaign, 0)
+	if err := query.Find(&campaigns).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "数据库查询失败"})
+		return
+	}
+	c.JSON(http.StatusOK, campaigns)
+}
+
+// GetCampaign 批次视图：上传的数据集、当年适用的省厅和交通部指标、由该批次生成的全部报告
+func GetCampaign(c *gin.Context) {
+	campaign, ok := loadCampaign(c)
+	if !ok {
+		return
+	}
+
+	datasets := make([]dao.Dataset, 0)
+	if err := dao.GetDB().Preload("Files").Where("campaign_id = ?", campaign.ID).Order("created_at").Find(&datasets).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "数据库查询失败"})
+		return
+	}
+	reports := make([]dao.Report, 0)
+	if err := dao.GetDB().Where("campaign_id = ?", campaign.ID).Order("created_at DESC").Find(&reports).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "数据库查询失败"})
+		return
+	}
+
+	// 指标配置缺失时返回 null，不影响查看批次
+	settings, err := takeSettingsSnapshot(campaign.Year)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "数据库查询失败"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"campaign":        campaign,
+		"datasets":        datasets,
+		"reports":         reports,
+		"provinceSetting": settings.Province,
+		"plan":            settings.Plan,
+		"nationalSetting": settings.National,
+		"milestone":       settings.Milestone,
+	})
+}
+
+func CreateCampaign(c *gin.Context) {
+	var campaign dao.Campaign
+	if err := c.ShouldBindJSON(&campaign); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	campaign.ID = 0
+	if err := validateCampaign(&campaign); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if err := dao.GetDB().Create(&campaign).Error; err != nil {
+		logger.Logger.Errorf("保存抽检批次失败: %v", err)
+		c.JSON(http.StatusConflict, gin.H{"error": fmt.Sprintf("抽检批次'%s'已存在", campaign.Name)})
+		return
+	}
+	c.JSON(http.StatusOK, campaign)
+}
+
+func UpdateCampaign(c *gin.Context) {
+	campaign, ok := loadCampaign(c)
+	if !ok {
+		return
+	}
+	var req dao.Campaign
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	req.ID = campaign.ID
+	req.CreatedAt = campaign.CreatedAt
+	if err := validateCampaign(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if err := dao.GetDB().Save(&req).Error; err != nil {
+		logger.Logger.Errorf("更新抽检批次失败: %v", err)
+		c.JSON(http.StatusConflict, gin.H{"error": fmt.Sprintf("抽检批次'%s'已存在", req.Name)})
+		return
+	}
+	c.JSON(http.StatusOK, req)
+}
+
+// DeleteCampaign 删除批次，其数据集和报告保留，仅解除关联
+func DeleteCampaign(c *gin.Context) {
+	campaign, ok := loadCampaign(c)
+	if !ok {
+		return
+	}
+	err := dao.GetDB().Transaction(func(tx *gorm.DB) error {
+		if err := tx.Model(&dao.Dataset{}).Where("campaign_id = ?", campaign.ID).Update("campaign_id", nil).Error; err != nil {
+			return err
+		}
+		if err := tx.Model(&dao.Report{}).Where("campaign_id = ?", campaign.ID).Update("campaign_id", nil).Error; err != nil {
+			return err
+		}
+		if err := tx.Where("campaign_id = ?", campaign.ID).Delete(&dao.Disease{}).Error; err != nil {
+			return err
+		}
+		return tx.Delete(campaign).Error
+	})
+	if err != nil {
+		logger.Logger.Errorf("删除抽检批次失败: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "删除抽检批次失败"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": fmt.Sprintf("抽检批次'%s'已删除", campaign.Name)})
+}
+
+// AttachDatasetToCampaign 将已上传的数据集归入批次
+func AttachDatasetToCampaign(c *gin.Context) {
+	campaign, ok := loadCampaign(c)
+	if !ok {
+		return
+	}
+	result := dao.GetDB().Model(&dao.Dataset{}).Where("id = ?", c.Param("datasetId")).Update("campaign_id", campaign.ID)
+	if result.Error != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "数据库更新失败"})
+		return
+	}
+	if result.RowsAffected == 0 {
+		c.JSON(http.StatusNotFound, gin.H{"error": "数据集不存在"})
+		return
+	}
+	// 病害记录随数据集归入新批次
+	if dataset, err := loadDataset(c.Param("datasetId")); err == nil {
+		importDatasetDiseases(dataset)
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "数据集已关联到抽检批次"})
+}
+
+// AttachReportToCampaign 将已生成的报告归入批次，filename 可带或不带 .md 扩展名
+func AttachReportToCampaign(c *gin.Context) {
+	campaign, ok := loadCampaign(c)
+	if !ok {
+		return
+	}
+	baseName := strings.TrimSuffix(c.Param("filename"), ".md")
+	if _, _, ok = parseReportBaseName(baseName); !ok {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "无效的文件名格式"})
+		return
+	}
+	report, err := loadReportRecord(baseName)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": fmt.Sprintf("报告文件 '%s' 未找到", baseName)})
+		return
+	}
+	if err = dao.GetDB().Model(report).Update("campaign_id", campaign.ID).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "数据库更新失败"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "报告已关联到抽检批次"})
+}
@@ -0,0 +1,98 @@
+package handler
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"gorm.io/gorm"
+	"ningxia_backend/dao"
+	"ningxia_backend/pkg/conf"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// parseReportBaseName 从报告基础名（例如: 高速公路...报告_1745680397）解析报告类型和时间戳
+func parseReportBaseName(baseName string) (string, int64, bool) {
+	lastUnderscoreIndex := strings.LastIndex(baseName, "_")
+	if lastUnderscoreIndex == -1 || lastUnderscoreIndex == len(baseName)-1 {
+		return "", 0, false
+	}
+	timestamp, err := strconv.ParseInt(baseName[lastUnderscoreIndex+1:], 10, 64)
+	if err != nil {
+		return "", 0, false
+	}
+	for rtConst, namePrefix := range ReportNameMap {
+		if baseName[:lastUnderscoreIndex] == namePrefix {
+			return rtConst, timestamp, true
+		}
+	}
+	return "", 0, false
+}
+
+// templateVersion 以模板内容摘要作为模板版本号，模板改动后版本号随之变化
+func templateVersion(content []byte) string {
+	sum := sha256.Sum256(content)
+	return hex.EncodeToString(sum[:])[:12]
+}
+
+// contentHash 计算报告内容的 SHA-256，用于核验报告真伪
+func contentHash(content []byte) string {
+	sum := sha256.Sum256(content)
+	return hex.EncodeToString(sum[:])
+}
+
+// reportMdPath 报告 Markdown 文件路径
+func reportMdPath(baseName string) string {
+	return filepath.Join(reportsBaseDir, baseName, baseName+".md")
+}
+
+// loadReportRecord 获取报告目录记录，历史报告没有记录时按文件名补建
+func loadReportRecord(baseName string) (*dao.Report, error) {
+	var report dao.Report
+	err := dao.GetDB().Where("filename = ?", baseName).First(&report).Error
+	if err == nil {
+		if report.ContentHash == "" {
+			// 早期登记的报告没有内容摘要，按当前文件补齐
+			content, err := os.ReadFile(reportMdPath(baseName))
+			if err != nil {
+				return nil, err
+			}
+			report.ContentHash = contentHash(content)
+			if report.Status == "" {
+				report.Status = ReportStatusActive
+			}
+			if err = dao.GetDB().Save(&report).Error; err != nil {
+				return nil, err
+			}
+		}
+		return &report, nil
+	}
+	if !errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, err
+	}
+
+	reportType, timestamp, ok := parseReportBaseName(baseName)
+	if !ok {
+		return nil, fmt.Errorf("无法从报告名 '%s' 识别出报告类型", baseName)
+	}
+	content, err := os.ReadFile(reportMdPath(baseName))
+	if err != nil {
+		return nil, err
+	}
+	report = dao.Report{
+		Filename:    baseName,
+		ReportType:  reportType,
+		Year:        time.Unix(timestamp, 0).Year(),
+		Unit:        conf.Conf.GetString("report.unit"),
+		ContentHash: contentHash(content),
+		Status:      ReportStatusActive,
+	}
+	if err = dao.GetDB().Create(&report).Error; err != nil {
+		return nil, err
+	}
+	return &report, nil
+}
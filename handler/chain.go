@@ -0,0 +1,150 @@
+package handler
+
+import (
+	"errors"
+	"fmt"
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+	"net/http"
+	"ningxia_backend/dao"
+	"ningxia_backend/pkg/logger"
+	"ningxia_backend/pkg/stake"
+	"strconv"
+	"strings"
+)
+
+// chainBreakView 断链及其断链方程，如 K12+300=K12+250
+type chainBreakView struct {
+	dao.ChainBreak
+	Equation string  `json:"equation"`
+	Length   float64 `json:"length"` // 正数为长链，负数为短链（米）
+}
+
+func newChainBreakView(b dao.ChainBreak) chainBreakView {
+	sb := stake.Break{Back: stake.Stake(b.Back), Ahead: stake.Stake(b.Ahead)}
+	return chainBreakView{ChainBreak: b, Equation: sb.String(), Length: float64(sb.Length())}
+}
+
+func loadChainBreaks(db *gorm.DB, routeCode string) ([]dao.ChainBreak, error) {
+	breaks := make([]dao.ChainBreak, 0)
+	err := db.Where("route_code = ?", routeCode).Order("back").Find(&breaks).Error
+	return breaks, err
+}
+
+func buildChain(breaks []dao.ChainBreak) (*stake.Chain, error) {
+	chain := make([]stake.Break, 0, len(breaks))
+	for _, b := range breaks {
+		chain = append(chain, stake.Break{Back: stake.Stake(b.Back), Ahead: stake.Stake(b.Ahead)})
+	}
+	return stake.NewChain(chain)
+}
+
+// routeChain 路线的断链表，没有登记断链时桩号即实际里程
+func routeChain(routeCode string) (*stake.Chain, error) {
+	breaks, err := loadChainBreaks(dao.GetDB(), routeCode)
+	if err != nil {
+		return nil, err
+	}
+	return buildChain(breaks)
+}
+
+// GetChainBreaks 断链列表，可按 routeCode 过滤
+func GetChainBreaks(c *gin.Context) {
+	db := dao.GetDB().Order("route_code").Order("back")
+	if routeCode := strings.ToUpper(strings.TrimSpace(c.Query("routeCode"))); routeCode != "" {
+		db = db.Where("route_code = ?", routeCode)
+	}
+	var breaks []dao.ChainBreak
+	if err := db.Find(&breaks).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "数据库查询失败"})
+		return
+	}
+	views := make([]chainBreakView, 0, len(breaks))
+	for _, b := range breaks {
+		views = append(views, newChainBreakView(b))
+	}
+	c.JSON(http.StatusOK, views)
+}
+
+// CreateChainBreak 登记断链，桩号按 K123+456 形式填写，并与该路线已有断链一起校验顺序
+func CreateChainBreak(c *gin.Context) {
+	var req struct {
+		RouteCode string `json:"routeCode" binding:"required"`
+		Back      string `json:"back" binding:"required"`
+		Ahead     string `json:"ahead" binding:"required"`
+		Note      string `json:"note"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	back, err := stake.Parse(req.Back)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "断前" + err.Error()})
+		return
+	}
+	ahead, err := stake.Parse(req.Ahead)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "断后" + err.Error()})
+		return
+	}
+	if back == ahead {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "断前桩号与断后桩号相同，不构成断链"})
+		return
+	}
+
+	b := dao.ChainBreak{
+		RouteCode: strings.ToUpper(strings.TrimSpace(req.RouteCode)),
+		Back:      back.Meters(),
+		Ahead:     ahead.Meters(),
+		Note:      req.Note,
+	}
+	err = dao.GetDB().Transaction(func(tx *gorm.DB) error {
+		breaks, err := loadChainBreaks(tx, b.RouteCode)
+		if err != nil {
+			return err
+		}
+		i := 0
+		for i < len(breaks) && breaks[i].Back < b.Back {
+			i++
+		}
+		breaks = append(breaks[:i], append([]dao.ChainBreak{b}, breaks[i:]...)...)
+		if _, err = buildChain(breaks); err != nil {
+			return err
+		}
+		return tx.Create(&b).Error
+	})
+	if err != nil {
+		if errors.Is(err, stake.ErrChainOrder) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		logger.Logger.Errorf("保存断链失败: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "保存断链失败"})
+		return
+	}
+	c.JSON(http.StatusOK, newChainBreakView(b))
+}
+
+func DeleteChainBreak(c *gin.Context) {
+	id, err := strconv.ParseUint(c.Param("id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "无效的断链ID"})
+		return
+	}
+	var b dao.ChainBreak
+	if err = dao.GetDB().First(&b, id).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "断链不存在"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "数据库查询失败"})
+		return
+	}
+	if err = dao.GetDB().Delete(&b).Error; err != nil {
+		logger.Logger.Errorf("删除断链失败: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "删除断链失败"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": fmt.Sprintf("断链 %s %s 已删除", b.RouteCode, newChainBreakView(b).Equation)})
+}
@@ -0,0 +1,429 @@
+package handler
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+	"io"
+	"net/http"
+	"ningxia_backend/dao"
+	"ningxia_backend/pkg/logger"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// 同一上传会话的分片写入需要串行
+var uploadLocks sync.Map
+
+func lockUpload(id string) func() {
+	mu, _ := uploadLocks.LoadOrStore(id, &sync.Mutex{})
+	mu.(*sync.Mutex).Lock()
+	return mu.(*sync.Mutex).Unlock
+}
+
+func newOpaqueID() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+func chunkPartPath(id string) string {
+	return filepath.Join(chunkUploadDir, id+".part")
+}
+
+func fileSHA256(path string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	h := sha256.New()
+	if _, err = io.Copy(h, f); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// loadUploadSession 按ID获取上传会话，未找到时直接写出 404
+func loadUploadSession(c *gin.Context, id string) (*dao.UploadSession, bool) {
+	var session dao.UploadSession
+	if err := dao.GetDB().Where("id = ?", id).First(&session).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "上传会话不存在"})
+			return nil, false
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "数据库查询失败"})
+		return nil, false
+	}
+	return &session, true
+}
+
+func uploadProgress(session *dao.UploadSession) gin.H {
+	percent := 100.0
+	if session.Size > 0 {
+		percent = float64(session.Received) * 100 / float64(session.Size)
+	}
+	return gin.H{
+		"uploadId":   session.ID,
+		"fieldName":  session.FieldName,
+		"fileName":   session.FileName,
+		"size":       session.Size,
+		"chunkSize":  session.ChunkSize,
+		"received":   session.Received,
+		"nextOffset": session.Received,
+		"percent":    percent,
+		"status":     session.Status,
+	}
+}
+
+// InitUploadHandler 创建分片上传会话
+func InitUploadHandler(c *gin.Context) {
+	var req struct {
+		FieldName string `json:"fieldName" binding:"required"`
+		FileName  string `json:"fileName" binding:"required"`
+		Size      int64  `json:"size" binding:"required"`
+		SHA256    string `json:"sha256" binding:"required"`
+		ChunkSize int64  `json:"chunkSize"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if !slices.Contains(zipFieldNames, req.FieldName) && !slices.Contains(excelFieldNames, req.FieldName) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("未知的文件字段 '%s'", req.FieldName)})
+		return
+	}
+	fileName := filepath.Base(filepath.Clean(req.FileName))
+	if fileName != req.FileName || fileName == "." || fileName == ".." {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "无效的文件名"})
+		return
+	}
+	if req.Size <= 0 || req.Size > maxFileSize {
+		c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("文件大小应在 1 到 %d 字节之间", maxFileSize)})
+		return
+	}
+	if _, err := hex.DecodeString(req.SHA256); err != nil || len(req.SHA256) != sha256.Size*2 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "sha256 格式有误"})
+		return
+	}
+	if req.ChunkSize == 0 {
+		req.ChunkSize = defaultChunkSize
+	}
+	if req.ChunkSize < 0 || req.ChunkSize > maxChunkSize {
+		c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("分片大小不能超过 %d 字节", maxChunkSize)})
+		return
+	}
+
+	id, err := newOpaqueID()
+	if err != nil {
+		logger.Logger.Errorf("生成上传会话ID失败: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "创建上传会话失败"})
+		return
+	}
+	session := dao.UploadSession{
+		ID:        id,
+		FieldName: req.FieldName,
+		FileName:  fileName,
+		Size:      req.Size,
+		SHA256:    strings.ToLower(req.SHA256),
+		ChunkSize: req.ChunkSize,
+		Status:    UploadStatusUploading,
+	}
+
+	// 相同内容已上传过时不需要再发送分片，客户端可直接调用 complete
+	blob, err := touchBlob(session.SHA256)
+	existed := err == nil && blob.Size == session.Size
+	if existed {
+		session.Received = session.Size
+	} else {
+		if err = os.MkdirAll(chunkUploadDir, 0755); err != nil {
+			logger.Logger.Errorf("创建分片目录失败: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "创建上传会话失败"})
+			return
+		}
+		if err = os.WriteFile(chunkPartPath(id), nil, 0644); err != nil {
+			logger.Logger.Errorf("创建分片文件失败: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "创建上传会话失败"})
+			return
+		}
+	}
+
+	if err = dao.GetDB().Create(&session).Error; err != nil {
+		logger.Logger.Errorf("保存上传会话失败: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "创建上传会话失败"})
+		return
+	}
+	logger.Logger.Infof("创建分片上传会话 %s: %s (%d 字节)", id, fileName, req.Size)
+	progress := uploadProgress(&session)
+	if existed {
+		progress["duplicate"] = blobDuplicate(blob)
+	}
+	c.JSON(http.StatusOK, progress)
+}
+
+// UploadChunkHandler 写入一个分片，offset 必须等于已接收字节数；请求头 X-Chunk-SHA256 为分片校验值
+func UploadChunkHandler(c *gin.Context) {
+	id := c.Param("id")
+	offset, err := strconv.ParseInt(c.Query("offset"), 10, 64)
+	if err != nil || offset < 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "无效的 offset 参数"})
+		return
+	}
+	chunkHash := strings.ToLower(c.GetHeader("X-Chunk-SHA256"))
+	if chunkHash == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "缺少分片校验值 X-Chunk-SHA256"})
+		return
+	}
+
+	unlock := lockUpload(id)
+	defer unlock()
+
+	session, ok := loadUploadSession(c, id)
+	if !ok {
+		return
+	}
+	if session.Status != UploadStatusUploading {
+		c.JSON(http.StatusConflict, gin.H{"error": "上传会话已完成"})
+		return
+	}
+	if offset > session.Received {
+		c.JSON(http.StatusConflict, gin.H{"error": "分片不连续，请从 nextOffset 继续上传", "nextOffset": session.Received})
+		return
+	}
+
+	chunk, err := io.ReadAll(http.MaxBytesReader(c.Writer, c.Request.Body, session.ChunkSize))
+	if err != nil {
+		c.JSON(http.StatusRequestEntityTooLarge, gin.H{"error": fmt.Sprintf("分片不能超过 %d 字节", session.ChunkSize)})
+		return
+	}
+	if len(chunk) == 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "分片内容为空"})
+		return
+	}
+	if offset+int64(len(chunk)) > session.Size {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "分片超出文件大小"})
+		return
+	}
+	sum := sha256.Sum256(chunk)
+	if hex.EncodeToString(sum[:]) != chunkHash {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "分片校验失败，请重新上传该分片", "nextOffset": session.Received})
+		return
+	}
+	if offset+int64(len(chunk)) <= session.Received {
+		// 客户端重发了已接收的分片，直接返回当前进度
+		c.JSON(http.StatusOK, uploadProgress(session))
+		return
+	}
+
+	f, err := os.OpenFile(chunkPartPath(id), os.O_WRONLY, 0644)
+	if err != nil {
+		logger.Logger.Errorf("打开分片文件 %s 失败: %v", id, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "写入分片失败"})
+		return
+	}
+	_, err = f.WriteAt(chunk, offset)
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		logger.Logger.Errorf("写入分片 %s@%d 失败: %v", id, offset, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "写入分片失败"})
+		return
+	}
+
+	session.Received = offset + int64(len(chunk))
+	if err = dao.GetDB().Model(session).Update("received", session.Received).Error; err != nil {
+		logger.Logger.Errorf("更新上传进度 %s 失败: %v", id, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "更新上传进度失败"})
+		return
+	}
+	c.JSON(http.StatusOK, uploadProgress(session))
+}
+
+// GetUploadProgressHandler 查询上传进度，断线后客户端从 nextOffset 继续上传
+func GetUploadProgressHandler(c *gin.Context) {
+	session, ok := loadUploadSession(c, c.Param("id"))
+	if !ok {
+		return
+	}
+	c.JSON(http.StatusOK, uploadProgress(session))
+}
+
+// CompleteUploadHandler 校验整个文件的 SHA-256，并按 /api/unzip 的规则处理文件后登记到数据集
+func CompleteUploadHandler(c *gin.Context) {
+	id := c.Param("id")
+	unlock := lockUpload(id)
+	defer unlock()
+
+	session, ok := loadUploadSession(c, id)
+	if !ok {
+		return
+	}
+	if session.Status != UploadStatusUploading {
+		c.JSON(http.StatusConflict, gin.H{"error": "上传会话已完成"})
+		return
+	}
+	if session.Received != session.Size {
+		c.JSON(http.StatusConflict, gin.H{"error": "文件尚未上传完成", "nextOffset": session.Received})
+		return
+	}
+
+	// 指定 datasetId 时把文件加入已有数据集，否则新建数据集，新数据集可用 campaignId 归入抽检批次
+	var dataset *dao.Dataset
+	var err error
+	saved := false
+	isNewDataset := c.Query("datasetId") == ""
+	if isNewDataset {
+		requestTempDir, err := os.MkdirTemp(uploadDir, "req-*-files")
+		if err != nil {
+			logger.Logger.Errorf("创建请求临时目录失败: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "创建临时目录失败"})
+			return
+		}
+		// 新数据集保存之前出错时删除其目录
+		defer func() {
+			if !saved {
+				os.RemoveAll(requestTempDir)
+			}
+		}()
+		if dataset, err = newDataset(requestTempDir); err != nil {
+			logger.Logger.Errorf("创建数据集失败: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "创建数据集失败"})
+			return
+		}
+		if dataset.CampaignID, err = parseCampaignRef(c.Query("campaignId")); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+	} else {
+		if dataset, err = loadDataset(c.Query("datasetId")); err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				c.JSON(http.StatusNotFound, gin.H{"error": "数据集不存在"})
+				return
+			}
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "数据库查询失败"})
+			return
+		}
+	}
+	existingFileCount := len(dataset.Files)
+
+	destPath := filepath.Join(dataset.Dir, session.FileName)
+	if slices.Contains(zipFieldNames, session.FieldName) {
+		destPath = filepath.Join(dataset.Dir, archiveExtractDirName(session.FileName))
+	}
+	if _, err = os.Stat(destPath); err == nil {
+		c.JSON(http.StatusConflict, gin.H{"error": fmt.Sprintf("数据集中已存在文件 '%s'", session.FileName)})
+		return
+	}
+
+	// 已上传过相同内容时直接使用，否则校验分片文件后入库
+	blob, err := touchBlob(session.SHA256)
+	existed := err == nil
+	if !existed {
+		partPath := chunkPartPath(id)
+		hash, err := fileSHA256(partPath)
+		if err != nil {
+			logger.Logger.Errorf("计算文件 %s 摘要失败: %v", partPath, err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "校验文件失败"})
+			return
+		}
+		if hash != session.SHA256 {
+			// 整体校验失败时清空已接收内容，需要重新上传
+			_ = os.Truncate(partPath, 0)
+			dao.GetDB().Model(session).Update("received", 0)
+			c.JSON(http.StatusBadRequest, gin.H{"error": "文件 SHA-256 校验失败，请重新上传"})
+			return
+		}
+		if blob, existed, err = ingestBlob(partPath, session.FileName, hash, slices.Contains(zipFieldNames, session.FieldName)); err != nil {
+			logger.Logger.Errorf("文件 '%s' 入库失败: %v", session.FileName, err)
+			status := extractErrorStatus(err)
+			if status == http.StatusInternalServerError {
+				// 分片文件已移回，可以重新提交完成请求
+				err = fmt.Errorf("保存文件 '%s' 失败", session.FileName)
+			} else {
+				// 压缩包本身不可用，清空已接收内容，需要重新上传
+				_ = os.Truncate(partPath, 0)
+				dao.GetDB().Model(session).Update("received", 0)
+			}
+			c.JSON(status, gin.H{"error": err.Error()})
+			return
+		}
+	} else {
+		os.Remove(chunkPartPath(id))
+	}
+
+	paths, err := linkBlobIntoDataset(dataset, session.FieldName, session.FileName, blob)
+	if err != nil {
+		logger.Logger.Errorf("登记文件 '%s' 失败: %v", session.FileName, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": fmt.Sprintf("登记文件 '%s' 失败", session.FileName)})
+		return
+	}
+	if isNewDataset {
+		err = dao.GetDB().Create(dataset).Error
+	} else {
+		err = dao.GetDB().Create(dataset.Files[existingFileCount:]).Error
+	}
+	if err != nil {
+		logger.Logger.Errorf("保存数据集失败: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "保存数据集失败"})
+		return
+	}
+	saved = true
+	retainBlobs(dataset.Files[existingFileCount:])
+	if slices.Contains([]string{diseaseDataRole, previousYearDiseaseRole}, session.FieldName) {
+		importDatasetDiseases(dataset)
+	}
+
+	if err = dao.GetDB().Model(session).Update("status", UploadStatusCompleted).Error; err != nil {
+		logger.Logger.Errorf("更新上传会话 %s 状态失败: %v", id, err)
+	}
+	// 已完成的会话不再接收分片，释放其互斥锁
+	uploadLocks.Delete(id)
+	logger.Logger.Infof("分片上传 %s 完成: %s", id, session.FileName)
+	validations := make([]*excelValidation, 0)
+	if slices.Contains(excelFieldNames, session.FieldName) {
+		validations = append(validations, validateExcelFile(session.FieldName, paths[0]))
+	}
+	rejected := blob.Rejected
+	if rejected == nil {
+		rejected = make([]rejectedEntry, 0)
+	}
+	resp := gin.H{"datasetId": dataset.ID, "files": dataset.Files[existingFileCount:], "validation": validations, "rejected": rejected}
+	if existed {
+		resp["duplicate"] = blobDuplicate(blob)
+	}
+	c.JSON(http.StatusOK, resp)
+}
+
+// AbortUploadHandler 放弃上传，删除已接收的分片
+func AbortUploadHandler(c *gin.Context) {
+	id := c.Param("id")
+	unlock := lockUpload(id)
+	defer unlock()
+
+	session, ok := loadUploadSession(c, id)
+	if !ok {
+		return
+	}
+	if err := os.Remove(chunkPartPath(id)); err != nil && !os.IsNotExist(err) {
+		logger.Logger.Errorf("删除分片文件 %s 失败: %v", id, err)
+	}
+	if err := dao.GetDB().Delete(session).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "删除上传会话失败"})
+		return
+	}
+	uploadLocks.Delete(id)
+	c.JSON(http.StatusOK, gin.H{"message": "上传已取消"})
+}
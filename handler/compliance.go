@@ -0,0 +1,323 @@
+package handler
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/gin-gonic/gin"
+	"math"
+	"net/http"
+	"ningxia_backend/dao"
+	"ningxia_backend/pkg/conf"
+	"ningxia_backend/pkg/logger"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+const (
+	complianceStatusPass     = "pass"
+	complianceStatusFail     = "fail"
+	complianceStatusNoTarget = "noTarget" // 指标未配置或为 0
+	complianceStatusNoData   = "noData"   // 计算结果中没有对应的实际值
+	complianceStatusNoKey    = "noKey"    // 未配置该指标对应的计算结果字段
+
+	complianceSourceProvince = "province"
+	complianceSourceNational = "national"
+
+	regionEast    = "east"
+	regionCentral = "central"
+	regionWest    = "west"
+
+	// 模板占位符为 COMPLIANCE_<指标>_TARGET / _ACTUAL / _GAP / _STATUS
+	compliancePlaceholderPrefix = "COMPLIANCE_"
+)
+
+var (
+	complianceStatusText = map[string]string{
+		complianceStatusPass:     "达标",
+		complianceStatusFail:     "未达标",
+		complianceStatusNoTarget: "未设定指标",
+		complianceStatusNoData:   "无数据",
+		complianceStatusNoKey:    "未配置结果字段",
+	}
+	regionNames = map[string]string{regionEast: "东部", regionCentral: "中部", regionWest: "西部"}
+)
+
+// complianceIndicator 一项考核指标：实际值取自计算结果的 ResultKey，目标值取自省厅或交通部配置。
+// ResultKey 可在配置 compliance.resultKeys.<Key> 中覆盖
+type complianceIndicator struct {
+	Key         string
+	Name        string
+	Source      string
+	ResultKey   string
+	ReportTypes []string
+	Target      func(settings *dao.SettingsSnapshot, region string) float64
+}
+
+// complianceItem 单项指标的达标情况，Gap 为实际值减目标值，负数表示差距
+type complianceItem struct {
+	Key    string   `json:"key"`
+	Name   string   `json:"name"`
+	Source string   `json:"source"`
+	Target *float64 `json:"target"`
+	Actual *float64 `json:"actual"`
+	Gap    *float64 `json:"gap"`
+	Status string   `json:"status"`
+}
+
+type complianceResult struct {
+	ReportType string           `json:"reportType"`
+	Year       int              `json:"year"`
+	Plan       string           `json:"plan"`
+	Region     string           `json:"region"`
+	Passed     int              `json:"passed"`
+	Failed     int              `json:"failed"`
+	Items      []complianceItem `json:"items"`
+}
+
+func provinceTarget(field func(*dao.ProvinceSetting) float64) func(*dao.SettingsSnapshot, string) float64 {
+	return func(settings *dao.SettingsSnapshot, _ string) float64 {
+		if settings == nil || settings.Province == nil {
+			return 0
+		}
+		return field(settings.Province)
+	}
+}
+
+func nationalTarget(field func(*dao.NationalSetting) float64) func(*dao.SettingsSnapshot, string) float64 {
+	return func(settings *dao.SettingsSnapshot, _ string) float64 {
+		if settings == nil || settings.National == nil {
+			return 0
+		}
+		return field(settings.National)
+	}
+}
+
+// regionalTarget 按所在区域选取东/中/西部指标
+func regionalTarget(east, central, west func(*dao.NationalSetting) float64) func(*dao.SettingsSnapshot, string) float64 {
+	return func(settings *dao.SettingsSnapshot, region string) float64 {
+		if settings == nil || settings.National == nil {
+			return 0
+		}
+		switch region {
+		case regionEast:
+			return east(settings.National)
+		case regionCentral:
+			return central(settings.National)
+		default:
+			return west(settings.National)
+		}
+	}
+}
+
+var (
+	trunkReportTypes  = []string{ReportTypeExpressway, ReportTypeNationalProvincial, ReportTypeRural}
+	reviewReportTypes = []string{ReportTypeMaintenance, ReportTypeConstruction}
+)
+
+// complianceIndicators 计算程序按 ResultKey 输出实际值（百分比或指数），未输出的指标记为无数据。
+// 目前计算程序只输出高速公路和国省道的平均PQI（FWALLROADPQI、GSPQIGROAD、GSPQISROAD），
+// 其余指标的结果字段待计算程序提供后在配置中指定，未指定时记为未配置结果字段
+var complianceIndicators = []complianceIndicator{
+	{"EXPRESSWAY_PQI", "高速公路PQI", complianceSourceProvince, "FWALLROADPQI", []string{ReportTypeExpressway},
+		provinceTarget(func(s *dao.ProvinceSetting) float64 { return s.Expressway })},
+	{"NATIONAL_HIGHWAY_PQI", "普通国道PQI", complianceSourceProvince, "GSPQIGROAD", []string{ReportTypeNationalProvincial},
+		provinceTarget(func(s *dao.ProvinceSetting) float64 { return s.NationalHighway })},
+	{"PROVINCIAL_HIGHWAY_PQI", "普通省道PQI", complianceSourceProvince, "GSPQISROAD", []string{ReportTypeNationalProvincial},
+		provinceTarget(func(s *dao.ProvinceSetting) float64 { return s.ProvincialHighway })},
+	{"RURAL_ROAD_PQI", "农村公路PQI", complianceSourceProvince, "", []string{ReportTypeRural},
+		provinceTarget(func(s *dao.ProvinceSetting) float64 { return s.RuralRoad })},
+
+	{"MQI_EXCELLENT", "MQI优良率", complianceSourceNational, "", trunkReportTypes,
+		nationalTarget(func(s *dao.NationalSetting) float64 { return s.MQIExcellent })},
+	{"PQI_EXCELLENT", "PQI优良率", complianceSourceNational, "", trunkReportTypes,
+		nationalTarget(func(s *dao.NationalSetting) float64 { return s.PQIExcellent })},
+	{"BRIDGE_RATE", "一、二类桥梁比例", complianceSourceNational, "", trunkReportTypes,
+		nationalTarget(func(s *dao.NationalSetting) float64 { return s.BridgeRate })},
+	{"RECYCLE_RATE", "旧料循环利用率", complianceSourceNational, "", reviewReportTypes,
+		nationalTarget(func(s *dao.NationalSetting) float64 { return s.RecycleRate })},
+	{"NATIONAL_MQI", "普通国道MQI（区域目标）", complianceSourceNational, "", []string{ReportTypeNationalProvincial},
+		regionalTarget(func(s *dao.NationalSetting) float64 { return s.NationalMQIEast },
+			func(s *dao.NationalSetting) float64 { return s.NationalMQICentral },
+			func(s *dao.NationalSetting) float64 { return s.NationalMQIWest })},
+	{"NATIONAL_PQI", "普通国道PQI（区域目标）", complianceSourceNational, "GSPQIGROAD", []string{ReportTypeNationalProvincial},
+		regionalTarget(func(s *dao.NationalSetting) float64 { return s.NationalPQIEast },
+			func(s *dao.NationalSetting) float64 { return s.NationalPQICentral },
+			func(s *dao.NationalSetting) float64 { return s.NationalPQIWest })},
+	{"PROVINCIAL_MQI", "普通省道MQI（区域目标）", complianceSourceNational, "", []string{ReportTypeNationalProvincial},
+		regionalTarget(func(s *dao.NationalSetting) float64 { return s.ProvincialMQIEast },
+			func(s *dao.NationalSetting) float64 { return s.ProvincialMQICentral },
+			func(s *dao.NationalSetting) float64 { return s.ProvincialMQIWest })},
+	{"PROVINCIAL_PQI", "普通省道PQI（区域目标）", complianceSourceNational, "GSPQISROAD", []string{ReportTypeNationalProvincial},
+		regionalTarget(func(s *dao.NationalSetting) float64 { return s.ProvincialPQIEast },
+			func(s *dao.NationalSetting) float64 { return s.ProvincialPQICentral },
+			func(s *dao.NationalSetting) float64 { return s.ProvincialPQIWest })},
+	{"RURAL_MQI", "农村公路MQI", complianceSourceNational, "", []string{ReportTypeRural},
+		nationalTarget(func(s *dao.NationalSetting) float64 { return s.RuralMQI })},
+}
+
+// complianceRegion 本省所属区域，宁夏为西部
+func complianceRegion() string {
+	region := conf.Conf.GetString("compliance.region")
+	if _, ok := regionNames[region]; !ok {
+		logger.Logger.Warnf("compliance.region 配置 '%s' 无效，按西部处理", region)
+		return regionWest
+	}
+	return region
+}
+
+// resultKey 指标对应的计算结果字段，配置优先
+func (i complianceIndicator) resultKey() string {
+	if key := strings.TrimSpace(conf.Conf.GetString("compliance.resultKeys." + i.Key)); key != "" {
+		return key
+	}
+	return i.ResultKey
+}
+
+// resultNumber 读取计算结果中的数值，计算程序可能输出数字或数字字符串
+func resultNumber(data map[string]any, key string) (float64, bool) {
+	switch v := data[key].(type) {
+	case float64:
+		return v, true
+	case int:
+		return float64(v), true
+	case string:
+		f, err := strconv.ParseFloat(strings.TrimSpace(strings.TrimSuffix(v, "%")), 64)
+		return f, err == nil
+	}
+	return 0, false
+}
+
+func round2(v float64) float64 {
+	return math.Round(v*100) / 100
+}
+
+// evaluateCompliance 将计算结果与配置副本中的目标值逐项比较，实际值不低于目标值为达标
+func evaluateCompliance(reportType string, data map[string]any, settings *dao.SettingsSnapshot) *complianceResult {
+	result := &complianceResult{ReportType: reportType, Region: complianceRegion(), Items: make([]complianceItem, 0)}
+	if settings != nil {
+		result.Year, result.Plan = settings.Year, settings.Plan
+	}
+	for _, indicator := range complianceIndicators {
+		if !slices.Contains(indicator.ReportTypes, reportType) {
+			continue
+		}
+		item := complianceItem{Key: indicator.Key, Name: indicator.Name, Source: indicator.Source}
+		target := indicator.Target(settings, result.Region)
+		if target > 0 {
+			item.Target = &target
+		}
+		resultKey := indicator.resultKey()
+		if actual, ok := resultNumber(data, resultKey); ok && resultKey != "" {
+			item.Actual = &actual
+		}
+		switch {
+		case item.Target == nil:
+			item.Status = complianceStatusNoTarget
+		case resultKey == "":
+			item.Status = complianceStatusNoKey
+		case item.Actual == nil:
+			item.Status = complianceStatusNoData
+		default:
+			gap := round2(*item.Actual - *item.Target)
+			item.Gap = &gap
+			if *item.Actual >= *item.Target {
+				item.Status = complianceStatusPass
+				result.Passed++
+			} else {
+				item.Status = complianceStatusFail
+				result.Failed++
+			}
+		}
+		result.Items = append(result.Items, item)
+	}
+	return result
+}
+
+func formatNumber(v *float64) string {
+	if v == nil {
+		return "-"
+	}
+	return strconv.FormatFloat(*v, 'f', -1, 64)
+}
+
+// compliancePlaceholders 生成模板占位符，未设定的目标值和实际值显示为 -
+func compliancePlaceholders(result *complianceResult) map[string]string {
+	placeholders := make(map[string]string)
+	for _, item := range result.Items {
+		prefix := compliancePlaceholderPrefix + item.Key
+		placeholders[prefix+"_TARGET"] = formatNumber(item.Target)
+		placeholders[prefix+"_ACTUAL"] = formatNumber(item.Actual)
+		placeholders[prefix+"_STATUS"] = complianceStatusText[item.Status]
+		gap := "-"
+		if item.Gap != nil {
+			gap = fmt.Sprintf("%.2f", *item.Gap)
+		}
+		placeholders[prefix+"_GAP"] = gap
+	}
+	placeholders[compliancePlaceholderPrefix+"PASSED"] = strconv.Itoa(result.Passed)
+	placeholders[compliancePlaceholderPrefix+"FAILED"] = strconv.Itoa(result.Failed)
+	return placeholders
+}
+
+// GetReportComplianceHandler 按报告保存的计算结果和生成时的配置副本评估达标情况
+func GetReportComplianceHandler(c *gin.Context) {
+	baseName := strings.TrimSuffix(c.Param("filename"), ".md")
+	reportType, _, ok := parseReportBaseName(baseName)
+	if !ok {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "无效的文件名格式"})
+		return
+	}
+	report, err := loadReportRecord(baseName)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": fmt.Sprintf("报告文件 '%s' 未找到", baseName)})
+		return
+	}
+
+	js, err := os.ReadFile(filepath.Join(reportsBaseDir, baseName, reportResultFile))
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "该报告没有保存计算结果"})
+		return
+	}
+	var data map[string]any
+	if err = json.Unmarshal(js, &data); err != nil {
+		logger.Logger.Errorf("解析报告 %s 计算结果失败: %v", baseName, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "解析计算结果失败"})
+		return
+	}
+
+	settings := report.SettingsSnapshot
+	if settings == nil {
+		// 早期报告没有配置副本，使用当前配置
+		if settings, err = takeSettingsSnapshot(report.Year); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "读取指标配置失败"})
+			return
+		}
+	}
+	c.JSON(http.StatusOK, evaluateCompliance(reportType, data, settings))
+}
+
+// EvaluateComplianceHandler 对任意计算结果按指定年份的当前配置评估达标情况
+func EvaluateComplianceHandler(c *gin.Context) {
+	var req struct {
+		ReportType string         `json:"reportType" binding:"required"`
+		Year       int            `json:"year" binding:"required"`
+		Results    map[string]any `json:"results" binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if _, ok := ReportNameMap[req.ReportType]; !ok {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "报告类型有误"})
+		return
+	}
+	settings, err := takeSettingsSnapshot(req.Year)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "读取指标配置失败"})
+		return
+	}
+	c.JSON(http.StatusOK, evaluateCompliance(req.ReportType, req.Results, settings))
+}
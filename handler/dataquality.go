@@ -0,0 +1,389 @@
+package handler
+
+import (
+	"cmp"
+	"errors"
+	"fmt"
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+	"maps"
+	"net/http"
+	"ningxia_backend/dao"
+	"ningxia_backend/pkg/conf"
+	"ningxia_backend/pkg/logger"
+	"ningxia_backend/pkg/stake"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strings"
+)
+
+// 数据质量问题的严重程度：error 阻止生成报告，warning 只提示
+const (
+	QualitySeverityError   = "error"
+	QualitySeverityWarning = "warning"
+)
+
+// 数据质量问题分类
+const (
+	QualityUnknownRoute     = "unknownRoute"     // 路线不在路网登记中
+	QualityOutOfExtent      = "outOfExtent"      // 桩号超出路线登记范围
+	QualityDuplicate        = "duplicate"        // 同方向、同车道起止桩号完全相同的重复路段
+	QualityOverlap          = "overlap"          // 路段部分重叠
+	QualityCoverageGap      = "coverageGap"      // 登记里程中未覆盖的部分
+	QualityInvalidDirection = "invalidDirection" // 方向写法无效
+	QualityRegistryEmpty    = "registryEmpty"    // 路网登记为空，无法核对路线和桩号
+)
+
+// defaultQualitySeverity 各类问题的默认严重程度，可在配置文件 dataQuality.severity 节点中按分类覆盖
+var defaultQualitySeverity = map[string]string{
+	QualityUnknownRoute:     QualitySeverityError,
+	QualityOutOfExtent:      QualitySeverityError,
+	QualityDuplicate:        QualitySeverityWarning, // 各表的方向、车道列确认前只提示
+	QualityOverlap:          QualitySeverityWarning,
+	QualityCoverageGap:      QualitySeverityWarning,
+	QualityInvalidDirection: QualitySeverityError,
+	QualityRegistryEmpty:    QualitySeverityWarning,
+}
+
+var (
+	// qualitySegmentRoles 按起止桩号记录路段的上传字段
+	qualitySegmentRoles = []string{"managementDetailFile", "unitLevelDetailFile", "roadConditionFile", "firstInspectionExcel", "secondInspectionExcel"}
+	// qualityPointRoles 按单个桩号记录位置的上传字段
+	qualityPointRoles = []string{"diseaseDataExcel"}
+	// qualityCoverageRoles 应覆盖路线全部登记里程的上传字段，抽检数据不检查覆盖
+	qualityCoverageRoles = []string{"unitLevelDetailFile", "roadConditionFile"}
+)
+
+// qualityGapTolerance 小于该长度（米）的间隙视为桩号取整误差
+const qualityGapTolerance = 1
+
+func qualitySeverity(category string) string {
+	key := "dataQuality.severity." + category
+	if severity := conf.Conf.GetString(key); severity == QualitySeverityError || severity == QualitySeverityWarning {
+		return severity
+	}
+	return defaultQualitySeverity[category]
+}
+
+type qualityIssue struct {
+	Severity  string `json:"severity"`
+	Category  string `json:"category"`
+	Role      string `json:"role,omitempty"`
+	File      string `json:"file,omitempty"`
+	Sheet     string `json:"sheet,omitempty"`
+	Row       int    `json:"row,omitempty"`
+	RouteCode string `json:"routeCode,omitempty"`
+	Message   string `json:"message"`
+}
+
+// qualityReport 数据质量检查结果，Issues 最多返回 maxExcelIssues 条，计数包含全部问题
+type qualityReport struct {
+	Blocking   bool           `json:"blocking"` // 存在 error 级问题
+	Errors     int            `json:"errors"`
+	Warnings   int            `json:"warnings"`
+	Categories map[string]int `json:"categories"`
+	Truncated  bool           `json:"truncated,omitempty"`
+	Issues     []qualityIssue `json:"issues"`
+}
+
+func (r *qualityReport) add(issue qualityIssue) {
+	issue.Severity = qualitySeverity(issue.Category)
+	r.Categories[issue.Category]++
+	if issue.Severity == QualitySeverityError {
+		r.Errors++
+		r.Blocking = true
+	} else {
+		r.Warnings++
+	}
+	if len(r.Issues) >= maxExcelIssues {
+		r.Truncated = true
+		return
+	}
+	r.Issues = append(r.Issues, issue)
+}
+
+// routeRegistry 路网登记，按路线编号分组
+type routeRegistry map[string][]dao.Road
+
+// loadRouteRegistry 载入路网登记，未填路线编号的旧数据待补全，不参与检查
+func loadRouteRegistry() (routeRegistry, error) {
+	var roads []dao.Road
+	if err := dao.GetDB().Where("route_code <> ''").Find(&roads).Error; err != nil {
+		return nil, err
+	}
+	registry := make(routeRegistry)
+	for _, road := range roads {
+		registry[road.RouteCode] = append(registry[road.RouteCode], road)
+	}
+	return registry, nil
+}
+
+// extent 路线在该方向上登记的桩号范围。优先使用同方向和双向登记的路段，
+// 路线只登记了另一方向时使用全部路段
+func (r routeRegistry) extent(routeCode, direction string) []stake.Segment {
+	var matched, all []stake.Segment
+	for _, road := range r[routeCode] {
+		segment := stake.NewSegment(stake.FromKm(road.StartStake), stake.FromKm(road.EndStake))
+		all = append(all, segment)
+		if direction == RoadDirectionBoth || road.Direction == direction || road.Direction == RoadDirectionBoth {
+			matched = append(matched, segment)
+		}
+	}
+	if len(matched) == 0 {
+		matched = all
+	}
+	return stake.Union(matched)
+}
+
+// qualitySegment 数据行中的路段，Row 为所在行号，Distance 为按断链换算的实际里程区间
+type qualitySegment struct {
+	stake.Segment
+	Distance   stake.Segment
+	Row        int
+	candidates []stake.Segment
+}
+
+// qualityChains 检查过程中按路线缓存的断链表，断链登记有误时按没有断链处理
+type qualityChains map[string]*stake.Chain
+
+func (q qualityChains) get(routeCode string) *stake.Chain {
+	if chain, ok := q[routeCode]; ok {
+		return chain
+	}
+	chain, err := routeChain(routeCode)
+	if err != nil {
+		logger.Logger.Errorf("读取路线 %s 的断链失败，按没有断链检查: %v", routeCode, err)
+		chain, _ = stake.NewChain(nil)
+	}
+	q[routeCode] = chain
+	return chain
+}
+
+// chainCandidates 桩号区间可能对应的实际里程区间。长链范围内的桩号出现两次，候选按长度从短到长、
+// 起点从前到后排列；桩号落在短链跳过的部分时按桩号本身计算
+func chainCandidates(chain *stake.Chain, segment stake.Segment) []stake.Segment {
+	var candidates []stake.Segment
+	for _, start := range chain.Distances(segment.Start) {
+		for _, end := range chain.Distances(segment.End) {
+			if end >= start {
+				candidates = append(candidates, stake.Segment{Start: stake.Stake(start), End: stake.Stake(end)})
+			}
+		}
+	}
+	if len(candidates) == 0 {
+		return []stake.Segment{segment}
+	}
+	sort.SliceStable(candidates, func(i, j int) bool {
+		if candidates[i].Length() != candidates[j].Length() {
+			return candidates[i].Length() < candidates[j].Length()
+		}
+		return candidates[i].Start < candidates[j].Start
+	})
+	return candidates
+}
+
+// placeSegments 按行序确定每个路段的实际里程：长链范围内的路段优先取不与前面路段重叠的候选，
+// 重复出现的桩号因此分别落在长链的前后两段上
+func placeSegments(segments []qualitySegment) {
+	var placed []stake.Segment
+	for i := range segments {
+		s := &segments[i]
+		s.Distance = s.candidates[0]
+		if len(s.candidates) > 1 {
+			for _, candidate := range s.candidates {
+				if !slices.ContainsFunc(placed, func(p stake.Segment) bool {
+					_, overlap := stake.Overlap(p, candidate)
+					return overlap || p == candidate
+				}) {
+					s.Distance = candidate
+					break
+				}
+			}
+		}
+		placed = append(placed, s.Distance)
+	}
+}
+
+// checkDataQuality 核对数据集文件与路网登记：路线是否登记、桩号是否在登记范围内、
+// 路段是否重复或重叠、全线数据是否覆盖登记里程，以及方向写法是否有效
+func checkDataQuality(files []dao.DatasetFile) (*qualityReport, error) {
+	report := &qualityReport{Categories: make(map[string]int), Issues: make([]qualityIssue, 0)}
+	registry, err := loadRouteRegistry()
+	if err != nil {
+		return nil, err
+	}
+	if len(registry) == 0 {
+		report.add(qualityIssue{Category: QualityRegistryEmpty, Message: "路网登记为空，未核对路线编号、桩号范围和覆盖情况"})
+	}
+
+	chains := make(qualityChains)
+	for _, file := range files {
+		point := slices.Contains(qualityPointRoles, file.Role)
+		if !point && !slices.Contains(qualitySegmentRoles, file.Role) {
+			continue
+		}
+		if ext := strings.ToLower(filepath.Ext(file.Path)); ext != ".xlsx" && ext != ".xlsm" {
+			continue
+		}
+		rows, err := readExcelRows(file.Role, file.Path)
+		if err != nil {
+			return nil, fmt.Errorf("读取 %s 失败: %w", file.Name, err)
+		}
+		checkFileQuality(report, registry, chains, file, rows, point)
+	}
+	return report, nil
+}
+
+// qualityGroup 比较重复、重叠和覆盖的分组：路线、方向、车道
+type qualityGroup struct {
+	RouteCode string
+	Direction string
+	Lane      string
+}
+
+func checkFileQuality(report *qualityReport, registry routeRegistry, chains qualityChains, file dao.DatasetFile, rows []excelRow, point bool) {
+	newIssue := func(category string, row excelRow, routeCode, message string) qualityIssue {
+		return qualityIssue{Category: category, Role: file.Role, File: file.Name, Sheet: row.Sheet, Row: row.Row, RouteCode: routeCode, Message: message}
+	}
+	unknownRoutes := make(map[string][]int) // 未登记的路线 -> 所在行号
+	groups := make(map[qualityGroup][]qualitySegment)
+	var sheet string
+
+	for _, row := range rows {
+		sheet = row.Sheet
+		routeCode := strings.ToUpper(row.Cells["路线编号"])
+		if routeCode == "" {
+			continue
+		}
+		rawDirection := row.Cells["方向"]
+		direction, ok := roadDirections[rawDirection]
+		if !ok {
+			// 方向不明的行无法与同方向的路段比较，不再做其余检查
+			report.add(newIssue(QualityInvalidDirection, row, routeCode, fmt.Sprintf("方向'%s'无效，应为上行、下行或双向", rawDirection)))
+			continue
+		}
+
+		var segment stake.Segment
+		if point {
+			s, err := stake.Parse(row.Cells["桩号"])
+			if err != nil {
+				continue // 桩号格式在上传校验时已经报告
+			}
+			segment = stake.Segment{Start: s, End: s}
+		} else {
+			start, err1 := stake.Parse(row.Cells["起点桩号"])
+			end, err2 := stake.Parse(row.Cells["终点桩号"])
+			if err1 != nil || err2 != nil {
+				continue
+			}
+			segment = stake.NewSegment(start, end)
+		}
+
+		// 路网登记为空时只检查重复和重叠
+		if len(registry) > 0 {
+			if _, ok := registry[routeCode]; !ok {
+				unknownRoutes[routeCode] = append(unknownRoutes[routeCode], row.Row)
+				continue
+			}
+			extent := registry.extent(routeCode, direction)
+			if !slices.ContainsFunc(extent, func(e stake.Segment) bool { return e.Contains(segment) }) {
+				ranges := make([]string, len(extent))
+				for i, e := range extent {
+					ranges[i] = e.String()
+				}
+				location := segment.String()
+				if point {
+					location = segment.Start.String()
+				}
+				report.add(newIssue(QualityOutOfExtent, row, routeCode, fmt.Sprintf("桩号 %s 超出路线登记范围 %s", location, strings.Join(ranges, "、"))))
+			}
+		}
+		if !point {
+			key := qualityGroup{RouteCode: routeCode, Direction: direction, Lane: row.Cells["车道"]}
+			candidates := chainCandidates(chains.get(routeCode), segment)
+			groups[key] = append(groups[key], qualitySegment{Segment: segment, Row: row.Row, candidates: candidates})
+		}
+	}
+
+	for _, routeCode := range slices.Sorted(maps.Keys(unknownRoutes)) {
+		rowNums := unknownRoutes[routeCode]
+		report.add(qualityIssue{
+			Category: QualityUnknownRoute, Role: file.Role, File: file.Name, Sheet: sheet, Row: rowNums[0], RouteCode: routeCode,
+			Message: fmt.Sprintf("路线 %s 不在路网登记中（共 %d 行）", routeCode, len(rowNums)),
+		})
+	}
+
+	keys := slices.SortedFunc(maps.Keys(groups), func(a, b qualityGroup) int {
+		return cmp.Or(cmp.Compare(a.RouteCode, b.RouteCode), cmp.Compare(a.Direction, b.Direction), cmp.Compare(a.Lane, b.Lane))
+	})
+	for _, key := range keys {
+		routeCode := key.RouteCode
+		chain := chains.get(routeCode)
+		toStakes := func(d stake.Segment) stake.Segment {
+			return stake.Segment{Start: chain.StakeAt(float64(d.Start)), End: chain.StakeAt(float64(d.End))}
+		}
+		segments := groups[key]
+		placeSegments(segments)
+		sort.SliceStable(segments, func(i, j int) bool { return segments[i].Distance.Start < segments[j].Distance.Start })
+		for i, a := range segments {
+			for _, b := range segments[i+1:] {
+				if b.Distance.Start >= a.Distance.End {
+					break
+				}
+				issue := qualityIssue{Role: file.Role, File: file.Name, Sheet: sheet, Row: b.Row, RouteCode: routeCode}
+				if a.Distance == b.Distance {
+					issue.Category = QualityDuplicate
+					issue.Message = fmt.Sprintf("路段 %s 与第 %d 行重复", b.Segment, a.Row)
+				} else if overlap, ok := stake.Overlap(a.Distance, b.Distance); ok {
+					issue.Category = QualityOverlap
+					issue.Message = fmt.Sprintf("路段 %s 与第 %d 行的 %s 重叠 %s", b.Segment, a.Row, a.Segment, toStakes(overlap))
+				} else {
+					continue
+				}
+				report.add(issue)
+			}
+		}
+
+		if !slices.Contains(qualityCoverageRoles, file.Role) || len(registry[routeCode]) == 0 {
+			continue
+		}
+		covered := make([]stake.Segment, len(segments))
+		for i, s := range segments {
+			covered[i] = s.Distance
+		}
+		for _, e := range registry.extent(routeCode, key.Direction) {
+			// 登记范围取最长的候选，终点在长链内时包含长链的后一段
+			extent := chainCandidates(chain, e)
+			for _, gap := range stake.Gaps(covered, extent[len(extent)-1]) {
+				if gap.Length() < qualityGapTolerance {
+					continue
+				}
+				report.add(qualityIssue{
+					Category: QualityCoverageGap, Role: file.Role, File: file.Name, Sheet: sheet, RouteCode: routeCode,
+					Message: fmt.Sprintf("登记里程中 %s（%s km）没有数据", toStakes(gap), formatKm(gap.Length()/1000)),
+				})
+			}
+		}
+	}
+}
+
+// GetDatasetQualityHandler 数据集的数据质量检查结果
+func GetDatasetQualityHandler(c *gin.Context) {
+	dataset, err := loadDataset(c.Param("id"))
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "数据集不存在"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "数据库查询失败"})
+		return
+	}
+	report, err := checkDataQuality(dataset.Files)
+	if err != nil {
+		logger.Logger.Errorf("数据质量检查失败: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "数据质量检查失败"})
+		return
+	}
+	c.JSON(http.StatusOK, report)
+}
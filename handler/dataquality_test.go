@@ -0,0 +1,195 @@
+package handler
+
+import (
+	"github.com/spf13/viper"
+	"maps"
+	"ningxia_backend/dao"
+	"ningxia_backend/pkg/conf"
+	"ningxia_backend/pkg/stake"
+	"slices"
+	"testing"
+)
+
+// G6 在 K10+000=K9+800 处有 200 米长链
+func testLongChain(t *testing.T) *stake.Chain {
+	t.Helper()
+	chain, err := stake.NewChain([]stake.Break{{Back: 10000, Ahead: 9800}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return chain
+}
+
+func TestChainCandidates(t *testing.T) {
+	long := testLongChain(t)
+	short, err := stake.NewChain([]stake.Break{{Back: 5000, Ahead: 5300}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name    string
+		chain   *stake.Chain
+		segment stake.Segment
+		want    []stake.Segment
+	}{
+		{"outside long chain", long, stake.Segment{Start: 8000, End: 9000}, []stake.Segment{{Start: 8000, End: 9000}}},
+		{"across long chain", long, stake.Segment{Start: 9000, End: 10500}, []stake.Segment{{Start: 9000, End: 10700}}},
+		{"inside long chain", long, stake.Segment{Start: 9850, End: 9950},
+			[]stake.Segment{{Start: 9850, End: 9950}, {Start: 10050, End: 10150}, {Start: 9850, End: 10150}}},
+		{"ends inside long chain", long, stake.Segment{Start: 9000, End: 9900},
+			[]stake.Segment{{Start: 9000, End: 9900}, {Start: 9000, End: 10100}}},
+		{"across short chain", short, stake.Segment{Start: 4000, End: 6000}, []stake.Segment{{Start: 4000, End: 5700}}},
+		{"inside short chain gap", short, stake.Segment{Start: 5100, End: 5200}, []stake.Segment{{Start: 5100, End: 5200}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := chainCandidates(tt.chain, tt.segment); !slices.Equal(got, tt.want) {
+				t.Errorf("chainCandidates(%v) = %v, want %v", tt.segment, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestPlaceSegments(t *testing.T) {
+	chain := testLongChain(t)
+	tests := []struct {
+		name     string
+		segments []stake.Segment
+		want     []stake.Segment
+	}{
+		{"repeated stakes fall on both passes", []stake.Segment{{Start: 9850, End: 9950}, {Start: 9850, End: 9950}},
+			[]stake.Segment{{Start: 9850, End: 9950}, {Start: 10050, End: 10150}}},
+		{"third repeat falls back to shortest", []stake.Segment{{Start: 9850, End: 9950}, {Start: 9850, End: 9950}, {Start: 9850, End: 9950}},
+			[]stake.Segment{{Start: 9850, End: 9950}, {Start: 10050, End: 10150}, {Start: 9850, End: 9950}}},
+		{"continuous rows through long chain", []stake.Segment{{Start: 9000, End: 10000}, {Start: 9800, End: 10500}},
+			[]stake.Segment{{Start: 9000, End: 10000}, {Start: 10000, End: 10700}}},
+		{"single candidate kept even if overlapping", []stake.Segment{{Start: 8000, End: 9000}, {Start: 8500, End: 9500}},
+			[]stake.Segment{{Start: 8000, End: 9000}, {Start: 8500, End: 9500}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			segments := make([]qualitySegment, len(tt.segments))
+			for i, s := range tt.segments {
+				segments[i] = qualitySegment{Segment: s, Row: i + 2, candidates: chainCandidates(chain, s)}
+			}
+			placeSegments(segments)
+			for i, s := range segments {
+				if s.Distance != tt.want[i] {
+					t.Errorf("row %d placed at %v, want %v", s.Row, s.Distance, tt.want[i])
+				}
+			}
+		})
+	}
+}
+
+func segmentRow(row int, routeCode, direction, lane, start, end string) excelRow {
+	return excelRow{Sheet: "Sheet1", Row: row, Cells: map[string]string{
+		"路线编号": routeCode, "方向": direction, "车道": lane, "起点桩号": start, "终点桩号": end,
+	}}
+}
+
+func TestCheckFileQuality(t *testing.T) {
+	conf.Conf = viper.New()
+	registry := routeRegistry{
+		"G6":   {{RouteCode: "G6", Direction: RoadDirectionBoth, StartStake: 9, EndStake: 11}},
+		"S101": {{RouteCode: "S101", Direction: RoadDirectionUp, StartStake: 0, EndStake: 2}},
+	}
+	tests := []struct {
+		name     string
+		role     string
+		rows     []excelRow
+		registry routeRegistry
+		want     map[string]int
+	}{
+		{"clean", "managementDetailFile", []excelRow{
+			segmentRow(2, "G6", "上行", "1", "K9+000", "K10+000"),
+			segmentRow(3, "G6", "上行", "1", "K9+800", "K11+000"),
+		}, registry, map[string]int{}},
+		{"duplicate in same lane", "managementDetailFile", []excelRow{
+			segmentRow(2, "G6", "上行", "1", "K9+000", "K9+500"),
+			segmentRow(3, "g6", "up", "1", "K9+000", "K9+500"),
+		}, registry, map[string]int{QualityDuplicate: 1}},
+		{"same stakes in other lane or direction", "managementDetailFile", []excelRow{
+			segmentRow(2, "G6", "上行", "1", "K9+000", "K9+500"),
+			segmentRow(3, "G6", "上行", "2", "K9+000", "K9+500"),
+			segmentRow(4, "G6", "下行", "1", "K9+000", "K9+500"),
+		}, registry, map[string]int{}},
+		{"partial overlap", "managementDetailFile", []excelRow{
+			segmentRow(2, "G6", "上行", "", "K9+000", "K9+500"),
+			segmentRow(3, "G6", "上行", "", "K9+400", "K9+700"),
+		}, registry, map[string]int{QualityOverlap: 1}},
+		{"touching segments", "managementDetailFile", []excelRow{
+			segmentRow(2, "G6", "上行", "", "K9+000", "K9+500"),
+			segmentRow(3, "G6", "上行", "", "K9+500", "K9+700"),
+		}, registry, map[string]int{}},
+		{"repeated stakes on long chain", "managementDetailFile", []excelRow{
+			segmentRow(2, "G6", "上行", "", "K9+850", "K9+950"),
+			segmentRow(3, "G6", "上行", "", "K9+850", "K9+950"),
+		}, registry, map[string]int{}},
+		{"unknown routes grouped", "managementDetailFile", []excelRow{
+			segmentRow(2, "X999", "上行", "", "K0+000", "K1+000"),
+			segmentRow(3, "X999", "上行", "", "K1+000", "K2+000"),
+			segmentRow(4, "Y001", "上行", "", "K0+000", "K1+000"),
+		}, registry, map[string]int{QualityUnknownRoute: 2}},
+		{"out of extent", "managementDetailFile", []excelRow{
+			segmentRow(2, "G6", "上行", "", "K8+000", "K9+500"),
+			segmentRow(3, "S101", "下行", "", "K1+000", "K3+000"),
+		}, registry, map[string]int{QualityOutOfExtent: 2}},
+		{"invalid direction skips other checks", "managementDetailFile", []excelRow{
+			segmentRow(2, "G6", "东", "", "K9+000", "K9+500"),
+			segmentRow(3, "G6", "东", "", "K9+000", "K9+500"),
+		}, registry, map[string]int{QualityInvalidDirection: 2}},
+		{"rows without route or with bad stakes ignored", "managementDetailFile", []excelRow{
+			segmentRow(2, "", "上行", "", "K9+000", "K9+500"),
+			segmentRow(3, "G6", "上行", "", "K9+000", "K9+1500"),
+		}, registry, map[string]int{}},
+		{"full coverage across long chain", "unitLevelDetailFile", []excelRow{
+			segmentRow(2, "G6", "双向", "", "K9+000", "K10+000"),
+			segmentRow(3, "G6", "双向", "", "K9+800", "K11+000"),
+		}, registry, map[string]int{}},
+		{"coverage gap after long chain", "unitLevelDetailFile", []excelRow{
+			segmentRow(2, "G6", "双向", "", "K9+000", "K10+000"),
+		}, registry, map[string]int{QualityCoverageGap: 1}},
+		{"coverage not checked for inspection data", "firstInspectionExcel", []excelRow{
+			segmentRow(2, "G6", "双向", "", "K9+000", "K9+100"),
+		}, registry, map[string]int{}},
+		{"empty registry checks only duplicates and overlaps", "unitLevelDetailFile", []excelRow{
+			segmentRow(2, "X999", "上行", "", "K0+000", "K1+000"),
+			segmentRow(3, "X999", "上行", "", "K0+000", "K1+000"),
+		}, routeRegistry{}, map[string]int{QualityDuplicate: 1}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			report := &qualityReport{Categories: make(map[string]int)}
+			chains := qualityChains{"G6": testLongChain(t)}
+			for _, routeCode := range []string{"S101", "X999", "Y001"} {
+				chains[routeCode], _ = stake.NewChain(nil)
+			}
+			file := dao.DatasetFile{Role: tt.role, Name: "test.xlsx"}
+			checkFileQuality(report, tt.registry, chains, file, tt.rows, false)
+			if !maps.Equal(report.Categories, tt.want) {
+				t.Errorf("categories = %v, want %v; issues: %+v", report.Categories, tt.want, report.Issues)
+			}
+		})
+	}
+}
+
+func TestCheckFileQualityPoints(t *testing.T) {
+	conf.Conf = viper.New()
+	registry := routeRegistry{"G6": {{RouteCode: "G6", Direction: RoadDirectionUp, StartStake: 9, EndStake: 11}}}
+	chains := qualityChains{"G6": testLongChain(t)}
+	rows := []excelRow{
+		{Sheet: "Sheet1", Row: 2, Cells: map[string]string{"路线编号": "G6", "方向": "上行", "桩号": "K9+500"}},
+		{Sheet: "Sheet1", Row: 3, Cells: map[string]string{"路线编号": "G6", "方向": "上行", "桩号": "K9+500"}},
+		{Sheet: "Sheet1", Row: 4, Cells: map[string]string{"路线编号": "G6", "方向": "上行", "桩号": "K12+000"}},
+	}
+	report := &qualityReport{Categories: make(map[string]int)}
+	checkFileQuality(report, registry, chains, dao.DatasetFile{Role: "diseaseDataExcel", Name: "disease.xlsx"}, rows, true)
+	// 病害按单点记录，同一桩号可有多处病害，不检查重复
+	if want := map[string]int{QualityOutOfExtent: 1}; !maps.Equal(report.Categories, want) {
+		t.Errorf("categories = %v, want %v", report.Categories, want)
+	}
+	if len(report.Issues) != 1 || report.Issues[0].Row != 4 || report.Issues[0].Severity != QualitySeverityError || !report.Blocking {
+		t.Errorf("issues = %+v", report.Issues)
+	}
+}
@@ -0,0 +1,94 @@
+package handler
+
+import (
+	"errors"
+	"fmt"
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+	"net/http"
+	"ningxia_backend/dao"
+	"ningxia_backend/pkg/logger"
+	"os"
+	"path/filepath"
+)
+
+// newDataset 为上传目录创建数据集记录（尚未保存）
+func newDataset(dir string) (*dao.Dataset, error) {
+	id, err := newOpaqueID()
+	if err != nil {
+		return nil, err
+	}
+	return &dao.Dataset{ID: id, Dir: dir}, nil
+}
+
+// addDatasetFile 登记数据集目录中的文件，计算大小和摘要
+func addDatasetFile(dataset *dao.Dataset, role, path string) error {
+	info, err := os.Stat(path)
+	if err != nil {
+		return err
+	}
+	hash, err := fileSHA256(path)
+	if err != nil {
+		return err
+	}
+	name, err := filepath.Rel(dataset.Dir, path)
+	if err != nil {
+		return err
+	}
+	dataset.Files = append(dataset.Files, dao.DatasetFile{
+		DatasetID: dataset.ID,
+		Role:      role,
+		Name:      filepath.ToSlash(name),
+		Path:      path,
+		Size:      info.Size(),
+		SHA256:    hash,
+	})
+	return nil
+}
+
+// loadDataset 按ID获取数据集及其文件
+func loadDataset(id string) (*dao.Dataset, error) {
+	var dataset dao.Dataset
+	if err := dao.GetDB().Preload("Files").Where("id = ?", id).First(&dataset).Error; err != nil {
+		return nil, err
+	}
+	return &dataset, nil
+}
+
+// resolveDatasetFiles 由服务器根据数据集ID解析出计算所需的文件，客户端不再提供路径
+func resolveDatasetFiles(ids []string) ([]dao.DatasetFile, error) {
+	var files []dao.DatasetFile
+	for _, id := range ids {
+		dataset, err := loadDataset(id)
+		if err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				return nil, fmt.Errorf("数据集 %s 不存在", id)
+			}
+			return nil, err
+		}
+		files = append(files, dataset.Files...)
+	}
+	return files, nil
+}
+
+func datasetFilePaths(files []dao.DatasetFile) []string {
+	paths := make([]string, len(files))
+	for i, file := range files {
+		paths[i] = file.Path
+	}
+	return paths
+}
+
+func GetDatasetHandler(c *gin.Context) {
+	dataset, err := loadDataset(c.Param("id"))
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "数据集不存在"})
+			return
+		}
+		logger.Logger.Errorf("查询数据集失败: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "数据库查询失败"})
+		return
+	}
+	c.JSON(http.StatusOK, dataset)
+}
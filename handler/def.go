@@ -3,6 +3,10 @@ package handler
 const (
 	uploadDir                     = "./tmp/uploads"
 	maxFileSize                   = 1024 * 1024 * 1024 // 1024MB
+	chunkUploadDir                = "./tmp/uploads/chunks"
+	blobDir                       = "./tmp/blobs"    // 内容寻址存储，按 SHA-256 保存上传文件
+	defaultChunkSize              = 8 * 1024 * 1024  // 8MB
+	maxChunkSize                  = 64 * 1024 * 1024 // 64MB
 	pdfDir                        = "./tmp/pdf"
 	reportsBaseDir                = "./reports" // Base directory for saved reports
 	expresswayReportBaseDir       = "./reports/expressway"
@@ -10,8 +14,12 @@ const (
 	constructionReportBaseDir     = "./reports/construction"
 	ruralReportBaseDir            = "./reports/rural"
 	nationalProvinceReportBaseDir = "./reports/nationalProvince"
+	reportResultFile              = "result.json" // 报告目录中保存的计算结果
 
 	wkhtmltopdfPath = "./wkhtmltox/bin/wkhtmltopdf.exe"
+
+	bundledFontFile   = "./fonts/方正黑体简体.TTF"
+	bundledFontFamily = "FZHei"
 )
 
 const (
@@ -21,6 +29,12 @@ const (
 	ReportTypeRural              = "RURAL"
 	ReportTypeNationalProvincial = "NATIONAL_PROVINCIAL"
 
+	UploadStatusUploading = "uploading"
+	UploadStatusCompleted = "completed"
+
+	ReportStatusActive    = "active"
+	ReportStatusWithdrawn = "withdrawn"
+
 	PyRespImagesKey      = "IMAGES"
 	PyRespExtraImagesKey = "EXTRA_IMAGES"
 	UserFont             = "FZHTJW--GB1-0"
@@ -35,6 +49,10 @@ var (
 		ReportTypeNationalProvincial: "普通国省干线抽检路段公路技术状况监管分析报告",
 		//ReportTypeMarket:             "市场化路段抽检路段公路技术状况监管分析报告",
 	}
+	// 前端可能发送的ZIP和Excel文件的表单字段名
+	zipFieldNames   = []string{"threeDimensionalDataZip", "cicsDataZip", "previousYearDiseaseZip"}
+	excelFieldNames = []string{"managementDetailFile", "unitLevelDetailFile", "roadConditionFile", "firstInspectionExcel", "secondInspectionExcel", "diseaseDataExcel"}
+
 	ReportDirs = []string{
 		expresswayReportBaseDir,
 		maintenanceReportBaseDir,
@@ -51,4 +69,8 @@ type exportPDFReq struct {
 	WmOpacity  float64 `form:"wm_opacity"`
 	WmFontSize int     `form:"wm_font_size"`
 	WmAngle    float64 `form:"wm_angle"`
+	Archive    bool    `form:"archive"` // 归档模式：嵌入字体、写入元数据并做PDF/A检查
+	Theme      string  `form:"theme"`   // 样式主题，为空时使用报告类型的默认主题
+	Attach     bool    `form:"attach"`  // 将原始输入文件和计算结果作为附件嵌入PDF
+	QR         string  `form:"qr"`      // 核验二维码位置：cover / footer / none，为空时取配置
 }
@@ -4,6 +4,7 @@ import (
 	"fmt"
 	"github.com/gin-gonic/gin"
 	"net/http"
+	"ningxia_backend/dao"
 	"ningxia_backend/pkg/logger"
 	"os"
 	"path/filepath"
@@ -31,5 +32,11 @@ func DeleteReportHandler(c *gin.Context) {
 	}
 
 	logger.Logger.Infof("报告目录已删除: %s", reportDirPath)
+
+	// 保留目录记录并标记为撤回，已分发的报告仍可核验到撤回状态
+	err = dao.GetDB().Model(&dao.Report{}).Where("filename = ?", baseName).Update("status", ReportStatusWithdrawn).Error
+	if err != nil {
+		logger.Logger.Errorf("标记报告 %s 撤回失败: %v", baseName, err)
+	}
 	c.JSON(http.StatusOK, gin.H{"message": fmt.Sprintf("报告 %s 删除成功", filename)})
 }
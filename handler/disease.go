@@ -0,0 +1,513 @@
+package handler
+
+import (
+	"errors"
+	"fmt"
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+	"math"
+	"net/http"
+	"ningxia_backend/dao"
+	"ningxia_backend/pkg/logger"
+	"ningxia_backend/pkg/stake"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+const (
+	// diseaseDataRole 本年病害数据表，previousYearDiseaseRole 为上年病害数据压缩包，其中的表格式相同
+	diseaseDataRole         = "diseaseDataExcel"
+	previousYearDiseaseRole = "previousYearDiseaseZip"
+
+	defaultDiseaseLimit = 500
+	maxDiseaseLimit     = 5000
+)
+
+// diseaseCategories 病害名称关键字与类别，按顺序匹配第一个包含的关键字
+var diseaseCategories = []struct {
+	Keyword  string
+	Category string
+}{
+	{"裂", "crack"}, // 龟裂、块状裂缝、纵向裂缝、横向裂缝
+	{"坑槽", "pothole"},
+	{"车辙", "rutting"},
+	{"沉陷", "subsidence"},
+	{"拥包", "shoving"},
+	{"波浪", "shoving"},
+	{"松散", "ravelling"},
+	{"麻面", "ravelling"},
+	{"泛油", "bleeding"},
+	{"修补", "patching"},
+}
+
+// diseaseSeverities 严重程度的常见写法
+var diseaseSeverities = map[string]string{
+	"轻": "轻", "轻度": "轻", "轻微": "轻", "L": "轻",
+	"中": "中", "中度": "中", "中等": "中", "M": "中",
+	"重": "重", "重度": "重", "严重": "重", "H": "重",
+}
+
+func diseaseCategory(name string) string {
+	for _, c := range diseaseCategories {
+		if strings.Contains(name, c.Keyword) || strings.EqualFold(name, c.Category) {
+			return c.Category
+		}
+	}
+	return "other"
+}
+
+func parseOptionalFloat(value, name string) (float64, error) {
+	if value == "" {
+		return 0, nil
+	}
+	v, err := strconv.ParseFloat(value, 64)
+	if err != nil || v < 0 {
+		return 0, fmt.Errorf("%s'%s'无效", name, value)
+	}
+	return v, nil
+}
+
+// parseDiseaseRow 将病害数据表的一行转换为病害记录
+func parseDiseaseRow(row excelRow) (*dao.Disease, error) {
+	cells := row.Cells
+	d := &dao.Disease{
+		RouteCode: strings.ToUpper(cells["路线编号"]),
+		Lane:      cells["车道"],
+		Type:      cells["病害类型"],
+		Image:     cells["图片"],
+	}
+	if d.RouteCode == "" || d.Type == "" {
+		return nil, errors.New("路线编号和病害类型不能为空")
+	}
+	direction, ok := roadDirections[cells["方向"]]
+	if !ok {
+		return nil, fmt.Errorf("方向'%s'无效", cells["方向"])
+	}
+	d.Direction = direction
+	s, err := stake.Parse(cells["桩号"])
+	if err != nil {
+		return nil, err
+	}
+	d.Stake = s.Meters()
+	d.Category = diseaseCategory(d.Type)
+	d.Severity = cells["严重程度"]
+	if severity, ok := diseaseSeverities[strings.ToUpper(d.Severity)]; ok {
+		d.Severity = severity
+	}
+	if d.Length, err = parseOptionalFloat(cells["长度"], "长度"); err != nil {
+		return nil, err
+	}
+	if d.Width, err = parseOptionalFloat(cells["宽度"], "宽度"); err != nil {
+		return nil, err
+	}
+	if d.Area, err = parseOptionalFloat(cells["面积"], "面积"); err != nil {
+		return nil, err
+	}
+	if d.Area == 0 {
+		d.Area = math.Round(d.Length*d.Width*1000) / 1000
+	}
+	return d, nil
+}
+
+// readDatasetDiseases 读取数据集中的本年和上年病害数据表，无法解析的行跳过并计数
+func readDatasetDiseases(dataset *dao.Dataset, year int) ([]dao.Disease, int, error) {
+	var diseases []dao.Disease
+	skipped := 0
+	for _, file := range dataset.Files {
+		recordYear := year
+		switch file.Role {
+		case diseaseDataRole:
+		case previousYearDiseaseRole:
+			recordYear = year - 1
+		default:
+			continue
+		}
+		if ext := strings.ToLower(filepath.Ext(file.Path)); ext != ".xlsx" && ext != ".xlsm" {
+			continue
+		}
+		rows, err := readExcelRows(diseaseDataRole, file.Path)
+		if err != nil {
+			return nil, 0, fmt.Errorf("读取 %s 失败: %w", file.Name, err)
+		}
+		for _, row := range rows {
+			d, err := parseDiseaseRow(row)
+			if err != nil {
+				skipped++
+				continue
+			}
+			d.DatasetID = dataset.ID
+			d.Year = recordYear
+			d.Source = fmt.Sprintf("%s:%d", file.Name, row.Row)
+			diseases = append(diseases, *d)
+		}
+	}
+	return diseases, skipped, nil
+}
+
+// syncDatasetDiseases 按数据集当前所属批次重新导入其病害记录，数据集未归入批次时只删除旧记录
+func syncDatasetDiseases(dataset *dao.Dataset) (imported, skipped int, err error) {
+	var diseases []dao.Disease
+	if dataset.CampaignID != nil {
+		var campaign dao.Campaign
+		if err = dao.GetDB().First(&campaign, *dataset.CampaignID).Error; err != nil {
+			return 0, 0, err
+		}
+		if diseases, skipped, err = readDatasetDiseases(dataset, campaign.Year); err != nil {
+			return 0, 0, err
+		}
+		for i := range diseases {
+			diseases[i].CampaignID = campaign.ID
+		}
+	}
+	err = dao.GetDB().Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("dataset_id = ?", dataset.ID).Delete(&dao.Disease{}).Error; err != nil {
+			return err
+		}
+		if len(diseases) == 0 {
+			return nil
+		}
+		return tx.CreateInBatches(diseases, 500).Error
+	})
+	if err != nil {
+		return 0, 0, err
+	}
+	return len(diseases), skipped, nil
+}
+
+// importDatasetDiseases 上传或关联批次后导入病害记录，失败只记录日志，可通过批次的导入接口重试
+func importDatasetDiseases(dataset *dao.Dataset) {
+	imported, skipped, err := syncDatasetDiseases(dataset)
+	if err != nil {
+		logger.Logger.Errorf("导入数据集 %s 的病害记录失败: %v", dataset.ID, err)
+		return
+	}
+	if imported > 0 || skipped > 0 {
+		logger.Logger.Infof("数据集 %s 导入病害记录 %d 条，跳过 %d 行", dataset.ID, imported, skipped)
+	}
+}
+
+// ImportCampaignDiseasesHandler 重新导入批次全部数据集的病害记录
+func ImportCampaignDiseasesHandler(c *gin.Context) {
+	campaign, ok := loadCampaign(c)
+	if !ok {
+		return
+	}
+	var datasets []dao.Dataset
+	if err := dao.GetDB().Preload("Files").Where("campaign_id = ?", campaign.ID).Find(&datasets).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "数据库查询失败"})
+		return
+	}
+	total, totalSkipped := 0, 0
+	for i := range datasets {
+		imported, skipped, err := syncDatasetDiseases(&datasets[i])
+		if err != nil {
+			logger.Logger.Errorf("导入数据集 %s 的病害记录失败: %v", datasets[i].ID, err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "导入病害记录失败"})
+			return
+		}
+		total += imported
+		totalSkipped += skipped
+	}
+	c.JSON(http.StatusOK, gin.H{"datasets": len(datasets), "imported": total, "skipped": totalSkipped})
+}
+
+// diseaseView 病害记录及格式化的桩号
+type diseaseView struct {
+	dao.Disease
+	StakeText string `json:"stakeText"`
+}
+
+// diseaseFilter 查询条件：campaignId、year、routeCode、direction、type（病害名称或类别）、severity、
+// from/to（桩号范围，如 K10+000）。批次中同时保存了上年病害，指定 campaignId 未指定 year 时只查批次年度
+type diseaseFilter struct {
+	RouteCode string
+	Direction string
+	Range     *stake.Segment
+}
+
+func applyDiseaseFilter(c *gin.Context, db *gorm.DB) (*gorm.DB, *diseaseFilter, error) {
+	filter := &diseaseFilter{}
+	if value := c.Query("campaignId"); value != "" {
+		id, err := strconv.ParseUint(value, 10, 64)
+		if err != nil {
+			return nil, nil, errors.New("无效的批次ID")
+		}
+		db = db.Where("campaign_id = ?", id)
+		if c.Query("year") == "" {
+			var campaign dao.Campaign
+			if err = dao.GetDB().Select("year").First(&campaign, id).Error; err != nil {
+				if errors.Is(err, gorm.ErrRecordNotFound) {
+					return nil, nil, fmt.Errorf("抽检批次 %d 不存在", id)
+				}
+				return nil, nil, errors.New("数据库查询失败")
+			}
+			db = db.Where("year = ?", campaign.Year)
+		}
+	}
+	if value := c.Query("year"); value != "" {
+		year, err := strconv.Atoi(value)
+		if err != nil {
+			return nil, nil, errors.New("无效的年份参数")
+		}
+		db = db.Where("year = ?", year)
+	}
+	if filter.RouteCode = strings.ToUpper(strings.TrimSpace(c.Query("routeCode"))); filter.RouteCode != "" {
+		db = db.Where("route_code = ?", filter.RouteCode)
+	}
+	if value := c.Query("direction"); value != "" {
+		direction, ok := roadDirections[value]
+		if !ok {
+			return nil, nil, errors.New("方向无效，应为上行、下行或双向")
+		}
+		filter.Direction = direction
+		db = db.Where("direction = ?", direction)
+	}
+	if value := strings.TrimSpace(c.Query("type")); value != "" {
+		db = db.Where("type = ? OR category = ?", value, value)
+	}
+	if value := strings.TrimSpace(c.Query("severity")); value != "" {
+		if severity, ok := diseaseSeverities[strings.ToUpper(value)]; ok {
+			value = severity
+		}
+		db = db.Where("severity = ?", value)
+	}
+	from, to := c.Query("from"), c.Query("to")
+	if from != "" || to != "" {
+		if from == "" || to == "" {
+			return nil, nil, errors.New("桩号范围需同时提供 from 和 to")
+		}
+		start, err := stake.Parse(from)
+		if err != nil {
+			return nil, nil, err
+		}
+		end, err := stake.Parse(to)
+		if err != nil {
+			return nil, nil, err
+		}
+		segment := stake.NewSegment(start, end)
+		filter.Range = &segment
+		db = db.Where("stake >= ? AND stake <= ?", float64(segment.Start), float64(segment.End))
+	}
+	return db, filter, nil
+}
+
+// GetDiseases 按条件查询病害记录，按路线和桩号排序，limit 默认 500、最大 5000
+func GetDiseases(c *gin.Context) {
+	db, _, err := applyDiseaseFilter(c, dao.GetDB().Model(&dao.Disease{}))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	limit, offset := defaultDiseaseLimit, 0
+	if value := c.Query("limit"); value != "" {
+		if limit, err = strconv.Atoi(value); err != nil || limit <= 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "limit 应为正整数"})
+			return
+		}
+		limit = min(limit, maxDiseaseLimit)
+	}
+	if value := c.Query("offset"); value != "" {
+		if offset, err = strconv.Atoi(value); err != nil || offset < 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "offset 应为非负整数"})
+			return
+		}
+	}
+
+	var total int64
+	if err = db.Count(&total).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "数据库查询失败"})
+		return
+	}
+	var diseases []dao.Disease
+	if err = db.Order("route_code, direction, stake, id").Limit(limit).Offset(offset).Find(&diseases).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "数据库查询失败"})
+		return
+	}
+	items := make([]diseaseView, 0, len(diseases))
+	for _, d := range diseases {
+		items = append(items, diseaseView{Disease: d, StakeText: stake.Stake(d.Stake).String()})
+	}
+	c.JSON(http.StatusOK, gin.H{"total": total, "limit": limit, "offset": offset, "items": items})
+}
+
+// diseaseDensity 病害密度统计，Km 为计算密度所用的里程，为 0 时不计算密度
+type diseaseDensity struct {
+	RouteCode    string         `json:"routeCode"`
+	Start        string         `json:"start,omitempty"` // groupBy=unit 时为评定单元起止桩号
+	End          string         `json:"end,omitempty"`
+	Km           float64        `json:"km"`
+	LengthSource string         `json:"lengthSource"` // registry：路网登记；range：查询的桩号范围；records：病害记录的桩号跨度；unit：评定单元；routes：各路线合计
+	Count        int            `json:"count"`
+	Area         float64        `json:"area"`
+	Density      *float64       `json:"density"`     // 处/km
+	AreaDensity  *float64       `json:"areaDensity"` // m²/km
+	ByCategory   map[string]int `json:"byCategory"`
+}
+
+func (d *diseaseDensity) add(disease dao.Disease) {
+	d.Count++
+	d.Area += disease.Area
+	d.ByCategory[disease.Category]++
+}
+
+func (d *diseaseDensity) finish() {
+	d.Area = round2(d.Area)
+	d.Km = math.Round(d.Km*1000) / 1000
+	if d.Km > 0 {
+		density, areaDensity := round2(float64(d.Count)/d.Km), round2(d.Area/d.Km)
+		d.Density, d.AreaDensity = &density, &areaDensity
+	}
+}
+
+// carriagewayKm 路线各幅登记里程之和（km），限定在 clip 范围内：分上下行登记的路段两幅分别计算，
+// 双向登记的路段只计一次
+func carriagewayKm(registry routeRegistry, routeCode string, clip *stake.Segment) float64 {
+	byDirection := make(map[string][]stake.Segment)
+	for _, road := range registry[routeCode] {
+		segment := stake.NewSegment(stake.FromKm(road.StartStake), stake.FromKm(road.EndStake))
+		if clip != nil {
+			overlap, ok := stake.Overlap(segment, *clip)
+			if !ok {
+				continue
+			}
+			segment = overlap
+		}
+		byDirection[road.Direction] = append(byDirection[road.Direction], segment)
+	}
+	length := func(segments []stake.Segment) float64 {
+		total := 0.0
+		for _, s := range segments {
+			total += s.Length()
+		}
+		return total
+	}
+	both := stake.Union(byDirection[RoadDirectionBoth])
+	up := stake.Union(append(byDirection[RoadDirectionUp], both...))
+	down := stake.Union(byDirection[RoadDirectionDown])
+	// 下行与双向登记重叠的部分已按双向计入
+	shared := 0.0
+	for _, d := range down {
+		for _, b := range both {
+			if overlap, ok := stake.Overlap(d, b); ok {
+				shared += overlap.Length()
+			}
+		}
+	}
+	return (length(up) + length(down) - shared) / 1000
+}
+
+// routeDensityKm 路线计算密度所用的里程：路网登记的范围（限定在查询的桩号范围内），
+// 未指定方向时为上下行各幅里程之和；未登记时使用查询的桩号范围，都没有时使用病害记录的桩号跨度
+func routeDensityKm(registry routeRegistry, routeCode string, filter *diseaseFilter, diseases []dao.Disease) (float64, string) {
+	if _, ok := registry[routeCode]; ok {
+		if filter.Direction == "" {
+			return carriagewayKm(registry, routeCode, filter.Range), "registry"
+		}
+		total := 0.0
+		for _, e := range registry.extent(routeCode, filter.Direction) {
+			if filter.Range == nil {
+				total += e.Length()
+			} else if o, ok := stake.Overlap(e, *filter.Range); ok {
+				total += o.Length()
+			}
+		}
+		return total / 1000, "registry"
+	}
+	if filter.Range != nil {
+		return filter.Range.Length() / 1000, "range"
+	}
+	lo, hi := math.Inf(1), math.Inf(-1)
+	for _, d := range diseases {
+		lo, hi = min(lo, d.Stake), max(hi, d.Stake)
+	}
+	return (hi - lo) / 1000, "records"
+}
+
+// GetDiseaseDensity 病害密度（处/km、m²/km），筛选条件同 GetDiseases。
+// groupBy=route（默认）按路线统计；groupBy=unit 按 unit 米（100 或 1000，默认 1000）的评定单元统计，只返回有病害的单元
+func GetDiseaseDensity(c *gin.Context) {
+	db, filter, err := applyDiseaseFilter(c, dao.GetDB().Model(&dao.Disease{}))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	groupBy := c.DefaultQuery("groupBy", "route")
+	if groupBy != "route" && groupBy != "unit" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "groupBy 应为 route 或 unit"})
+		return
+	}
+	unit := stake.Unit1km
+	if value := c.Query("unit"); value != "" {
+		switch value {
+		case "100":
+			unit = stake.Unit100m
+		case "1000":
+		default:
+			c.JSON(http.StatusBadRequest, gin.H{"error": "unit 应为 100 或 1000"})
+			return
+		}
+	}
+
+	var diseases []dao.Disease
+	if err = db.Order("route_code, stake").Find(&diseases).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "数据库查询失败"})
+		return
+	}
+	byRoute := make(map[string][]dao.Disease)
+	for _, d := range diseases {
+		byRoute[d.RouteCode] = append(byRoute[d.RouteCode], d)
+	}
+	routes := make([]string, 0, len(byRoute))
+	for routeCode := range byRoute {
+		routes = append(routes, routeCode)
+	}
+	sort.Strings(routes)
+
+	results := make([]*diseaseDensity, 0)
+	if groupBy == "unit" {
+		for _, routeCode := range routes {
+			units := make(map[stake.Stake]*diseaseDensity)
+			var starts []stake.Stake
+			for _, d := range byRoute[routeCode] {
+				start := stake.Stake(math.Floor(d.Stake/float64(unit))) * unit
+				u, ok := units[start]
+				if !ok {
+					u = &diseaseDensity{RouteCode: routeCode, Start: start.String(), End: (start + unit).String(), Km: unit.Km(), LengthSource: "unit", ByCategory: map[string]int{}}
+					units[start] = u
+					starts = append(starts, start)
+				}
+				u.add(d)
+			}
+			slices.Sort(starts)
+			for _, start := range starts {
+				units[start].finish()
+				results = append(results, units[start])
+			}
+		}
+		c.JSON(http.StatusOK, gin.H{"groupBy": groupBy, "unit": float64(unit), "items": results})
+		return
+	}
+
+	registry, err := loadRouteRegistry()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "数据库查询失败"})
+		return
+	}
+	total := &diseaseDensity{RouteCode: "合计", LengthSource: "routes", ByCategory: map[string]int{}}
+	for _, routeCode := range routes {
+		route := &diseaseDensity{RouteCode: routeCode, ByCategory: map[string]int{}}
+		route.Km, route.LengthSource = routeDensityKm(registry, routeCode, filter, byRoute[routeCode])
+		for _, d := range byRoute[routeCode] {
+			route.add(d)
+			total.add(d)
+		}
+		total.Km += route.Km
+		route.finish()
+		results = append(results, route)
+	}
+	total.finish()
+	c.JSON(http.StatusOK, gin.H{"groupBy": groupBy, "items": results, "total": total})
+}
@@ -0,0 +1,552 @@
+package handler
+
+import (
+	"errors"
+	"fmt"
+	"github.com/gin-gonic/gin"
+	"github.com/xuri/excelize/v2"
+	"gorm.io/gorm"
+	"math"
+	"net/http"
+	"net/url"
+	"ningxia_backend/dao"
+	"ningxia_backend/pkg/conf"
+	"ningxia_backend/pkg/logger"
+	"ningxia_backend/pkg/stake"
+	"regexp"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// 前后两次病害比对的结果分类。实施前的病害为 repaired、persistent 或 worsened，
+// 实施后未与实施前匹配的病害为 new
+const (
+	DiseaseRepaired   = "repaired"   // 实施后同一位置没有相容的病害
+	DiseasePersistent = "persistent" // 实施后仍存在
+	DiseaseWorsened   = "worsened"   // 实施后仍存在且程度加重或面积增大
+	DiseaseNew        = "new"        // 实施后新出现
+
+	// 模板中的有效修复率占位符：（实施前病害-实施后重复病害）/实施前病害
+	repairRatePlaceholder = "REPAIR_RATE"
+)
+
+var (
+	diseaseStatusNames = map[string]string{
+		DiseaseRepaired:   "已修复",
+		DiseasePersistent: "仍存在",
+		DiseaseWorsened:   "加重",
+		DiseaseNew:        "新增",
+	}
+	severityRanks   = map[string]int{"轻": 1, "中": 2, "重": 3}
+	laneRegexp      = regexp.MustCompile(`\d+`)
+	errNoBaseline   = errors.New("没有可比对的实施前病害记录")
+	errSameBaseline = errors.New("基准批次不能与本批次相同")
+)
+
+// diseaseMatchOptions 匹配条件，取自配置 diseaseMatch 节点
+type diseaseMatchOptions struct {
+	StakeTolerance float64             `json:"stakeTolerance"` // 桩号相差不超过该值（米）
+	LaneTolerance  int                 `json:"laneTolerance"`  // 车道号相差不超过该值，车道为空时不限
+	AreaGrowth     float64             `json:"areaGrowth"`     // 面积增大超过该比例视为加重
+	Compatible     [][]string          `json:"compatible"`     // 可互相匹配的病害类别，同类别总是可以匹配
+	groups         map[string][]string // 类别 -> 所在的相容分组
+}
+
+func loadDiseaseMatchOptions() diseaseMatchOptions {
+	opts := diseaseMatchOptions{
+		StakeTolerance: math.Max(conf.Conf.GetFloat64("diseaseMatch.stakeTolerance"), 0),
+		LaneTolerance:  max(conf.Conf.GetInt("diseaseMatch.laneTolerance"), 0),
+		AreaGrowth:     math.Max(conf.Conf.GetFloat64("diseaseMatch.areaGrowth"), 0),
+		Compatible:     make([][]string, 0),
+		groups:         make(map[string][]string),
+	}
+	for _, value := range conf.Conf.GetStringSlice("diseaseMatch.compatible") {
+		var group []string
+		for _, category := range strings.Split(value, ",") {
+			if category = strings.TrimSpace(category); category != "" {
+				group = append(group, category)
+			}
+		}
+		if len(group) < 2 {
+			continue
+		}
+		opts.Compatible = append(opts.Compatible, group)
+		for _, category := range group {
+			opts.groups[category] = append(opts.groups[category], group...)
+		}
+	}
+	return opts
+}
+
+func (o diseaseMatchOptions) compatibleType(before, after dao.Disease) bool {
+	return before.Type == after.Type || before.Category == after.Category || slices.Contains(o.groups[before.Category], after.Category)
+}
+
+// compatibleLane 车道号可解析为数字时按容差比较，否则要求写法相同，任一方未填写车道时不限
+func (o diseaseMatchOptions) compatibleLane(before, after string) bool {
+	if before == "" || after == "" {
+		return true
+	}
+	b, a := laneRegexp.FindString(before), laneRegexp.FindString(after)
+	if b == "" || a == "" {
+		return before == after
+	}
+	bn, _ := strconv.Atoi(b)
+	an, _ := strconv.Atoi(a)
+	return max(bn-an, an-bn) <= o.LaneTolerance
+}
+
+func compatibleDirection(before, after string) bool {
+	return before == after || before == RoadDirectionBoth || after == RoadDirectionBoth
+}
+
+// worsening 匹配的病害是否加重，返回变化说明
+func (o diseaseMatchOptions) worsening(before, after dao.Disease) string {
+	var changes []string
+	if b, a := severityRanks[before.Severity], severityRanks[after.Severity]; b > 0 && a > b {
+		changes = append(changes, fmt.Sprintf("程度由%s变为%s", before.Severity, after.Severity))
+	}
+	if before.Area > 0 && after.Area > before.Area*(1+o.AreaGrowth) {
+		changes = append(changes, fmt.Sprintf("面积由 %g 增至 %g m²", before.Area, after.Area))
+	}
+	return strings.Join(changes, "，")
+}
+
+// diseaseMatchItem 一条比对结果，Before、After 分别为实施前后的病害记录，Offset 为两者桩号差（米）
+type diseaseMatchItem struct {
+	Status     string       `json:"status"`
+	StatusName string       `json:"statusName"`
+	RouteCode  string       `json:"routeCode"`
+	Before     *diseaseView `json:"before,omitempty"`
+	After      *diseaseView `json:"after,omitempty"`
+	Offset     *float64     `json:"offset,omitempty"`
+	Change     string       `json:"change,omitempty"` // 加重的说明
+}
+
+func (i *diseaseMatchItem) stake() float64 {
+	if i.Before != nil {
+		return i.Before.Stake
+	}
+	return i.After.Stake
+}
+
+func newDiseaseMatchItem(status string, before, after *dao.Disease) diseaseMatchItem {
+	item := diseaseMatchItem{Status: status, StatusName: diseaseStatusNames[status]}
+	if before != nil {
+		item.RouteCode = before.RouteCode
+		item.Before = &diseaseView{Disease: *before, StakeText: stake.Stake(before.Stake).String()}
+	}
+	if after != nil {
+		item.RouteCode = after.RouteCode
+		item.After = &diseaseView{Disease: *after, StakeText: stake.Stake(after.Stake).String()}
+	}
+	if before != nil && after != nil {
+		offset := round2(after.Stake - before.Stake)
+		item.Offset = &offset
+	}
+	return item
+}
+
+// matchDiseases 按路线、方向、桩号和车道一一匹配实施前后的病害。候选对按桩号差从小到大、
+// 同名病害优先依次确定，每条记录最多匹配一次
+func matchDiseases(before, after []dao.Disease, opts diseaseMatchOptions) []diseaseMatchItem {
+	type candidate struct {
+		b, a   int
+		offset float64
+		exact  bool
+	}
+	byRoute := make(map[string][]int)
+	for i, d := range after {
+		byRoute[d.RouteCode] = append(byRoute[d.RouteCode], i)
+	}
+	for _, indexes := range byRoute {
+		sort.Slice(indexes, func(i, j int) bool { return after[indexes[i]].Stake < after[indexes[j]].Stake })
+	}
+
+	var candidates []candidate
+	for b, d := range before {
+		indexes := byRoute[d.RouteCode]
+		start := sort.Search(len(indexes), func(i int) bool { return after[indexes[i]].Stake >= d.Stake-opts.StakeTolerance })
+		for _, a := range indexes[start:] {
+			e := after[a]
+			if e.Stake > d.Stake+opts.StakeTolerance {
+				break
+			}
+			if compatibleDirection(d.Direction, e.Direction) && opts.compatibleLane(d.Lane, e.Lane) && opts.compatibleType(d, e) {
+				candidates = append(candidates, candidate{b: b, a: a, offset: math.Abs(e.Stake - d.Stake), exact: d.Type == e.Type})
+			}
+		}
+	}
+	sort.SliceStable(candidates, func(i, j int) bool {
+		if candidates[i].offset != candidates[j].offset {
+			return candidates[i].offset < candidates[j].offset
+		}
+		return candidates[i].exact && !candidates[j].exact
+	})
+
+	matchedBefore := make(map[int]int, len(candidates))
+	matchedAfter := make(map[int]bool, len(candidates))
+	for _, c := range candidates {
+		if _, ok := matchedBefore[c.b]; ok || matchedAfter[c.a] {
+			continue
+		}
+		matchedBefore[c.b] = c.a
+		matchedAfter[c.a] = true
+	}
+
+	items := make([]diseaseMatchItem, 0, len(before)+len(after)-len(matchedBefore))
+	for b := range before {
+		a, ok := matchedBefore[b]
+		if !ok {
+			items = append(items, newDiseaseMatchItem(DiseaseRepaired, &before[b], nil))
+			continue
+		}
+		status, change := DiseasePersistent, opts.worsening(before[b], after[a])
+		if change != "" {
+			status = DiseaseWorsened
+		}
+		item := newDiseaseMatchItem(status, &before[b], &after[a])
+		item.Change = change
+		items = append(items, item)
+	}
+	for a := range after {
+		if !matchedAfter[a] {
+			items = append(items, newDiseaseMatchItem(DiseaseNew, nil, &after[a]))
+		}
+	}
+	sort.SliceStable(items, func(i, j int) bool {
+		if items[i].RouteCode != items[j].RouteCode {
+			return items[i].RouteCode < items[j].RouteCode
+		}
+		return items[i].stake() < items[j].stake()
+	})
+	return items
+}
+
+// diseaseMatchSummary 比对统计，RepairRate 为有效修复率（%），实施前没有病害时为空
+type diseaseMatchSummary struct {
+	RouteCode  string   `json:"routeCode"`
+	Before     int      `json:"before"`
+	After      int      `json:"after"`
+	Repaired   int      `json:"repaired"`
+	Persistent int      `json:"persistent"`
+	Worsened   int      `json:"worsened"`
+	New        int      `json:"new"`
+	RepairRate *float64 `json:"repairRate"`
+}
+
+func (s *diseaseMatchSummary) add(item diseaseMatchItem) {
+	if item.Before != nil {
+		s.Before++
+	}
+	if item.After != nil {
+		s.After++
+	}
+	switch item.Status {
+	case DiseaseRepaired:
+		s.Repaired++
+	case DiseasePersistent:
+		s.Persistent++
+	case DiseaseWorsened:
+		s.Worsened++
+	case DiseaseNew:
+		s.New++
+	}
+}
+
+func (s *diseaseMatchSummary) finish() {
+	if s.Before > 0 {
+		rate := round2(float64(s.Repaired) / float64(s.Before) * 100)
+		s.RepairRate = &rate
+	}
+}
+
+// comparisonSide 参与比对的一组病害记录：某批次中某一检测年度的记录
+type comparisonSide struct {
+	CampaignID   uint   `json:"campaignId"`
+	CampaignName string `json:"campaignName"`
+	Year         int    `json:"year"`
+	Count        int    `json:"count"`
+}
+
+func (s *comparisonSide) query(routeCode string) *gorm.DB {
+	db := dao.GetDB().Where("campaign_id = ? AND year = ?", s.CampaignID, s.Year)
+	if routeCode != "" {
+		db = db.Where("route_code = ?", routeCode)
+	}
+	return db
+}
+
+// diseaseBaseline 确定实施前的病害记录：指定了基准批次时使用该批次本年度的记录；
+// 否则优先使用本批次上传的上年病害数据，没有时使用年度更早的最近一个有病害记录的批次
+func diseaseBaseline(campaign *dao.Campaign, baselineID *uint) (*comparisonSide, error) {
+	db := dao.GetDB()
+	if baselineID != nil {
+		var baseline dao.Campaign
+		if err := db.First(&baseline, *baselineID).Error; err != nil {
+			return nil, err
+		}
+		return &comparisonSide{CampaignID: baseline.ID, CampaignName: baseline.Name, Year: baseline.Year}, nil
+	}
+
+	var count int64
+	if err := db.Model(&dao.Disease{}).Where("campaign_id = ? AND year = ?", campaign.ID, campaign.Year-1).Count(&count).Error; err != nil {
+		return nil, err
+	}
+	if count > 0 {
+		return &comparisonSide{CampaignID: campaign.ID, CampaignName: campaign.Name, Year: campaign.Year - 1}, nil
+	}
+	var baseline dao.Campaign
+	err := db.Where("year < ?", campaign.Year).
+		Where("EXISTS (SELECT 1 FROM diseases WHERE diseases.campaign_id = campaigns.id AND diseases.year = campaigns.year)").
+		Order("year DESC, id DESC").First(&baseline).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, errNoBaseline
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &comparisonSide{CampaignID: baseline.ID, CampaignName: baseline.Name, Year: baseline.Year}, nil
+}
+
+// diseaseComparison 批次病害与实施前病害的比对结果
+type diseaseComparison struct {
+	Before  *comparisonSide        `json:"before"`
+	After   *comparisonSide        `json:"after"`
+	Options diseaseMatchOptions    `json:"options"`
+	Summary *diseaseMatchSummary   `json:"summary"` // 全部路线合计
+	ByRoute []*diseaseMatchSummary `json:"byRoute"`
+	Items   []diseaseMatchItem     `json:"items"`
+}
+
+// compareCampaignDiseases 比对批次本年度的病害与实施前的病害，routeCode 不为空时只比对该路线
+func compareCampaignDiseases(campaign *dao.Campaign, baselineID *uint, routeCode string) (*diseaseComparison, error) {
+	beforeSide, err := diseaseBaseline(campaign, baselineID)
+	if err != nil {
+		return nil, err
+	}
+	afterSide := &comparisonSide{CampaignID: campaign.ID, CampaignName: campaign.Name, Year: campaign.Year}
+	if beforeSide.CampaignID == afterSide.CampaignID && beforeSide.Year == afterSide.Year {
+		return nil, errSameBaseline
+	}
+	var before, after []dao.Disease
+	if err = beforeSide.query(routeCode).Order("id").Find(&before).Error; err != nil {
+		return nil, err
+	}
+	if err = afterSide.query(routeCode).Order("id").Find(&after).Error; err != nil {
+		return nil, err
+	}
+	beforeSide.Count, afterSide.Count = len(before), len(after)
+
+	opts := loadDiseaseMatchOptions()
+	result := &diseaseComparison{
+		Before: beforeSide, After: afterSide, Options: opts,
+		Summary: &diseaseMatchSummary{RouteCode: "合计"},
+		ByRoute: make([]*diseaseMatchSummary, 0),
+		Items:   matchDiseases(before, after, opts),
+	}
+	routes := make(map[string]*diseaseMatchSummary)
+	for _, item := range result.Items {
+		route, ok := routes[item.RouteCode]
+		if !ok {
+			route = &diseaseMatchSummary{RouteCode: item.RouteCode}
+			routes[item.RouteCode] = route
+			result.ByRoute = append(result.ByRoute, route)
+		}
+		route.add(item)
+		result.Summary.add(item)
+	}
+	for _, route := range result.ByRoute {
+		route.finish()
+	}
+	result.Summary.finish()
+	return result, nil
+}
+
+// repairPlaceholders 养护工程和建设工程报告中的有效修复率，没有批次或无法比对时不提供，沿用计算结果中的取值
+func repairPlaceholders(reportType string, campaignID *uint) map[string]string {
+	if campaignID == nil || (reportType != ReportTypeMaintenance && reportType != ReportTypeConstruction) {
+		return nil
+	}
+	var campaign dao.Campaign
+	if err := dao.GetDB().First(&campaign, *campaignID).Error; err != nil {
+		logger.Logger.Errorf("读取抽检批次 %d 失败: %v", *campaignID, err)
+		return nil
+	}
+	comparison, err := compareCampaignDiseases(&campaign, nil, "")
+	if err != nil {
+		if !errors.Is(err, errNoBaseline) {
+			logger.Logger.Errorf("比对批次 %d 的病害失败: %v", campaign.ID, err)
+		}
+		return nil
+	}
+	if comparison.Summary.RepairRate == nil {
+		return nil
+	}
+	return map[string]string{repairRatePlaceholder: fmt.Sprintf("%.2f%%", *comparison.Summary.RepairRate)}
+}
+
+var (
+	diseaseSummaryColumns = []sheetColumn{
+		{"路线编号", "routeCode"},
+		{"实施前病害", "before"},
+		{"实施后病害", "after"},
+		{"已修复", "repaired"},
+		{"仍存在", "persistent"},
+		{"加重", "worsened"},
+		{"新增", "new"},
+		{"有效修复率(%)", "repairRate"},
+	}
+	diseaseMatchColumns = []sheetColumn{
+		{"路线编号", "routeCode"},
+		{"状态", "status"},
+		{"方向", "direction"},
+		{"实施前桩号", "beforeStake"},
+		{"实施前车道", "beforeLane"},
+		{"实施前病害", "beforeType"},
+		{"实施前程度", "beforeSeverity"},
+		{"实施前面积(m²)", "beforeArea"},
+		{"实施后桩号", "afterStake"},
+		{"实施后车道", "afterLane"},
+		{"实施后病害", "afterType"},
+		{"实施后程度", "afterSeverity"},
+		{"实施后面积(m²)", "afterArea"},
+		{"桩号差(m)", "offset"},
+		{"变化", "change"},
+	}
+	directionNames = map[string]string{RoadDirectionUp: "上行", RoadDirectionDown: "下行", RoadDirectionBoth: "双向"}
+)
+
+func diseaseSummaryRow(s *diseaseMatchSummary) map[string]any {
+	row := map[string]any{
+		"routeCode": s.RouteCode, "before": s.Before, "after": s.After,
+		"repaired": s.Repaired, "persistent": s.Persistent, "worsened": s.Worsened, "new": s.New,
+	}
+	if s.RepairRate != nil {
+		row["repairRate"] = *s.RepairRate
+	}
+	return row
+}
+
+func diseaseMatchRow(item diseaseMatchItem) map[string]any {
+	row := map[string]any{"routeCode": item.RouteCode, "status": item.StatusName, "change": item.Change}
+	for prefix, d := range map[string]*diseaseView{"before": item.Before, "after": item.After} {
+		if d == nil {
+			continue
+		}
+		row["direction"] = directionNames[d.Direction]
+		row[prefix+"Stake"] = d.StakeText
+		row[prefix+"Lane"] = d.Lane
+		row[prefix+"Type"] = d.Type
+		row[prefix+"Severity"] = d.Severity
+		row[prefix+"Area"] = d.Area
+	}
+	if item.Offset != nil {
+		row["offset"] = *item.Offset
+	}
+	return row
+}
+
+// writeDiseaseComparison 比对结果表：汇总、重复病害明细（仍存在和加重）、已修复病害、新增病害
+func writeDiseaseComparison(f *excelize.File, comparison *diseaseComparison) error {
+	summaryRows := make([]map[string]any, 0, len(comparison.ByRoute)+1)
+	for _, route := range comparison.ByRoute {
+		summaryRows = append(summaryRows, diseaseSummaryRow(route))
+	}
+	summaryRows = append(summaryRows, diseaseSummaryRow(comparison.Summary))
+	if err := writeSettingSheet(f, "汇总", diseaseSummaryColumns, summaryRows); err != nil {
+		return err
+	}
+
+	sheets := []struct {
+		Name     string
+		Statuses []string
+	}{
+		{"重复病害明细", []string{DiseasePersistent, DiseaseWorsened}},
+		{"已修复病害", []string{DiseaseRepaired}},
+		{"新增病害", []string{DiseaseNew}},
+	}
+	for _, sheet := range sheets {
+		rows := make([]map[string]any, 0)
+		for _, item := range comparison.Items {
+			if slices.Contains(sheet.Statuses, item.Status) {
+				rows = append(rows, diseaseMatchRow(item))
+			}
+		}
+		if err := writeSettingSheet(f, sheet.Name, diseaseMatchColumns, rows); err != nil {
+			return err
+		}
+	}
+	return f.DeleteSheet("Sheet1")
+}
+
+// GetCampaignDiseaseComparison 批次病害与实施前病害的比对结果和有效修复率。
+// baseline 指定基准批次，默认见 diseaseBaseline；routeCode 限定路线；status 只返回该分类的明细；
+// format=xlsx 时导出汇总和明细表
+func GetCampaignDiseaseComparison(c *gin.Context) {
+	campaign, ok := loadCampaign(c)
+	if !ok {
+		return
+	}
+	baselineID, err := parseCampaignRef(c.Query("baseline"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "无效的基准批次ID"})
+		return
+	}
+	status := c.Query("status")
+	if _, ok := diseaseStatusNames[status]; status != "" && !ok {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "status 应为 repaired、persistent、worsened 或 new"})
+		return
+	}
+	format := c.DefaultQuery("format", "json")
+	if format != "json" && format != "xlsx" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "format 应为 json 或 xlsx"})
+		return
+	}
+
+	comparison, err := compareCampaignDiseases(campaign, baselineID, strings.ToUpper(strings.TrimSpace(c.Query("routeCode"))))
+	if err != nil {
+		switch {
+		case errors.Is(err, gorm.ErrRecordNotFound):
+			c.JSON(http.StatusNotFound, gin.H{"error": "基准批次不存在"})
+		case errors.Is(err, errNoBaseline):
+			c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		case errors.Is(err, errSameBaseline):
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		default:
+			logger.Logger.Errorf("比对批次 %d 的病害失败: %v", campaign.ID, err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "病害比对失败"})
+		}
+		return
+	}
+
+	if format == "xlsx" {
+		f := excelize.NewFile()
+		defer f.Close()
+		if err = writeDiseaseComparison(f, comparison); err != nil {
+			logger.Logger.Errorf("生成病害比对表失败: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "生成病害比对表失败"})
+			return
+		}
+		filename := fmt.Sprintf("%s_病害比对.xlsx", campaign.Name)
+		c.Header("Content-Disposition", fmt.Sprintf("attachment; filename=\"%s\"; filename*=UTF-8''%s", filename, url.QueryEscape(filename)))
+		c.Header("Content-Type", "application/vnd.openxmlformats-officedocument.spreadsheetml.sheet")
+		if err = f.Write(c.Writer); err != nil {
+			logger.Logger.Errorf("写出病害比对表失败: %v", err)
+		}
+		return
+	}
+
+	if status != "" {
+		items := make([]diseaseMatchItem, 0)
+		for _, item := range comparison.Items {
+			if item.Status == status {
+				items = append(items, item)
+			}
+		}
+		comparison.Items = items
+	}
+	c.JSON(http.StatusOK, comparison)
+}
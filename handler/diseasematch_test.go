@@ -0,0 +1,191 @@
+package handler
+
+import (
+	"fmt"
+	"github.com/spf13/viper"
+	"ningxia_backend/dao"
+	"ningxia_backend/pkg/conf"
+	"slices"
+	"testing"
+)
+
+func testDiseaseMatchOptions() diseaseMatchOptions {
+	conf.Conf = viper.New()
+	conf.Conf.Set("diseaseMatch.stakeTolerance", 10)
+	conf.Conf.Set("diseaseMatch.laneTolerance", 1)
+	conf.Conf.Set("diseaseMatch.areaGrowth", 0.2)
+	conf.Conf.Set("diseaseMatch.compatible", []string{"crack, joint", "pothole"})
+	return loadDiseaseMatchOptions()
+}
+
+func TestLoadDiseaseMatchOptions(t *testing.T) {
+	opts := testDiseaseMatchOptions()
+	if opts.StakeTolerance != 10 || opts.LaneTolerance != 1 || opts.AreaGrowth != 0.2 {
+		t.Errorf("options = %+v", opts)
+	}
+	// 只有一个类别的分组没有意义，忽略
+	if want := [][]string{{"crack", "joint"}}; !slices.EqualFunc(opts.Compatible, want, slices.Equal) {
+		t.Errorf("compatible = %v, want %v", opts.Compatible, want)
+	}
+}
+
+// disease 构造测试用病害，默认上行、横向裂缝
+func disease(id uint, routeCode string, stake float64, modify ...func(*dao.Disease)) dao.Disease {
+	d := dao.Disease{ID: id, RouteCode: routeCode, Direction: RoadDirectionUp, Stake: stake, Type: "横向裂缝", Category: "crack"}
+	for _, m := range modify {
+		m(&d)
+	}
+	return d
+}
+
+func withDirection(direction string) func(*dao.Disease) {
+	return func(d *dao.Disease) { d.Direction = direction }
+}
+
+func withLane(lane string) func(*dao.Disease) {
+	return func(d *dao.Disease) { d.Lane = lane }
+}
+
+func withType(name, category string) func(*dao.Disease) {
+	return func(d *dao.Disease) { d.Type, d.Category = name, category }
+}
+
+func withSeverity(severity string, area float64) func(*dao.Disease) {
+	return func(d *dao.Disease) { d.Severity, d.Area = severity, area }
+}
+
+// matchOutcome 比对结果写成“状态 实施前ID-实施后ID”，未匹配的一方记为 0
+func matchOutcome(items []diseaseMatchItem) []string {
+	out := make([]string, len(items))
+	for i, item := range items {
+		var b, a uint
+		if item.Before != nil {
+			b = item.Before.ID
+		}
+		if item.After != nil {
+			a = item.After.ID
+		}
+		out[i] = fmt.Sprintf("%s %d-%d", item.Status, b, a)
+	}
+	return out
+}
+
+func TestMatchDiseases(t *testing.T) {
+	opts := testDiseaseMatchOptions()
+	tests := []struct {
+		name   string
+		before []dao.Disease
+		after  []dao.Disease
+		want   []string
+	}{
+		{"same position", []dao.Disease{disease(1, "G6", 1000)}, []dao.Disease{disease(11, "G6", 1000)},
+			[]string{"persistent 1-11"}},
+		{"within stake tolerance", []dao.Disease{disease(1, "G6", 1000)}, []dao.Disease{disease(11, "G6", 1010)},
+			[]string{"persistent 1-11"}},
+		{"beyond stake tolerance", []dao.Disease{disease(1, "G6", 1000)}, []dao.Disease{disease(11, "G6", 1010.5)},
+			[]string{"repaired 1-0", "new 0-11"}},
+		{"different route", []dao.Disease{disease(1, "G6", 1000)}, []dao.Disease{disease(11, "G22", 1000)},
+			[]string{"new 0-11", "repaired 1-0"}},
+		{"opposite direction", []dao.Disease{disease(1, "G6", 1000)}, []dao.Disease{disease(11, "G6", 1000, withDirection(RoadDirectionDown))},
+			[]string{"repaired 1-0", "new 0-11"}},
+		{"both directions match either", []dao.Disease{disease(1, "G6", 1000, withDirection(RoadDirectionBoth))},
+			[]dao.Disease{disease(11, "G6", 1000, withDirection(RoadDirectionDown))}, []string{"persistent 1-11"}},
+		{"adjacent lane", []dao.Disease{disease(1, "G6", 1000, withLane("1"))}, []dao.Disease{disease(11, "G6", 1000, withLane("第2车道"))},
+			[]string{"persistent 1-11"}},
+		{"lane beyond tolerance", []dao.Disease{disease(1, "G6", 1000, withLane("1"))}, []dao.Disease{disease(11, "G6", 1000, withLane("3"))},
+			[]string{"repaired 1-0", "new 0-11"}},
+		{"lane missing on one side", []dao.Disease{disease(1, "G6", 1000)}, []dao.Disease{disease(11, "G6", 1000, withLane("3"))},
+			[]string{"persistent 1-11"}},
+		{"non-numeric lanes must be equal", []dao.Disease{disease(1, "G6", 1000, withLane("左"))}, []dao.Disease{disease(11, "G6", 1000, withLane("右"))},
+			[]string{"repaired 1-0", "new 0-11"}},
+		{"incompatible category", []dao.Disease{disease(1, "G6", 1000)}, []dao.Disease{disease(11, "G6", 1000, withType("坑槽", "pothole"))},
+			[]string{"repaired 1-0", "new 0-11"}},
+		{"compatible category", []dao.Disease{disease(1, "G6", 1000)}, []dao.Disease{disease(11, "G6", 1000, withType("接缝破碎", "joint"))},
+			[]string{"persistent 1-11"}},
+		{"nearest after wins", []dao.Disease{disease(1, "G6", 1000)}, []dao.Disease{disease(11, "G6", 1008), disease(12, "G6", 997)},
+			[]string{"persistent 1-12", "new 0-11"}},
+		{"same name preferred at equal offset",
+			[]dao.Disease{disease(1, "G6", 1000)},
+			[]dao.Disease{disease(11, "G6", 1005, withType("纵向裂缝", "crack")), disease(12, "G6", 995)},
+			[]string{"persistent 1-12", "new 0-11"}},
+		{"each record matched once",
+			[]dao.Disease{disease(1, "G6", 1000), disease(2, "G6", 1002)},
+			[]dao.Disease{disease(11, "G6", 1001)},
+			[]string{"persistent 1-11", "repaired 2-0"}},
+		{"closest pair claims the after first",
+			[]dao.Disease{disease(1, "G6", 1000), disease(2, "G6", 1006)},
+			[]dao.Disease{disease(11, "G6", 1005), disease(12, "G6", 1012)},
+			[]string{"repaired 1-0", "persistent 2-11", "new 0-12"}},
+		{"severity increased", []dao.Disease{disease(1, "G6", 1000, withSeverity("轻", 0))},
+			[]dao.Disease{disease(11, "G6", 1000, withSeverity("重", 0))}, []string{"worsened 1-11"}},
+		{"severity decreased", []dao.Disease{disease(1, "G6", 1000, withSeverity("重", 0))},
+			[]dao.Disease{disease(11, "G6", 1000, withSeverity("轻", 0))}, []string{"persistent 1-11"}},
+		{"area grew beyond threshold", []dao.Disease{disease(1, "G6", 1000, withSeverity("", 1))},
+			[]dao.Disease{disease(11, "G6", 1000, withSeverity("", 1.3))}, []string{"worsened 1-11"}},
+		{"area grew within threshold", []dao.Disease{disease(1, "G6", 1000, withSeverity("", 1))},
+			[]dao.Disease{disease(11, "G6", 1000, withSeverity("", 1.1))}, []string{"persistent 1-11"}},
+		{"sorted by route and stake",
+			[]dao.Disease{disease(1, "G6", 3000), disease(2, "G22", 500)},
+			[]dao.Disease{disease(11, "G6", 2000), disease(12, "G6", 3005)},
+			[]string{"repaired 2-0", "new 0-11", "persistent 1-12"}},
+		{"no records", nil, nil, []string{}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := matchOutcome(matchDiseases(tt.before, tt.after, opts)); !slices.Equal(got, tt.want) {
+				t.Errorf("matchDiseases = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestMatchDiseasesDetail(t *testing.T) {
+	opts := testDiseaseMatchOptions()
+	items := matchDiseases(
+		[]dao.Disease{disease(1, "G6", 1000, withSeverity("轻", 2))},
+		[]dao.Disease{disease(11, "G6", 1003.456, withSeverity("中", 3))},
+		opts,
+	)
+	if len(items) != 1 {
+		t.Fatalf("items = %+v", items)
+	}
+	item := items[0]
+	if item.Status != DiseaseWorsened || item.StatusName != "加重" || item.RouteCode != "G6" {
+		t.Errorf("item = %+v", item)
+	}
+	if want := "程度由轻变为中，面积由 2 增至 3 m²"; item.Change != want {
+		t.Errorf("change = %q, want %q", item.Change, want)
+	}
+	if item.Offset == nil || *item.Offset != 3.46 {
+		t.Errorf("offset = %v, want 3.46", item.Offset)
+	}
+	if item.Before.StakeText != "K1+000" || item.After.StakeText != "K1+003.456" {
+		t.Errorf("stakes = %s, %s", item.Before.StakeText, item.After.StakeText)
+	}
+}
+
+func TestDiseaseMatchSummary(t *testing.T) {
+	opts := testDiseaseMatchOptions()
+	items := matchDiseases(
+		[]dao.Disease{disease(1, "G6", 1000), disease(2, "G6", 2000), disease(3, "G6", 3000, withSeverity("轻", 0)), disease(4, "G6", 4000)},
+		[]dao.Disease{disease(11, "G6", 1000), disease(13, "G6", 3000, withSeverity("重", 0)), disease(15, "G6", 5000)},
+		opts,
+	)
+	var s diseaseMatchSummary
+	for _, item := range items {
+		s.add(item)
+	}
+	s.finish()
+	if s.Before != 4 || s.After != 3 || s.Repaired != 2 || s.Persistent != 1 || s.Worsened != 1 || s.New != 1 {
+		t.Errorf("summary = %+v", s)
+	}
+	if s.RepairRate == nil || *s.RepairRate != 50 {
+		t.Errorf("repair rate = %v, want 50", s.RepairRate)
+	}
+
+	var empty diseaseMatchSummary
+	empty.finish()
+	if empty.RepairRate != nil {
+		t.Errorf("repair rate without baseline = %v, want nil", *empty.RepairRate)
+	}
+}
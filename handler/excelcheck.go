@@ -0,0 +1,340 @@
+package handler
+
+import (
+	"fmt"
+	"github.com/xuri/excelize/v2"
+	"maps"
+	"ningxia_backend/pkg/conf"
+	"ningxia_backend/pkg/logger"
+	"ningxia_backend/pkg/stake"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+const (
+	ColumnTypeText   = "text"
+	ColumnTypeNumber = "number"
+	ColumnTypeStake  = "stake"
+
+	maxExcelIssues = 200 // 单个文件最多返回的问题数
+)
+
+// excelColumnRule 列校验规则，Headers 为可接受的表头写法，第一个为标准名称
+type excelColumnRule struct {
+	Headers  []string `mapstructure:"headers"`
+	Type     string   `mapstructure:"type"`
+	Required bool     `mapstructure:"required"` // 表头中必须有该列
+	NotEmpty bool     `mapstructure:"notEmpty"` // 数据行中不能为空
+	Min      *float64 `mapstructure:"min"`
+	Max      *float64 `mapstructure:"max"`
+}
+
+// excelSheetRule 工作表校验规则，Name 为空表示第一个工作表
+type excelSheetRule struct {
+	Name      string            `mapstructure:"name"`
+	HeaderRow int               `mapstructure:"headerRow"` // 表头所在行，从 1 开始
+	Columns   []excelColumnRule `mapstructure:"columns"`
+}
+
+type excelIssue struct {
+	Sheet   string `json:"sheet"`
+	Row     int    `json:"row,omitempty"`
+	Column  string `json:"column,omitempty"`
+	Cell    string `json:"cell,omitempty"`
+	Message string `json:"message"`
+}
+
+type excelValidation struct {
+	Role      string       `json:"role"`
+	File      string       `json:"file"`
+	Valid     bool         `json:"valid"`
+	Truncated bool         `json:"truncated,omitempty"` // 问题过多时只返回前 maxExcelIssues 条
+	Issues    []excelIssue `json:"issues"`
+}
+
+func float64Ptr(v float64) *float64 {
+	return &v
+}
+
+func stakeColumn(header string) excelColumnRule {
+	return excelColumnRule{Headers: []string{header}, Type: ColumnTypeStake, Required: true, NotEmpty: true}
+}
+
+func routeColumn() excelColumnRule {
+	return excelColumnRule{Headers: []string{"路线编号", "路线代码", "路线编码"}, Type: ColumnTypeText, Required: true, NotEmpty: true}
+}
+
+func directionColumn() excelColumnRule {
+	return excelColumnRule{Headers: []string{"方向", "行车方向"}, Type: ColumnTypeText}
+}
+
+func laneColumn() excelColumnRule {
+	return excelColumnRule{Headers: []string{"车道", "车道号"}, Type: ColumnTypeText}
+}
+
+func indexColumn(header string, required bool) excelColumnRule {
+	return excelColumnRule{Headers: []string{header}, Type: ColumnTypeNumber, Required: required, Min: float64Ptr(0), Max: float64Ptr(100)}
+}
+
+// defaultExcelRules 各上传字段的默认校验规则，可在配置文件 excelRules 节点中按字段覆盖
+var defaultExcelRules = map[string][]excelSheetRule{
+	"managementDetailFile": {{HeaderRow: 1, Columns: []excelColumnRule{
+		{Headers: []string{"管养单位", "养护单位"}, Type: ColumnTypeText, Required: true, NotEmpty: true},
+		routeColumn(), directionColumn(), laneColumn(), stakeColumn("起点桩号"), stakeColumn("终点桩号"),
+	}}},
+	"unitLevelDetailFile": {{HeaderRow: 1, Columns: []excelColumnRule{
+		routeColumn(), directionColumn(), laneColumn(), stakeColumn("起点桩号"), stakeColumn("终点桩号"),
+		indexColumn("MQI", false), indexColumn("PQI", true),
+	}}},
+	"roadConditionFile": {{HeaderRow: 1, Columns: []excelColumnRule{
+		routeColumn(), directionColumn(), laneColumn(),
+		stakeColumn("起点桩号"), stakeColumn("终点桩号"),
+		indexColumn("PQI", true), indexColumn("PCI", false), indexColumn("RQI", false),
+		indexColumn("RDI", false), indexColumn("SRI", false),
+	}}},
+	"firstInspectionExcel": {{HeaderRow: 1, Columns: []excelColumnRule{
+		routeColumn(), directionColumn(), laneColumn(), stakeColumn("起点桩号"), stakeColumn("终点桩号"), indexColumn("PQI", true),
+	}}},
+	"secondInspectionExcel": {{HeaderRow: 1, Columns: []excelColumnRule{
+		routeColumn(), directionColumn(), laneColumn(), stakeColumn("起点桩号"), stakeColumn("终点桩号"), indexColumn("PQI", true),
+	}}},
+	"diseaseDataExcel": {{HeaderRow: 1, Columns: []excelColumnRule{
+		routeColumn(), directionColumn(),
+		{Headers: []string{"桩号", "起点桩号"}, Type: ColumnTypeStake, Required: true, NotEmpty: true},
+		{Headers: []string{"病害类型", "病害名称"}, Type: ColumnTypeText, Required: true, NotEmpty: true},
+		{Headers: []string{"长度", "长度(m)"}, Type: ColumnTypeNumber, Min: float64Ptr(0)},
+		{Headers: []string{"宽度", "宽度(m)"}, Type: ColumnTypeNumber, Min: float64Ptr(0)},
+		{Headers: []string{"面积", "面积(m2)", "面积(㎡)"}, Type: ColumnTypeNumber, Min: float64Ptr(0)},
+		laneColumn(),
+		{Headers: []string{"严重程度", "程度", "损坏程度"}, Type: ColumnTypeText},
+		{Headers: []string{"图片", "照片", "图片编号", "图像文件"}, Type: ColumnTypeText},
+	}}},
+}
+
+// excelRulesFor 获取上传字段的校验规则，配置文件优先
+func excelRulesFor(role string) ([]excelSheetRule, bool) {
+	key := "excelRules." + role
+	if conf.Conf.IsSet(key) {
+		var rules []excelSheetRule
+		if err := conf.Conf.UnmarshalKey(key, &rules); err == nil {
+			return rules, true
+		} else {
+			logger.Logger.Errorf("解析配置 %s 失败，使用默认规则: %v", key, err)
+		}
+	}
+	rules, ok := defaultExcelRules[role]
+	return rules, ok
+}
+
+func normalizeHeader(s string) string {
+	return strings.Join(strings.Fields(s), "")
+}
+
+// validateExcelFile 按上传字段的规则校验 Excel 的工作表、表头、类型和取值范围
+func validateExcelFile(role, path string) *excelValidation {
+	result := &excelValidation{Role: role, File: filepath.Base(path), Issues: []excelIssue{}}
+	addIssue := func(issue excelIssue) bool {
+		if len(result.Issues) >= maxExcelIssues {
+			result.Truncated = true
+			return false
+		}
+		result.Issues = append(result.Issues, issue)
+		return true
+	}
+	defer func() {
+		result.Valid = len(result.Issues) == 0
+	}()
+
+	rules, ok := excelRulesFor(role)
+	if !ok {
+		return result
+	}
+	if ext := strings.ToLower(filepath.Ext(path)); ext != ".xlsx" && ext != ".xlsm" {
+		addIssue(excelIssue{Message: fmt.Sprintf("不支持的文件格式 %s，请另存为 .xlsx", ext)})
+		return result
+	}
+
+	f, err := excelize.OpenFile(path)
+	if err != nil {
+		addIssue(excelIssue{Message: fmt.Sprintf("无法打开Excel文件: %v", err)})
+		return result
+	}
+	defer f.Close()
+
+	for _, sheetRule := range rules {
+		sheet := sheetRule.Name
+		if sheet == "" {
+			sheet = f.GetSheetName(0)
+		} else if idx, err := f.GetSheetIndex(sheet); err != nil || idx == -1 {
+			addIssue(excelIssue{Sheet: sheet, Message: "缺少工作表"})
+			continue
+		}
+		if !validateExcelSheet(f, sheet, sheetRule, addIssue) {
+			break
+		}
+	}
+	return result
+}
+
+// validateExcelSheet 校验单个工作表，addIssue 返回 false 表示问题数已达上限，停止校验
+func validateExcelSheet(f *excelize.File, sheet string, rule excelSheetRule, addIssue func(excelIssue) bool) bool {
+	headerRow := rule.HeaderRow
+	if headerRow <= 0 {
+		headerRow = 1
+	}
+
+	rows, err := f.Rows(sheet)
+	if err != nil {
+		return addIssue(excelIssue{Sheet: sheet, Message: fmt.Sprintf("读取工作表失败: %v", err)})
+	}
+	defer rows.Close()
+
+	columnIndex := make(map[int]excelColumnRule) // 列序号(从1开始) -> 规则
+	var columns []int
+	rowNum := 0
+	for rows.Next() {
+		rowNum++
+		cells, err := rows.Columns(excelize.Options{RawCellValue: true})
+		if err != nil {
+			if !addIssue(excelIssue{Sheet: sheet, Row: rowNum, Message: fmt.Sprintf("读取行失败: %v", err)}) {
+				return false
+			}
+			continue
+		}
+		if rowNum < headerRow {
+			continue
+		}
+
+		if rowNum == headerRow {
+			for _, colRule := range rule.Columns {
+				found := false
+				for i, cell := range cells {
+					for _, header := range colRule.Headers {
+						if normalizeHeader(cell) == normalizeHeader(header) {
+							columnIndex[i+1] = colRule
+							found = true
+						}
+					}
+				}
+				if !found && colRule.Required {
+					if !addIssue(excelIssue{Sheet: sheet, Row: headerRow, Column: colRule.Headers[0], Message: "表头缺少必需列"}) {
+						return false
+					}
+				}
+			}
+			columns = slices.Sorted(maps.Keys(columnIndex))
+			continue
+		}
+
+		if strings.TrimSpace(strings.Join(cells, "")) == "" {
+			continue
+		}
+		for _, col := range columns {
+			colRule := columnIndex[col]
+			value := ""
+			if col <= len(cells) {
+				value = strings.TrimSpace(cells[col-1])
+			}
+			message := checkExcelCell(value, colRule)
+			if message == "" {
+				continue
+			}
+			cellName, _ := excelize.CoordinatesToCellName(col, rowNum)
+			if !addIssue(excelIssue{Sheet: sheet, Row: rowNum, Column: colRule.Headers[0], Cell: cellName, Message: message}) {
+				return false
+			}
+		}
+	}
+	if rowNum < headerRow {
+		return addIssue(excelIssue{Sheet: sheet, Row: headerRow, Message: "工作表缺少表头行"})
+	}
+	return true
+}
+
+// checkExcelCell 校验单元格，返回问题描述，为空表示通过
+func checkExcelCell(value string, rule excelColumnRule) string {
+	if value == "" {
+		if rule.NotEmpty {
+			return "不能为空"
+		}
+		return ""
+	}
+
+	switch rule.Type {
+	case ColumnTypeNumber:
+		v, err := strconv.ParseFloat(value, 64)
+		if err != nil {
+			return fmt.Sprintf("'%s' 不是数字", value)
+		}
+		if rule.Min != nil && v < *rule.Min {
+			return fmt.Sprintf("%g 小于最小值 %g", v, *rule.Min)
+		}
+		if rule.Max != nil && v > *rule.Max {
+			return fmt.Sprintf("%g 大于最大值 %g", v, *rule.Max)
+		}
+	case ColumnTypeStake:
+		if _, err := stake.Parse(value); err != nil {
+			return fmt.Sprintf("'%s' 不是有效的桩号", value)
+		}
+	}
+	return ""
+}
+
+// excelRow 按列规则读取的数据行，Cells 以列规则的标准名称为键
+type excelRow struct {
+	Sheet string
+	Row   int
+	Cells map[string]string
+}
+
+// readExcelRows 按上传字段的第一个工作表规则定位表头，读取各数据行中规则列出的列，空行跳过。
+// 没有规则的字段返回空结果
+func readExcelRows(role, path string) ([]excelRow, error) {
+	rules, ok := excelRulesFor(role)
+	if !ok || len(rules) == 0 {
+		return nil, nil
+	}
+	rule := rules[0]
+	f, err := excelize.OpenFile(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	sheet := rule.Name
+	if sheet == "" {
+		sheet = f.GetSheetName(0)
+	}
+	rows, err := f.GetRows(sheet, excelize.Options{RawCellValue: true})
+	if err != nil {
+		return nil, err
+	}
+	headerRow := max(rule.HeaderRow, 1)
+	if len(rows) < headerRow {
+		return nil, nil
+	}
+
+	index := make(map[string]int) // 标准名称 -> 列序号(从0开始)
+	for i, cell := range rows[headerRow-1] {
+		for _, colRule := range rule.Columns {
+			if slices.ContainsFunc(colRule.Headers, func(h string) bool { return normalizeHeader(h) == normalizeHeader(cell) }) {
+				index[colRule.Headers[0]] = i
+			}
+		}
+	}
+	var result []excelRow
+	for i, row := range rows[headerRow:] {
+		if strings.TrimSpace(strings.Join(row, "")) == "" {
+			continue
+		}
+		cells := make(map[string]string, len(index))
+		for header, col := range index {
+			if col < len(row) {
+				cells[header] = strings.TrimSpace(row[col])
+			}
+		}
+		result = append(result, excelRow{Sheet: sheet, Row: headerRow + i + 1, Cells: cells})
+	}
+	return result, nil
+}
@@ -2,15 +2,17 @@ package handler
 
 import (
 	"bytes"
+	"errors"
 	"fmt"
 	"github.com/gin-gonic/gin"
-	"github.com/gomarkdown/markdown"
 	"github.com/pdfcpu/pdfcpu/pkg/api"
 	"github.com/pdfcpu/pdfcpu/pkg/pdfcpu/model"
 	"github.com/pdfcpu/pdfcpu/pkg/pdfcpu/types"
-	"io"
+	"gorm.io/gorm"
 	"net/http"
 	"net/url"
+	"ningxia_backend/dao"
+	"ningxia_backend/pkg/conf"
 	"ningxia_backend/pkg/logger"
 	"os"
 	"os/exec"
@@ -73,60 +75,76 @@ func ExportReportHandler(c *gin.Context) {
 		return
 	}
 
-	htmlContentBytes := markdown.ToHTML(mdContent, nil, nil)
-	htmlContentString := string(htmlContentBytes)
-
-	// 保留之前的字体大小样式，但移除水印相关的 CSS
-	htmlHeadContent := `
-<head>
-    <meta charset="utf-8">
-    <style>
-        body {
-            font-family: "Microsoft YaHei", sans-serif;
-            font-size: 20pt; /* 用户找到合适的字号 */
-            line-height: 1.5;
-            /* 移除 position: relative; 如果body不作为其他元素的定位上下文 */
-        }
-        h1 { font-size: 36pt; margin-top: 20pt; margin-bottom: 10pt; }
-        h2 { font-size: 32pt; margin-top: 18pt; margin-bottom: 8pt; }
-        h3 { font-size: 28pt; margin-top: 16pt; margin-bottom: 6pt; }
-        p { font-size: 20pt; margin-top: 6pt; margin-bottom: 6pt; }
-        table {
-             border-collapse: collapse;
-             width: 100%;
-             margin-top: 10pt;
-             margin-bottom: 10pt;
-             font-size: 9pt;
-        }
-        th, td {
-            border: 1px solid #ddd;
-            padding: 8pt;
-            text-align: left;
-        }
-        th { background-color: #f2f2f2; }
-    </style>
-</head>
-`
-
-	htmlWithHead := ""
-	htmlTagIndex := strings.Index(htmlContentString, "<html")
-	if htmlTagIndex != -1 {
-		htmlTagEndIndex := strings.Index(htmlContentString[htmlTagIndex:], ">")
-		if htmlTagEndIndex != -1 {
-			insertIndex := htmlTagIndex + htmlTagEndIndex + 1
-			htmlWithHead = htmlContentString[:insertIndex] + "\n" + htmlHeadContent + "\n" + htmlContentString[insertIndex:]
-		} else {
-			htmlWithHead = htmlHeadContent + "\n" + htmlContentString
+	qrPosition := req.QR
+	if qrPosition == "" {
+		qrPosition = conf.Conf.GetString("verify.qrPosition")
+	}
+	if qrPosition != QRPositionNone && qrPosition != QRPositionCover && qrPosition != QRPositionFooter {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "二维码位置参数有误"})
+		return
+	}
+	if qrPosition != QRPositionNone && publicBaseURL() == "" {
+		if req.QR != "" {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "未配置对外访问地址 server.publicBaseUrl，无法加盖核验二维码"})
+			return
 		}
-	} else {
-		htmlWithHead = htmlHeadContent + "\n" + htmlContentString
+		logger.Logger.Warnf("未配置对外访问地址 server.publicBaseUrl，报告 %s 不加盖核验二维码", baseName)
+		qrPosition = QRPositionNone
+	}
+
+	var report *dao.Report
+	if req.Archive || req.Attach || qrPosition != QRPositionNone {
+		report, err = loadReportRecord(baseName)
+		if err != nil {
+			logger.Logger.Errorf("获取报告 %s 的目录信息失败: %v", baseName, err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "获取报告目录信息失败"})
+			return
+		}
+	}
+	var meta archiveMeta
+	if req.Archive {
+		meta = newArchiveMeta(report)
+	}
+
+	reportType, _, _ := parseReportBaseName(baseName)
+	theme, err := resolveTheme(req.Theme, reportType)
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": fmt.Sprintf("未找到主题'%s'", req.Theme)})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "获取样式主题失败"})
+		return
+	}
+	if req.Archive {
+		// 归档模式使用随程序分发的方正黑体，保证字体可以嵌入PDF
+		theme.FontFamily = bundledFontFamily
 	}
 
+	fontURL, err := bundledFontFileURL()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "服务器配置错误 (无法确定字体文件路径)"})
+		return
+	}
+	htmlWithHead, err := buildReportHTML(mdContent, theme, fontURL)
+	if err != nil {
+		logger.Logger.Errorf("渲染报告 %s 失败: %v", filename, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "渲染报告失败"})
+		return
+	}
+	// 图片内嵌为 data URI，wkhtmltopdf 无需再通过网络读取
+	htmlWithHead, err = resolveImageLinks(htmlWithHead, "")
+	if err != nil {
+		logger.Logger.Warnf("报告 %s 图片链接处理失败: %v", filename, err)
+	}
 	htmlContentToPdf := []byte(htmlWithHead)
 
 	// 调用 wkhtmltopdf 工具生成 PDF 字节流
-	// 移除 --enable-local-file-access 选项，因为不再需要加载本地水印图片
-	cmd := exec.Command(wkhtmltopdfPath, "-", "-")
+	args := wkhtmltopdfArgs(theme)
+	if req.Archive {
+		args = append(args, "--title", meta.Title)
+	}
+	cmd := exec.Command(wkhtmltopdfPath, append(args, "-", "-")...)
 	cmd.Stdin = bytes.NewReader(htmlContentToPdf)
 
 	var pdfBytesBuffer bytes.Buffer // 用于存放 wkhtmltopdf 生成的原始 PDF 字节流
@@ -142,95 +160,107 @@ func ExportReportHandler(c *gin.Context) {
 	}
 	logger.Logger.Infof("stderr: %s", stderr.String())
 
-	// 设置 HTTP 响应头
-	c.Header("Content-Type", "application/pdf")
-
-	downloadFilename := strings.TrimSuffix(filename, ".md") + ".pdf"
-	encodedFilename := url.QueryEscape(downloadFilename)
-	contentDisposition := fmt.Sprintf("attachment; filename*=utf-8''%s", encodedFilename)
-	c.Header("Content-Disposition", contentDisposition)
-
+	pdfBytes := pdfBytesBuffer.Bytes()
 	if req.WmContent != "" {
-		tempPdfPath := filepath.Join(pdfDir, baseName+".pdf")
-		err = os.WriteFile(tempPdfPath, pdfBytesBuffer.Bytes(), 0644)
+		pdfBytes, err = addTextWatermarks(pdfBytes, req)
 		if err != nil {
-			logger.Logger.Errorf("将 PDF 内容写入临时文件 %s 失败: %v", tempPdfPath, err)
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "写入临时文件失败"})
+			logger.Logger.Errorf("PDF添加水印失败: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "PDF添加水印失败"})
 			return
 		}
-		defer os.Remove(tempPdfPath)
+	}
 
-		rsForWatermarking, err := os.Open(tempPdfPath)
+	if qrPosition != QRPositionNone {
+		pdfBytes, err = stampVerificationQR(pdfBytes, report, qrPosition)
 		if err != nil {
-			logger.Logger.Errorf("无法重新打开临时文件 %s 进行水印操作: %v", tempPdfPath, err)
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "服务器内部错误 (准备水印数据失败)"})
+			logger.Logger.Errorf("PDF加盖核验二维码失败: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "PDF加盖核验二维码失败"})
 			return
 		}
-		defer rsForWatermarking.Close()
-
-		// --- 使用 pdfcpu 添加水印 ---
-		// --- 水印配置数据 ---
-		cnf := model.NewDefaultConfiguration()
-		cnf.Unit = types.POINTS
+	}
 
-		baseDesc := fmt.Sprintf("points:%d, rotation:%.2f, opacity:%.2f, fillcolor:%s, font:%s", req.WmFontSize, req.WmAngle, req.WmOpacity/100, req.WmColor, UserFont)
-		positions := []string{
-			"pos:tl, off:55 -100", "pos:tr, off:-55 -200",
-			"pos:bl, off:55 250", "pos:br, off:-55 150",
+	// PDF/A-3 允许嵌入注明 MIME 类型和关联关系的附件，附件需要在写入归档元数据之前加入。
+	// 不可用而跳过的附件通过响应头告知客户端
+	if req.Attach {
+		attachments, skipped := reportAttachments(report)
+		if len(skipped) > 0 {
+			c.Header("X-Skipped-Attachments", url.QueryEscape(strings.Join(skipped, ",")))
 		}
-		watermarksForOnePage := make([]*model.Watermark, 0, len(positions))
-		for _, posStr := range positions {
-			fullDesc := fmt.Sprintf("%s, %s", baseDesc, posStr)
-			wm, err := api.TextWatermark(req.WmContent, fullDesc, false, false, cnf.Unit)
-			if err != nil {
-				logger.Logger.Errorf("创建水印失败 (描述: '%s'): %v", fullDesc, err)
-				continue
-			}
-			watermarksForOnePage = append(watermarksForOnePage, wm)
+		pdfBytes, err = embedAttachments(pdfBytes, attachments)
+		if err != nil {
+			logger.Logger.Errorf("PDF嵌入附件失败: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "PDF嵌入附件失败"})
+			return
 		}
+	}
 
-		ctx, err := api.ReadContextFile(tempPdfPath)
+	if req.Archive {
+		pdfBytes, err = makeArchivalPDF(pdfBytes, meta)
 		if err != nil {
-			logger.Logger.Errorf("读取PDF信息失败: %v", err)
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "读取PDF信息失败"})
+			logger.Logger.Errorf("生成归档PDF失败: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "生成归档PDF失败"})
 			return
 		}
-		pageCount := ctx.PageCount
-		if pageCount == 0 {
-			logger.Logger.Errorf("PDF文件没有页面")
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "PDF文件没有页面"})
+		if problems := checkArchivalConformance(pdfBytes); len(problems) > 0 {
+			logger.Logger.Errorf("归档PDF %s 未通过检查: %v", baseName, problems)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "归档PDF未通过检查", "problems": problems})
 			return
 		}
-		watermarkMap := make(map[int][]*model.Watermark)
-		for i := 1; i <= pageCount; i++ {
-			watermarkMap[i] = watermarksForOnePage
-		}
+	}
 
-		watermarkedPdfBuffer := new(bytes.Buffer)
-		err = api.AddWatermarksSliceMap(rsForWatermarking, watermarkedPdfBuffer, watermarkMap, cnf)
-		if err != nil {
-			logger.Logger.Errorf("添加水印到字节流失败: %v", err)
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "PDF添加水印失败"})
-			return
-		}
+	// 设置 HTTP 响应头
+	c.Header("Content-Type", "application/pdf")
+
+	downloadFilename := strings.TrimSuffix(filename, ".md") + ".pdf"
+	encodedFilename := url.QueryEscape(downloadFilename)
+	contentDisposition := fmt.Sprintf("attachment; filename*=utf-8''%s", encodedFilename)
+	c.Header("Content-Disposition", contentDisposition)
+	c.Header("Content-Length", fmt.Sprintf("%d", len(pdfBytes)))
 
-		// **使用添加水印后 buffer 的大小**
-		c.Header("Content-Length", fmt.Sprintf("%d", watermarkedPdfBuffer.Len()))
+	_, err = c.Writer.Write(pdfBytes)
+	if err != nil {
+		logger.Logger.Errorf("将 PDF 响应写入客户端失败: %v\n", err)
+	}
+}
 
-		// **将添加水印后 buffer 的内容写入 HTTP 响应体**
-		_, err = io.Copy(c.Writer, watermarkedPdfBuffer)
-		if err != nil {
-			logger.Logger.Errorf("将 PDF 响应写入客户端失败: %v\n", err)
-		}
-	} else {
-		// --- 不需要添加水印，直接返回原始 PDF ---
-		// **使用 wkhtmltopdf 生成的原始 PDF buffer 的大小**
-		c.Header("Content-Length", fmt.Sprintf("%d", pdfBytesBuffer.Len()))
+// addTextWatermarks 使用 pdfcpu 在每一页四个位置添加文字水印
+func addTextWatermarks(pdf []byte, req exportPDFReq) ([]byte, error) {
+	// --- 水印配置数据 ---
+	cnf := model.NewDefaultConfiguration()
+	cnf.Unit = types.POINTS
 
-		// **将原始 PDF buffer 的内容写入 HTTP 响应体**
-		_, err = io.Copy(c.Writer, &pdfBytesBuffer)
+	baseDesc := fmt.Sprintf("points:%d, rotation:%.2f, opacity:%.2f, fillcolor:%s, font:%s", req.WmFontSize, req.WmAngle, req.WmOpacity/100, req.WmColor, UserFont)
+	positions := []string{
+		"pos:tl, off:55 -100", "pos:tr, off:-55 -200",
+		"pos:bl, off:55 250", "pos:br, off:-55 150",
+	}
+	watermarksForOnePage := make([]*model.Watermark, 0, len(positions))
+	for _, posStr := range positions {
+		fullDesc := fmt.Sprintf("%s, %s", baseDesc, posStr)
+		wm, err := api.TextWatermark(req.WmContent, fullDesc, false, false, cnf.Unit)
 		if err != nil {
-			logger.Logger.Errorf("将原始 PDF 响应写入客户端失败: %v\n", err)
+			logger.Logger.Errorf("创建水印失败 (描述: '%s'): %v", fullDesc, err)
+			continue
 		}
+		watermarksForOnePage = append(watermarksForOnePage, wm)
+	}
+
+	ctx, err := api.ReadAndValidate(bytes.NewReader(pdf), model.NewDefaultConfiguration())
+	if err != nil {
+		return nil, fmt.Errorf("读取PDF信息失败: %w", err)
+	}
+	pageCount := ctx.PageCount
+	if pageCount == 0 {
+		return nil, fmt.Errorf("PDF文件没有页面")
+	}
+	watermarkMap := make(map[int][]*model.Watermark)
+	for i := 1; i <= pageCount; i++ {
+		watermarkMap[i] = watermarksForOnePage
+	}
+
+	watermarkedPdfBuffer := new(bytes.Buffer)
+	if err = api.AddWatermarksSliceMap(bytes.NewReader(pdf), watermarkedPdfBuffer, watermarkMap, cnf); err != nil {
+		return nil, err
 	}
+	return watermarkedPdfBuffer.Bytes(), nil
 }
@@ -0,0 +1,341 @@
+package handler
+
+import (
+	"encoding/xml"
+	"fmt"
+	"github.com/gin-gonic/gin"
+	"net/http"
+	"net/url"
+	"ningxia_backend/dao"
+	"ningxia_backend/pkg/geo"
+	"ningxia_backend/pkg/logger"
+	"ningxia_backend/pkg/stake"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+const (
+	mapFormatGeoJSON = "geojson"
+	mapFormatKML     = "kml"
+)
+
+// mapSegmentRoles 含逐段 PQI 的上传字段，按优先顺序选取其中一种，避免同一路段重复出现
+var mapSegmentRoles = []string{"roadConditionFile", "unitLevelDetailFile", "firstInspectionExcel", "secondInspectionExcel"}
+
+// pqiGrade PQI 评定等级及地图颜色（RGB），按 JTG 5210 优、良、中、次、差划分
+type pqiGrade struct {
+	Name  string
+	Min   float64
+	Color string
+}
+
+var pqiGrades = []pqiGrade{
+	{"优", 90, "#2E7D32"},
+	{"良", 80, "#1E88E5"},
+	{"中", 70, "#FDD835"},
+	{"次", 60, "#FB8C00"},
+	{"差", 0, "#E53935"},
+}
+
+func gradeForPQI(pqi float64) pqiGrade {
+	for _, g := range pqiGrades {
+		if pqi >= g.Min {
+			return g
+		}
+	}
+	return pqiGrades[len(pqiGrades)-1]
+}
+
+// inspectionSegment 检测数据中的一个评定单元
+type inspectionSegment struct {
+	RouteCode string
+	Direction string
+	Start     stake.Stake
+	End       stake.Stake
+	PQI       float64
+}
+
+// mapFeature 已定位到中心线上的评定单元
+type mapFeature struct {
+	inspectionSegment
+	Grade pqiGrade
+	Line  geo.Line
+}
+
+// readInspectionSegments 读取检测数据中的路线、方向、起止桩号和 PQI。
+// 取值无效的行跳过，这些行在上传校验时已经报告
+func readInspectionSegments(file dao.DatasetFile) ([]inspectionSegment, error) {
+	rows, err := readExcelRows(file.Role, file.Path)
+	if err != nil {
+		return nil, err
+	}
+	var segments []inspectionSegment
+	for _, row := range rows {
+		start, err1 := stake.Parse(row.Cells["起点桩号"])
+		end, err2 := stake.Parse(row.Cells["终点桩号"])
+		pqi, err3 := strconv.ParseFloat(row.Cells["PQI"], 64)
+		if err1 != nil || err2 != nil || err3 != nil {
+			continue
+		}
+		direction, ok := roadDirections[row.Cells["方向"]]
+		if !ok {
+			direction = RoadDirectionBoth
+		}
+		if start > end {
+			start, end = end, start
+		}
+		segments = append(segments, inspectionSegment{
+			RouteCode: strings.ToUpper(row.Cells["路线编号"]),
+			Direction: direction,
+			Start:     start,
+			End:       end,
+			PQI:       pqi,
+		})
+	}
+	return segments, nil
+}
+
+// collectInspectionSegments 从数据集文件中读取评定单元，role 为空时按 mapSegmentRoles 的顺序选取第一种有数据的字段
+func collectInspectionSegments(files []dao.DatasetFile, role string) ([]inspectionSegment, string, error) {
+	roles := mapSegmentRoles
+	if role != "" {
+		roles = []string{role}
+	}
+	for _, r := range roles {
+		var segments []inspectionSegment
+		seen := make(map[string]bool)
+		for _, file := range files {
+			ext := strings.ToLower(filepath.Ext(file.Path))
+			if file.Role != r || seen[file.SHA256] || (ext != ".xlsx" && ext != ".xlsm") {
+				continue
+			}
+			seen[file.SHA256] = true
+			fileSegments, err := readInspectionSegments(file)
+			if err != nil {
+				return nil, "", fmt.Errorf("读取 %s 失败: %w", file.Name, err)
+			}
+			segments = append(segments, fileSegments...)
+		}
+		if len(segments) > 0 {
+			return segments, r, nil
+		}
+	}
+	return nil, "", nil
+}
+
+// locateSegments 将评定单元定位到路线中心线上，返回无法定位的单元数
+func locateSegments(segments []inspectionSegment) ([]mapFeature, int, error) {
+	locators := make(map[string]*routeLocator)
+	features := make([]mapFeature, 0, len(segments))
+	unlocated := 0
+	for _, s := range segments {
+		locator, ok := locators[s.RouteCode]
+		if !ok {
+			var err error
+			if locator, err = newRouteLocator(s.RouteCode); err != nil {
+				return nil, 0, err
+			}
+			locators[s.RouteCode] = locator
+		}
+		line, err := locator.Segment(s.Direction, s.Start, s.End)
+		if err != nil {
+			unlocated++
+			continue
+		}
+		features = append(features, mapFeature{inspectionSegment: s, Grade: gradeForPQI(s.PQI), Line: line})
+	}
+	return features, unlocated, nil
+}
+
+func (f mapFeature) properties() gin.H {
+	return gin.H{
+		"routeCode":  f.RouteCode,
+		"direction":  f.Direction,
+		"startStake": f.Start.String(),
+		"endStake":   f.End.String(),
+		"pqi":        f.PQI,
+		"grade":      f.Grade.Name,
+		"color":      f.Grade.Color,
+		// simplestyle 约定，geojson.io 等工具直接按此着色
+		"stroke":       f.Grade.Color,
+		"stroke-width": 4,
+	}
+}
+
+func writeMapGeoJSON(c *gin.Context, name string, features []mapFeature, unlocated int) {
+	items := make([]gin.H, 0, len(features))
+	for _, f := range features {
+		items = append(items, gin.H{
+			"type":       "Feature",
+			"geometry":   gin.H{"type": "LineString", "coordinates": f.Line},
+			"properties": f.properties(),
+		})
+	}
+	c.Header("Content-Disposition", fmt.Sprintf("attachment; filename*=UTF-8''%s", url.PathEscape(name+".geojson")))
+	c.Header("Content-Type", "application/geo+json")
+	c.JSON(http.StatusOK, gin.H{"type": "FeatureCollection", "name": name, "unlocated": unlocated, "features": items})
+}
+
+type kmlStyle struct {
+	ID        string `xml:"id,attr"`
+	LineColor string `xml:"LineStyle>color"`
+	LineWidth int    `xml:"LineStyle>width"`
+}
+
+type kmlData struct {
+	Name  string `xml:"name,attr"`
+	Value string `xml:"value"`
+}
+
+type kmlPlacemark struct {
+	Name         string    `xml:"name"`
+	Description  string    `xml:"description"`
+	StyleURL     string    `xml:"styleUrl"`
+	ExtendedData []kmlData `xml:"ExtendedData>Data"`
+	Coordinates  string    `xml:"LineString>coordinates"`
+}
+
+type kmlDocument struct {
+	XMLName     xml.Name       `xml:"http://www.opengis.net/kml/2.2 kml"`
+	Name        string         `xml:"Document>name"`
+	Description string         `xml:"Document>description"`
+	Styles      []kmlStyle     `xml:"Document>Style"`
+	Placemarks  []kmlPlacemark `xml:"Document>Placemark"`
+}
+
+// kmlColor 将 #RRGGBB 转换为 KML 的 aabbggrr
+func kmlColor(rgb string) string {
+	rgb = strings.TrimPrefix(rgb, "#")
+	return strings.ToLower("ff" + rgb[4:6] + rgb[2:4] + rgb[0:2])
+}
+
+func writeMapKML(c *gin.Context, name string, features []mapFeature, unlocated int) {
+	doc := kmlDocument{Name: name, Description: fmt.Sprintf("共 %d 个评定单元，%d 个无法定位", len(features)+unlocated, unlocated)}
+	for i, g := range pqiGrades {
+		doc.Styles = append(doc.Styles, kmlStyle{ID: fmt.Sprintf("pqi%d", i), LineColor: kmlColor(g.Color), LineWidth: 4})
+	}
+	for _, f := range features {
+		coords := make([]string, len(f.Line))
+		for i, p := range f.Line {
+			coords[i] = strconv.FormatFloat(p.Lon(), 'f', 7, 64) + "," + strconv.FormatFloat(p.Lat(), 'f', 7, 64)
+		}
+		var data []kmlData
+		for _, key := range []string{"routeCode", "direction", "startStake", "endStake", "pqi", "grade"} {
+			data = append(data, kmlData{Name: key, Value: fmt.Sprint(f.properties()[key])})
+		}
+		doc.Placemarks = append(doc.Placemarks, kmlPlacemark{
+			Name:         fmt.Sprintf("%s %s~%s", f.RouteCode, f.Start, f.End),
+			Description:  fmt.Sprintf("PQI %g（%s）", f.PQI, f.Grade.Name),
+			StyleURL:     fmt.Sprintf("#pqi%d", slices.IndexFunc(pqiGrades, func(g pqiGrade) bool { return g.Name == f.Grade.Name })),
+			ExtendedData: data,
+			Coordinates:  strings.Join(coords, " "),
+		})
+	}
+	out, err := xml.MarshalIndent(doc, "", "  ")
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "生成KML失败"})
+		return
+	}
+	c.Header("Content-Disposition", fmt.Sprintf("attachment; filename*=UTF-8''%s", url.PathEscape(name+".kml")))
+	c.Data(http.StatusOK, "application/vnd.google-earth.kml+xml", append([]byte(xml.Header), out...))
+}
+
+// exportInspectionMap 定位数据集中的评定单元并按 format 参数输出 GeoJSON 或 KML
+func exportInspectionMap(c *gin.Context, name string, files []dao.DatasetFile) {
+	format := strings.ToLower(c.DefaultQuery("format", mapFormatGeoJSON))
+	if format != mapFormatGeoJSON && format != mapFormatKML {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "format 应为 geojson 或 kml"})
+		return
+	}
+	role := c.Query("role")
+	if role != "" && !slices.Contains(mapSegmentRoles, role) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("role 应为 %s 之一", strings.Join(mapSegmentRoles, "、"))})
+		return
+	}
+
+	segments, role, err := collectInspectionSegments(files, role)
+	if err != nil {
+		logger.Logger.Errorf("读取 %s 检测数据失败: %v", name, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "读取检测数据失败"})
+		return
+	}
+	if len(segments) == 0 {
+		c.JSON(http.StatusNotFound, gin.H{"error": "没有含路线、桩号和 PQI 的检测数据"})
+		return
+	}
+	features, unlocated, err := locateSegments(segments)
+	if err != nil {
+		logger.Logger.Errorf("定位 %s 检测路段失败: %v", name, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "读取中心线失败"})
+		return
+	}
+	if unlocated > 0 {
+		logger.Logger.Infof("%s 使用 %s 数据，%d 个评定单元没有对应的中心线", name, role, unlocated)
+	}
+	if format == mapFormatKML {
+		writeMapKML(c, name, features, unlocated)
+	} else {
+		writeMapGeoJSON(c, name, features, unlocated)
+	}
+}
+
+// ExportReportMapHandler 导出报告所用检测数据的 PQI 分级地图
+func ExportReportMapHandler(c *gin.Context) {
+	baseName := strings.TrimSuffix(c.Param("filename"), ".md")
+	report, err := loadReportRecord(baseName)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": fmt.Sprintf("报告文件 '%s' 未找到", baseName)})
+		return
+	}
+	files, err := resolveDatasetFiles(report.DatasetIDs)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		return
+	}
+	exportInspectionMap(c, baseName, files)
+}
+
+// ExportCampaignMapHandler 导出批次全部数据集（含批次内报告使用的数据集）的 PQI 分级地图
+func ExportCampaignMapHandler(c *gin.Context) {
+	campaign, ok := loadCampaign(c)
+	if !ok {
+		return
+	}
+	files, err := campaignDatasetFiles(campaign.ID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "数据库查询失败"})
+		return
+	}
+	exportInspectionMap(c, campaign.Name, files)
+}
+
+// campaignDatasetFiles 批次关联的数据集和批次内报告使用的数据集中的全部文件
+func campaignDatasetFiles(campaignID uint) ([]dao.DatasetFile, error) {
+	var ids []string
+	if err := dao.GetDB().Model(&dao.Dataset{}).Where("campaign_id = ?", campaignID).Order("created_at").Pluck("id", &ids).Error; err != nil {
+		return nil, err
+	}
+	var reports []dao.Report
+	if err := dao.GetDB().Where("campaign_id = ?", campaignID).Find(&reports).Error; err != nil {
+		return nil, err
+	}
+	for _, report := range reports {
+		for _, id := range report.DatasetIDs {
+			if !slices.Contains(ids, id) {
+				ids = append(ids, id)
+			}
+		}
+	}
+	// 已被清理的数据集直接跳过
+	var datasets []dao.Dataset
+	if err := dao.GetDB().Preload("Files").Where("id IN ?", ids).Find(&datasets).Error; err != nil {
+		return nil, err
+	}
+	var files []dao.DatasetFile
+	for _, dataset := range datasets {
+		files = append(files, dataset.Files...)
+	}
+	return files, nil
+}
@@ -0,0 +1,563 @@
+package handler
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/gin-gonic/gin"
+	"github.com/jonas-p/go-shp"
+	"gorm.io/gorm"
+	"io"
+	"math"
+	"net/http"
+	"ningxia_backend/dao"
+	"ningxia_backend/pkg/geo"
+	"ningxia_backend/pkg/logger"
+	"ningxia_backend/pkg/stake"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// 中心线属性字段的常见写法，依次匹配，不区分大小写
+var (
+	geometryRouteKeys     = []string{"routeCode", "路线编号", "路线代码", "路线编码", "LXBM", "LXDM"}
+	geometryDirectionKeys = []string{"direction", "方向", "行车方向", "FX"}
+	geometryStartKeys     = []string{"startStake", "起点桩号", "QDZH"}
+	geometryEndKeys       = []string{"endStake", "止点桩号", "终点桩号", "ZDZH"}
+)
+
+// geometryFeature 导入文件中的一条中心线及其属性
+type geometryFeature struct {
+	Properties map[string]string
+	Line       geo.Line
+}
+
+type geometryIssue struct {
+	Feature   int    `json:"feature"` // 要素序号，从 1 开始
+	RouteCode string `json:"routeCode,omitempty"`
+	Message   string `json:"message"`
+}
+
+type geoJSONGeometry struct {
+	Type        string          `json:"type"`
+	Coordinates json.RawMessage `json:"coordinates"`
+}
+
+type geoJSONFeature struct {
+	Type       string           `json:"type"`
+	Geometry   *geoJSONGeometry `json:"geometry"`
+	Properties map[string]any   `json:"properties"`
+}
+
+type geoJSONDocument struct {
+	Type     string           `json:"type"`
+	Features []geoJSONFeature `json:"features"`
+	geoJSONFeature
+}
+
+func geometryProperty(props map[string]string, keys []string) string {
+	for _, key := range keys {
+		for name, value := range props {
+			if strings.EqualFold(strings.TrimSpace(name), key) {
+				return strings.TrimSpace(value)
+			}
+		}
+	}
+	return ""
+}
+
+func pointsToLine(coords [][]float64) (geo.Line, error) {
+	line := make(geo.Line, 0, len(coords))
+	for _, c := range coords {
+		if len(c) < 2 {
+			return nil, errors.New("坐标至少需要经度和纬度")
+		}
+		line = append(line, geo.Point{c[0], c[1]})
+	}
+	return line, nil
+}
+
+// parseGeoJSONGeometry 读取 LineString 或 MultiLineString，多段线按顺序首尾连接
+func parseGeoJSONGeometry(g *geoJSONGeometry) (geo.Line, error) {
+	if g == nil {
+		return nil, errors.New("缺少几何图形")
+	}
+	switch g.Type {
+	case "LineString":
+		var coords [][]float64
+		if err := json.Unmarshal(g.Coordinates, &coords); err != nil {
+			return nil, fmt.Errorf("坐标格式有误: %v", err)
+		}
+		return pointsToLine(coords)
+	case "MultiLineString":
+		var parts [][][]float64
+		if err := json.Unmarshal(g.Coordinates, &parts); err != nil {
+			return nil, fmt.Errorf("坐标格式有误: %v", err)
+		}
+		var line geo.Line
+		for _, part := range parts {
+			l, err := pointsToLine(part)
+			if err != nil {
+				return nil, err
+			}
+			line = append(line, l...)
+		}
+		return line, nil
+	default:
+		return nil, fmt.Errorf("不支持的几何类型 %s，中心线应为 LineString 或 MultiLineString", g.Type)
+	}
+}
+
+func parseGeoJSON(r io.Reader) ([]geometryFeature, []geometryIssue, error) {
+	var doc geoJSONDocument
+	if err := json.NewDecoder(r).Decode(&doc); err != nil {
+		return nil, nil, fmt.Errorf("无法解析GeoJSON: %v", err)
+	}
+	switch doc.Type {
+	case "FeatureCollection":
+	case "Feature":
+		doc.Features = []geoJSONFeature{doc.geoJSONFeature}
+	default:
+		return nil, nil, fmt.Errorf("GeoJSON 应为 FeatureCollection 或 Feature，实际为'%s'", doc.Type)
+	}
+
+	var features []geometryFeature
+	var issues []geometryIssue
+	for i, f := range doc.Features {
+		props := make(map[string]string, len(f.Properties))
+		for key, value := range f.Properties {
+			if value != nil {
+				props[key] = fmt.Sprint(value)
+			}
+		}
+		line, err := parseGeoJSONGeometry(f.Geometry)
+		if err != nil {
+			issues = append(issues, geometryIssue{Feature: i + 1, RouteCode: geometryProperty(props, geometryRouteKeys), Message: err.Error()})
+			continue
+		}
+		features = append(features, geometryFeature{Properties: props, Line: line})
+	}
+	return features, issues, nil
+}
+
+// parseShapefileZip 解压 ZIP 并读取其中唯一的 Shapefile（.shp、.shx、.dbf），属性为 GBK 编码时自动转换
+func parseShapefileZip(path string) ([]geometryFeature, []geometryIssue, error) {
+	files, _, err := extractArchive(path, filepath.Join(filepath.Dir(path), "extracted"))
+	if err != nil {
+		return nil, nil, fmt.Errorf("解压失败: %v", err)
+	}
+	var shpFiles []string
+	for _, file := range files {
+		if strings.EqualFold(filepath.Ext(file), ".shp") {
+			shpFiles = append(shpFiles, file)
+		}
+	}
+	if len(shpFiles) != 1 {
+		return nil, nil, fmt.Errorf("压缩包中应有且只有一个 .shp 文件，实际 %d 个", len(shpFiles))
+	}
+	// 属性表缺失时 go-shp 读取字段会越界，先行检查
+	base := strings.TrimSuffix(shpFiles[0], filepath.Ext(shpFiles[0]))
+	for _, ext := range []string{".shx", ".dbf"} {
+		if _, err = os.Stat(base + ext); err != nil {
+			return nil, nil, fmt.Errorf("压缩包中缺少 %s 文件", ext)
+		}
+	}
+	reader, err := shp.Open(shpFiles[0])
+	if err != nil {
+		return nil, nil, fmt.Errorf("无法读取Shapefile: %v", err)
+	}
+	defer reader.Close()
+
+	fields := reader.Fields()
+	var features []geometryFeature
+	var issues []geometryIssue
+	n := 0
+	for reader.Next() {
+		n++
+		props := make(map[string]string, len(fields))
+		for i, field := range fields {
+			// DBF 定长字段以空格或 NUL 补齐
+			value := strings.Trim(reader.Attribute(i), " \x00")
+			if !utf8.ValidString(value) {
+				if decoded, err := decodeGBK(value); err == nil {
+					value = decoded
+				}
+			}
+			props[field.String()] = value
+		}
+
+		// 多段线按顺序首尾连接
+		var points []shp.Point
+		_, shape := reader.Shape()
+		switch s := shape.(type) {
+		case *shp.PolyLine:
+			points = s.Points
+		case *shp.PolyLineZ:
+			points = s.Points
+		case *shp.PolyLineM:
+			points = s.Points
+		default:
+			issues = append(issues, geometryIssue{Feature: n, RouteCode: geometryProperty(props, geometryRouteKeys), Message: "中心线应为线要素（PolyLine）"})
+			continue
+		}
+		line := make(geo.Line, len(points))
+		for i, p := range points {
+			line[i] = geo.Point{p.X, p.Y}
+		}
+		features = append(features, geometryFeature{Properties: props, Line: line})
+	}
+	if err = reader.Err(); err != nil {
+		return nil, nil, fmt.Errorf("读取Shapefile失败: %v", err)
+	}
+	return features, issues, nil
+}
+
+// parseGeometryStake 属性中的桩号，数字按公里数解析
+func parseGeometryStake(value string) (float64, bool, error) {
+	if value == "" {
+		return 0, false, nil
+	}
+	s, err := stake.Parse(value)
+	if err != nil {
+		return 0, false, err
+	}
+	return s.Km(), true, nil
+}
+
+// routeExtent 路网登记中路线在该方向上的起止桩号（km），没有登记时 ok 为 false
+func routeExtent(routeCode, direction string) (start, end float64, ok bool, err error) {
+	var extent struct {
+		Start *float64
+		End   *float64
+	}
+	err = dao.GetDB().Model(&dao.Road{}).
+		Select("MIN(start_stake) AS start, MAX(end_stake) AS end").
+		Where("route_code = ? AND direction IN ?", routeCode, []string{direction, RoadDirectionBoth, RoadDirectionUp}).
+		Scan(&extent).Error
+	if err != nil || extent.Start == nil || extent.End == nil {
+		return 0, 0, false, err
+	}
+	return *extent.Start, *extent.End, true, nil
+}
+
+// buildRoadGeometry 校验要素属性并转换为中心线记录，未提供起止桩号时取路网登记中该路线的范围
+func buildRoadGeometry(feature geometryFeature, source string) (*dao.RoadGeometry, error) {
+	props := feature.Properties
+	g := &dao.RoadGeometry{
+		RouteCode: strings.ToUpper(geometryProperty(props, geometryRouteKeys)),
+		Source:    source,
+	}
+	if !routeCodeRegexp.MatchString(g.RouteCode) {
+		return nil, fmt.Errorf("路线编号'%s'无效，应为 G/S/X/Y 加数字", g.RouteCode)
+	}
+	direction, ok := roadDirections[geometryProperty(props, geometryDirectionKeys)]
+	if !ok {
+		return nil, fmt.Errorf("方向'%s'无效，应为上行、下行或双向", geometryProperty(props, geometryDirectionKeys))
+	}
+	g.Direction = direction
+	if err := feature.Line.Validate(); err != nil {
+		return nil, err
+	}
+
+	var hasStart, hasEnd bool
+	var err error
+	if g.StartStake, hasStart, err = parseGeometryStake(geometryProperty(props, geometryStartKeys)); err != nil {
+		return nil, fmt.Errorf("起点%w", err)
+	}
+	if g.EndStake, hasEnd, err = parseGeometryStake(geometryProperty(props, geometryEndKeys)); err != nil {
+		return nil, fmt.Errorf("止点%w", err)
+	}
+	if !hasStart || !hasEnd {
+		start, end, ok, err := routeExtent(g.RouteCode, g.Direction)
+		if err != nil {
+			return nil, err
+		}
+		if !ok {
+			return nil, fmt.Errorf("未提供起止桩号，且路网登记中没有路线 %s", g.RouteCode)
+		}
+		if !hasStart {
+			g.StartStake = start
+		}
+		if !hasEnd {
+			g.EndStake = end
+		}
+	}
+	if g.EndStake <= g.StartStake {
+		return nil, errors.New("止点桩号应大于起点桩号")
+	}
+
+	for _, p := range feature.Line {
+		g.Coordinates = append(g.Coordinates, [2]float64(p))
+	}
+	g.Length = math.Round(feature.Line.Length()*1000) / 1000
+	return g, nil
+}
+
+// ImportRoadGeometryHandler 导入路线中心线，支持 GeoJSON（.geojson/.json）和打包为 ZIP 的 Shapefile。
+// 坐标须为 WGS84 经纬度，点序与桩号增大方向一致；同一路线、方向已有的中心线整体替换。
+// dryRun=true 时只校验；任一要素有错误则不写入
+func ImportRoadGeometryHandler(c *gin.Context) {
+	dryRun, _ := strconv.ParseBool(c.Query("dryRun"))
+	file, err := c.FormFile("file")
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "请上传中心线文件"})
+		return
+	}
+
+	var features []geometryFeature
+	var issues []geometryIssue
+	switch ext := strings.ToLower(filepath.Ext(file.Filename)); ext {
+	case ".geojson", ".json":
+		src, err := file.Open()
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "读取上传文件失败"})
+			return
+		}
+		features, issues, err = parseGeoJSON(src)
+		src.Close()
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+	case ".zip":
+		tmpDir, err := os.MkdirTemp(uploadDir, "geometry-*")
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "创建临时目录失败"})
+			return
+		}
+		defer os.RemoveAll(tmpDir)
+		zipPath := filepath.Join(tmpDir, "upload.zip")
+		if err = c.SaveUploadedFile(file, zipPath); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "保存上传文件失败"})
+			return
+		}
+		if features, issues, err = parseShapefileZip(zipPath); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+	default:
+		c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("不支持的文件格式 %s，请上传 GeoJSON 或打包为 ZIP 的 Shapefile", ext)})
+		return
+	}
+	if issues == nil {
+		issues = make([]geometryIssue, 0)
+	}
+
+	geometries := make([]*dao.RoadGeometry, 0, len(features))
+	routes := make(map[string]bool)
+	for i, feature := range features {
+		g, err := buildRoadGeometry(feature, file.Filename)
+		if err != nil {
+			issues = append(issues, geometryIssue{Feature: i + 1, RouteCode: geometryProperty(feature.Properties, geometryRouteKeys), Message: err.Error()})
+			continue
+		}
+		geometries = append(geometries, g)
+		routes[g.RouteCode+"/"+g.Direction] = true
+	}
+
+	resp := gin.H{"dryRun": dryRun, "features": len(geometries), "routes": len(routes), "issues": issues, "valid": len(issues) == 0}
+	if dryRun {
+		c.JSON(http.StatusOK, resp)
+		return
+	}
+	if len(issues) > 0 || len(geometries) == 0 {
+		resp["error"] = "中心线文件有错误或没有线要素，未写入任何数据"
+		c.JSON(http.StatusBadRequest, resp)
+		return
+	}
+	err = dao.GetDB().Transaction(func(tx *gorm.DB) error {
+		for key := range routes {
+			routeCode, direction, _ := strings.Cut(key, "/")
+			if err := tx.Where("route_code = ? AND direction = ?", routeCode, direction).Delete(&dao.RoadGeometry{}).Error; err != nil {
+				return err
+			}
+		}
+		return tx.Create(geometries).Error
+	})
+	if err != nil {
+		logger.Logger.Errorf("导入路线中心线失败: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "导入路线中心线失败"})
+		return
+	}
+	resp["message"] = "路线中心线导入成功"
+	c.JSON(http.StatusOK, resp)
+}
+
+// GetRoadGeometry 以 GeoJSON 返回路线中心线，可按 routeCode 过滤
+func GetRoadGeometry(c *gin.Context) {
+	db := dao.GetDB().Order("route_code").Order("start_stake")
+	if routeCode := strings.ToUpper(strings.TrimSpace(c.Query("routeCode"))); routeCode != "" {
+		db = db.Where("route_code = ?", routeCode)
+	}
+	var geometries []dao.RoadGeometry
+	if err := db.Find(&geometries).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "数据库查询失败"})
+		return
+	}
+	features := make([]gin.H, 0, len(geometries))
+	for _, g := range geometries {
+		features = append(features, gin.H{
+			"type":     "Feature",
+			"geometry": gin.H{"type": "LineString", "coordinates": g.Coordinates},
+			"properties": gin.H{
+				"id":         g.ID,
+				"routeCode":  g.RouteCode,
+				"direction":  g.Direction,
+				"startStake": stake.FromKm(g.StartStake).String(),
+				"endStake":   stake.FromKm(g.EndStake).String(),
+				"length":     g.Length,
+				"source":     g.Source,
+			},
+		})
+	}
+	c.JSON(http.StatusOK, gin.H{"type": "FeatureCollection", "features": features})
+}
+
+func DeleteRoadGeometry(c *gin.Context) {
+	id, err := strconv.ParseUint(c.Param("id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "无效的中心线ID"})
+		return
+	}
+	result := dao.GetDB().Delete(&dao.RoadGeometry{}, id)
+	if result.Error != nil {
+		logger.Logger.Errorf("删除中心线失败: %v", result.Error)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "删除中心线失败"})
+		return
+	}
+	if result.RowsAffected == 0 {
+		c.JSON(http.StatusNotFound, gin.H{"error": "中心线不存在"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "中心线已删除"})
+}
+
+var errNoGeometry = errors.New("该路线没有中心线")
+
+// geometryPiece 一段中心线，start、end 为首末点的实际里程（米）
+type geometryPiece struct {
+	direction  string
+	line       geo.Line
+	length     float64
+	start, end float64
+}
+
+// routeLocator 路线的线性参照：桩号经断链换算为实际里程，再按比例定位到中心线上。
+// 中心线实测长度与桩号里程不一致时按比例分摊
+type routeLocator struct {
+	chain  *stake.Chain
+	pieces []geometryPiece
+}
+
+func newRouteLocator(routeCode string) (*routeLocator, error) {
+	chain, err := routeChain(routeCode)
+	if err != nil {
+		return nil, err
+	}
+	var geometries []dao.RoadGeometry
+	if err = dao.GetDB().Where("route_code = ?", routeCode).Order("start_stake").Find(&geometries).Error; err != nil {
+		return nil, err
+	}
+	locator := &routeLocator{chain: chain}
+	for _, g := range geometries {
+		start, err := chain.Distance(stake.FromKm(g.StartStake))
+		if err != nil {
+			return nil, err
+		}
+		end, err := chain.Distance(stake.FromKm(g.EndStake))
+		if err != nil {
+			return nil, err
+		}
+		line := make(geo.Line, len(g.Coordinates))
+		for i, p := range g.Coordinates {
+			line[i] = geo.Point(p)
+		}
+		locator.pieces = append(locator.pieces, geometryPiece{direction: g.Direction, line: line, length: line.Length(), start: start, end: end})
+	}
+	return locator, nil
+}
+
+// piecesFor 该方向可用的中心线：优先使用同方向的，没有时使用双向或上行中心线
+func (l *routeLocator) piecesFor(direction string) []geometryPiece {
+	for _, candidates := range [][]string{{direction}, {RoadDirectionBoth, RoadDirectionUp}} {
+		var pieces []geometryPiece
+		for _, p := range l.pieces {
+			for _, d := range candidates {
+				if p.direction == d {
+					pieces = append(pieces, p)
+				}
+			}
+		}
+		if len(pieces) > 0 {
+			return pieces
+		}
+	}
+	return nil
+}
+
+func (p geometryPiece) position(distance float64) float64 {
+	return (distance - p.start) / (p.end - p.start) * p.length
+}
+
+// Locate 桩号对应的坐标
+func (l *routeLocator) Locate(direction string, s stake.Stake) (geo.Point, error) {
+	distance, err := l.chain.Distance(s)
+	if err != nil {
+		return geo.Point{}, err
+	}
+	for _, p := range l.piecesFor(direction) {
+		if distance >= p.start && distance <= p.end {
+			return p.line.Interpolate(p.position(distance)), nil
+		}
+	}
+	return geo.Point{}, fmt.Errorf("%w覆盖桩号 %s", errNoGeometry, s)
+}
+
+// Segment 桩号区间对应的折线，跨越多段中心线时依次连接，区间超出中心线范围的部分舍去
+func (l *routeLocator) Segment(direction string, from, to stake.Stake) (geo.Line, error) {
+	segment, err := l.chain.Segment(from, to)
+	if err != nil {
+		return nil, err
+	}
+	var line geo.Line
+	for _, p := range l.piecesFor(direction) {
+		start, end := max(float64(segment.Start), p.start), min(float64(segment.End), p.end)
+		if start >= end {
+			continue
+		}
+		line = append(line, p.line.Substring(p.position(start), p.position(end))...)
+	}
+	if len(line) < 2 {
+		return nil, fmt.Errorf("%w覆盖路段 %s~%s", errNoGeometry, from, to)
+	}
+	return line, nil
+}
+
+// LocateStakeHandler 线性参照：由路线编号和桩号查询坐标
+func LocateStakeHandler(c *gin.Context) {
+	routeCode := strings.ToUpper(strings.TrimSpace(c.Query("routeCode")))
+	s, err := stake.Parse(c.Query("stake"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	direction, ok := roadDirections[c.Query("direction")]
+	if !ok {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "方向无效，应为上行、下行或双向"})
+		return
+	}
+	locator, err := newRouteLocator(routeCode)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "读取中心线失败"})
+		return
+	}
+	point, err := locator.Locate(direction, s)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"routeCode": routeCode, "stake": s.String(), "lon": point.Lon(), "lat": point.Lat()})
+}
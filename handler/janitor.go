@@ -0,0 +1,371 @@
+package handler
+
+import (
+	"fmt"
+	"github.com/gin-gonic/gin"
+	"io/fs"
+	"net/http"
+	"ningxia_backend/dao"
+	"ningxia_backend/pkg/conf"
+	"ningxia_backend/pkg/logger"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// cleanupResult 一次清理的统计
+type cleanupResult struct {
+	StartedAt      time.Time `json:"startedAt"`
+	RemovedDirs    []string  `json:"removedDirs"`
+	RemovedFiles   []string  `json:"removedFiles"`
+	ReclaimedBytes int64     `json:"reclaimedBytes"`
+	Errors         []string  `json:"errors,omitempty"`
+}
+
+var (
+	janitorMu   sync.Mutex // 定时清理和手动清理不并发执行
+	lastCleanup atomic.Pointer[cleanupResult]
+
+	datasetUseMu  sync.Mutex
+	datasetsInUse = make(map[string]int) // 正在计算的数据集 -> 使用中的请求数
+)
+
+// StartJanitor 启动后台清理任务，按 janitor.interval 周期删除过期的上传目录、分片和临时PDF
+func StartJanitor() {
+	interval := conf.Conf.GetDuration("janitor.interval")
+	if interval <= 0 {
+		logger.Logger.Infof("janitor.interval 未配置，不启动后台清理")
+		return
+	}
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			runCleanup()
+			<-ticker.C
+		}
+	}()
+}
+
+// dirUsage 统计目录大小和其中最新的修改时间
+func dirUsage(dir string) (int64, time.Time, error) {
+	var size int64
+	var latest time.Time
+	err := filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		if !d.IsDir() {
+			size += info.Size()
+		}
+		if info.ModTime().After(latest) {
+			latest = info.ModTime()
+		}
+		return nil
+	})
+	return size, latest, err
+}
+
+// referencedDatasetIDs 未撤回报告引用的数据集，以及已归入抽检批次的数据集（批次删除后才随上传期限清理）
+func referencedDatasetIDs() (map[string]bool, error) {
+	var reports []dao.Report
+	if err := dao.GetDB().Where("status <> ?", ReportStatusWithdrawn).Find(&reports).Error; err != nil {
+		return nil, err
+	}
+	ids := make(map[string]bool)
+	for _, report := range reports {
+		for _, id := range report.DatasetIDs {
+			ids[id] = true
+		}
+	}
+	var campaignDatasets []string
+	if err := dao.GetDB().Model(&dao.Dataset{}).Where("campaign_id IS NOT NULL").Pluck("id", &campaignDatasets).Error; err != nil {
+		return nil, err
+	}
+	for _, id := range campaignDatasets {
+		ids[id] = true
+	}
+	return ids, nil
+}
+
+func runCleanup() *cleanupResult {
+	janitorMu.Lock()
+	defer janitorMu.Unlock()
+
+	result := &cleanupResult{StartedAt: time.Now(), RemovedDirs: []string{}, RemovedFiles: []string{}}
+	fail := func(format string, args ...any) {
+		logger.Logger.Errorf(format, args...)
+		result.Errors = append(result.Errors, fmt.Sprintf(format, args...))
+	}
+
+	cleanupUploadDirs(result, fail)
+	cleanupChunkSessions(result, fail)
+	cleanupBlobs(result, fail)
+	cleanupPDFFiles(result, fail)
+
+	logger.Logger.Infof("清理完成: 删除目录 %d 个，文件 %d 个，回收 %d 字节",
+		len(result.RemovedDirs), len(result.RemovedFiles), result.ReclaimedBytes)
+	lastCleanup.Store(result)
+	return result
+}
+
+// removeUploadDir 删除上传目录及其中数据集的记录，释放对上传文件的引用。目录删除失败时返回 false
+func removeUploadDir(dir string, datasets []dao.Dataset, fail func(string, ...any)) bool {
+	if err := os.RemoveAll(dir); err != nil {
+		fail("删除上传目录 %s 失败: %v", dir, err)
+		return false
+	}
+	for _, dataset := range datasets {
+		var files []dao.DatasetFile
+		if err := dao.GetDB().Where("dataset_id = ?", dataset.ID).Find(&files).Error; err != nil {
+			fail("查询数据集 %s 的文件记录失败: %v", dataset.ID, err)
+			continue
+		}
+		releaseBlobs(files)
+		if err := dao.GetDB().Where("dataset_id = ?", dataset.ID).Delete(&dao.DatasetFile{}).Error; err != nil {
+			fail("删除数据集 %s 的文件记录失败: %v", dataset.ID, err)
+		}
+		if err := dao.GetDB().Delete(&dataset).Error; err != nil {
+			fail("删除数据集 %s 失败: %v", dataset.ID, err)
+		}
+	}
+	return true
+}
+
+// useDatasets 标记数据集正在被计算使用，清理任务跳过这些数据集所在的目录。返回的函数解除标记
+func useDatasets(ids []string) func() {
+	datasetUseMu.Lock()
+	defer datasetUseMu.Unlock()
+	for _, id := range ids {
+		datasetsInUse[id]++
+	}
+	return func() {
+		datasetUseMu.Lock()
+		defer datasetUseMu.Unlock()
+		for _, id := range ids {
+			if datasetsInUse[id]--; datasetsInUse[id] <= 0 {
+				delete(datasetsInUse, id)
+			}
+		}
+	}
+}
+
+// cleanupUploadDirs 删除超过 janitor.uploadTTL、没有被报告或批次引用且不在计算中的上传目录及其数据集记录
+func cleanupUploadDirs(result *cleanupResult, fail func(string, ...any)) {
+	ttl := conf.Conf.GetDuration("janitor.uploadTTL")
+	referenced, err := referencedDatasetIDs()
+	if err != nil {
+		fail("查询报告和批次引用的数据集失败: %v", err)
+		return
+	}
+	var datasets []dao.Dataset
+	if err = dao.GetDB().Find(&datasets).Error; err != nil {
+		fail("查询数据集失败: %v", err)
+		return
+	}
+	datasetsByDir := make(map[string][]dao.Dataset)
+	for _, dataset := range datasets {
+		dir := filepath.Clean(dataset.Dir)
+		datasetsByDir[dir] = append(datasetsByDir[dir], dataset)
+	}
+
+	entries, err := os.ReadDir(uploadDir)
+	if err != nil {
+		fail("读取上传目录失败: %v", err)
+		return
+	}
+	for _, entry := range entries {
+		if !entry.IsDir() || !strings.HasPrefix(entry.Name(), "req-") {
+			continue
+		}
+		dir := filepath.Clean(filepath.Join(uploadDir, entry.Name()))
+		inUse := false
+		for _, dataset := range datasetsByDir[dir] {
+			if referenced[dataset.ID] || time.Since(dataset.CreatedAt) < ttl {
+				inUse = true
+			}
+		}
+		if inUse {
+			continue
+		}
+		// 以目录中最新的修改时间为准，避免删除仍在追加文件的数据集
+		size, latest, err := dirUsage(dir)
+		if err != nil {
+			fail("统计目录 %s 失败: %v", dir, err)
+			continue
+		}
+		if time.Since(latest) < ttl {
+			continue
+		}
+
+		// 检查和删除在同一把锁内完成，计算开始前标记的数据集不会在计算过程中被删除
+		datasetUseMu.Lock()
+		if slices.ContainsFunc(datasetsByDir[dir], func(d dao.Dataset) bool { return datasetsInUse[d.ID] > 0 }) {
+			datasetUseMu.Unlock()
+			continue
+		}
+		removed := removeUploadDir(dir, datasetsByDir[dir], fail)
+		datasetUseMu.Unlock()
+		if !removed {
+			continue
+		}
+		logger.Logger.Infof("清理过期上传目录 %s，回收 %d 字节", dir, size)
+		result.RemovedDirs = append(result.RemovedDirs, dir)
+		result.ReclaimedBytes += size
+	}
+}
+
+// cleanupChunkSessions 删除超过 janitor.uploadTTL 未更新的分片上传会话，以及没有会话的分片文件
+func cleanupChunkSessions(result *cleanupResult, fail func(string, ...any)) {
+	ttl := conf.Conf.GetDuration("janitor.uploadTTL")
+	var sessions []dao.UploadSession
+	if err := dao.GetDB().Where("updated_at < ?", time.Now().Add(-ttl)).Find(&sessions).Error; err != nil {
+		fail("查询过期上传会话失败: %v", err)
+		return
+	}
+	for _, session := range sessions {
+		unlock := lockUpload(session.ID)
+		if err := dao.GetDB().Delete(&session).Error; err != nil {
+			fail("删除上传会话 %s 失败: %v", session.ID, err)
+		} else {
+			uploadLocks.Delete(session.ID)
+		}
+		unlock()
+	}
+
+	entries, err := os.ReadDir(chunkUploadDir)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			fail("读取分片目录失败: %v", err)
+		}
+		return
+	}
+	for _, entry := range entries {
+		path := filepath.Join(chunkUploadDir, entry.Name())
+		id := strings.TrimSuffix(entry.Name(), filepath.Ext(entry.Name()))
+		var count int64
+		dao.GetDB().Model(&dao.UploadSession{}).Where("id = ?", id).Count(&count)
+		if count > 0 {
+			continue
+		}
+		removeExpiredFile(path, ttl, result, fail)
+	}
+}
+
+// cleanupBlobs 按数据集文件记录校正引用计数，删除没有数据集引用且超过 janitor.uploadTTL 未使用的文件
+func cleanupBlobs(result *cleanupResult, fail func(string, ...any)) {
+	ttl := conf.Conf.GetDuration("janitor.uploadTTL")
+	var blobs []dao.Blob
+	if err := dao.GetDB().Find(&blobs).Error; err != nil {
+		fail("查询上传文件失败: %v", err)
+		return
+	}
+	for _, blob := range blobs {
+		unlock := lockBlob(blob.SHA256)
+		var refs int64
+		if err := dao.GetDB().Model(&dao.DatasetFile{}).Where("blob_sha256 = ?", blob.SHA256).
+			Distinct("dataset_id").Count(&refs).Error; err != nil {
+			fail("统计文件 %s 的引用失败: %v", blob.SHA256, err)
+			unlock()
+			continue
+		}
+		if int(refs) != blob.RefCount {
+			logger.Logger.Warnf("文件 %s 引用计数 %d 与实际引用 %d 不符，已校正", blob.SHA256, blob.RefCount, refs)
+			dao.GetDB().Model(&blob).Update("ref_count", refs)
+		}
+		if refs > 0 || time.Since(blob.LastUsedAt) < ttl {
+			unlock()
+			continue
+		}
+
+		size, _, err := dirUsage(blobExtractDir(blob.SHA256))
+		if err != nil && !os.IsNotExist(err) {
+			fail("统计目录 %s 失败: %v", blobExtractDir(blob.SHA256), err)
+		}
+		if err = os.RemoveAll(blobExtractDir(blob.SHA256)); err != nil {
+			fail("删除解压目录 %s 失败: %v", blobExtractDir(blob.SHA256), err)
+			unlock()
+			continue
+		}
+		if err = os.Remove(blobPath(blob.SHA256)); err != nil && !os.IsNotExist(err) {
+			fail("删除文件 %s 失败: %v", blobPath(blob.SHA256), err)
+			unlock()
+			continue
+		}
+		if err = dao.GetDB().Delete(&blob).Error; err != nil {
+			fail("删除文件记录 %s 失败: %v", blob.SHA256, err)
+		}
+		unlock()
+		logger.Logger.Infof("清理未引用的上传文件 %s (%s)，回收 %d 字节", blob.FileName, blob.SHA256, blob.Size+size)
+		result.RemovedFiles = append(result.RemovedFiles, blobPath(blob.SHA256))
+		result.ReclaimedBytes += blob.Size + size
+	}
+}
+
+// cleanupPDFFiles 删除 tmp/pdf 中超过 janitor.pdfTTL 的临时文件，导出PDF不会再引用它们
+func cleanupPDFFiles(result *cleanupResult, fail func(string, ...any)) {
+	ttl := conf.Conf.GetDuration("janitor.pdfTTL")
+	entries, err := os.ReadDir(pdfDir)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			fail("读取PDF临时目录失败: %v", err)
+		}
+		return
+	}
+	for _, entry := range entries {
+		if entry.IsDir() {
+			continue
+		}
+		removeExpiredFile(filepath.Join(pdfDir, entry.Name()), ttl, result, fail)
+	}
+}
+
+func removeExpiredFile(path string, ttl time.Duration, result *cleanupResult, fail func(string, ...any)) {
+	info, err := os.Stat(path)
+	if err != nil || info.IsDir() || time.Since(info.ModTime()) < ttl {
+		return
+	}
+	if err = os.Remove(path); err != nil {
+		fail("删除文件 %s 失败: %v", path, err)
+		return
+	}
+	logger.Logger.Infof("清理过期文件 %s，回收 %d 字节", path, info.Size())
+	result.RemovedFiles = append(result.RemovedFiles, path)
+	result.ReclaimedBytes += info.Size()
+}
+
+// GetStorageUsageHandler 返回上传、分片、临时PDF和报告目录的磁盘占用及最近一次清理结果
+func GetStorageUsageHandler(c *gin.Context) {
+	usage := make(map[string]gin.H)
+	for name, dir := range map[string]string{"uploads": uploadDir, "chunks": chunkUploadDir, "blobs": blobDir, "pdf": pdfDir, "reports": reportsBaseDir} {
+		size, _, err := dirUsage(dir)
+		if err != nil && !os.IsNotExist(err) {
+			logger.Logger.Errorf("统计目录 %s 失败: %v", dir, err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "统计磁盘占用失败"})
+			return
+		}
+		usage[name] = gin.H{"dir": dir, "bytes": size}
+	}
+	// uploads 包含 chunks 子目录，单独列出上传目录本身的占用。数据集目录中的文件多为 blobs 的硬链接，不额外占用空间
+	usage["uploads"]["bytes"] = usage["uploads"]["bytes"].(int64) - usage["chunks"]["bytes"].(int64)
+
+	c.JSON(http.StatusOK, gin.H{
+		"usage":       usage,
+		"uploadTTL":   conf.Conf.GetDuration("janitor.uploadTTL").String(),
+		"pdfTTL":      conf.Conf.GetDuration("janitor.pdfTTL").String(),
+		"lastCleanup": lastCleanup.Load(),
+	})
+}
+
+// CleanupHandler 立即执行一次清理
+func CleanupHandler(c *gin.Context) {
+	c.JSON(http.StatusOK, runCleanup())
+}
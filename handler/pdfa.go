@@ -0,0 +1,436 @@
+package handler
+
+import (
+	"bytes"
+	"encoding/xml"
+	"fmt"
+	"github.com/pdfcpu/pdfcpu/pkg/api"
+	"github.com/pdfcpu/pdfcpu/pkg/pdfcpu"
+	"github.com/pdfcpu/pdfcpu/pkg/pdfcpu/model"
+	"github.com/pdfcpu/pdfcpu/pkg/pdfcpu/types"
+	"ningxia_backend/dao"
+	"ningxia_backend/pkg/icc"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// archiveMeta 写入归档PDF的文档元数据，来源于报告目录
+type archiveMeta struct {
+	Title           string
+	Author          string
+	Subject         string
+	Keywords        []string
+	ReportType      string
+	Year            int
+	Unit            string
+	TemplateVersion string
+}
+
+func newArchiveMeta(report *dao.Report) archiveMeta {
+	title := ReportNameMap[report.ReportType]
+	yearStr := strconv.Itoa(report.Year)
+	return archiveMeta{
+		Title:           title,
+		Author:          report.Unit,
+		Subject:         fmt.Sprintf("%d年度%s", report.Year, title),
+		Keywords:        []string{report.ReportType, yearStr, report.Unit, report.TemplateVersion},
+		ReportType:      report.ReportType,
+		Year:            report.Year,
+		Unit:            report.Unit,
+		TemplateVersion: report.TemplateVersion,
+	}
+}
+
+// PDF/A-3B XMP 元数据，自定义的报告属性需要通过 pdfaExtension 声明
+const xmpTemplate = `<?xpacket begin="` + "\ufeff" + `" id="W5M0MpCehiHzreSzNTczkc9d"?>
+<x:xmpmeta xmlns:x="adobe:ns:meta/">
+ <rdf:RDF xmlns:rdf="http://www.w3.org/1999/02/22-rdf-syntax-ns#">
+  <rdf:Description rdf:about=""
+    xmlns:dc="http://purl.org/dc/elements/1.1/"
+    xmlns:pdf="http://ns.adobe.com/pdf/1.3/"
+    xmlns:xmp="http://ns.adobe.com/xap/1.0/"
+    xmlns:pdfaid="http://www.aiim.org/pdfa/ns/id/"
+    xmlns:pdfaExtension="http://www.aiim.org/pdfa/ns/extension/"
+    xmlns:pdfaSchema="http://www.aiim.org/pdfa/ns/schema#"
+    xmlns:pdfaProperty="http://www.aiim.org/pdfa/ns/property#"
+    xmlns:nxr="http://ningxia-road.local/ns/report/1.0/">
+   <dc:format>application/pdf</dc:format>
+   <dc:title><rdf:Alt><rdf:li xml:lang="x-default">%[1]s</rdf:li></rdf:Alt></dc:title>
+   <dc:creator><rdf:Seq><rdf:li>%[2]s</rdf:li></rdf:Seq></dc:creator>
+   <dc:description><rdf:Alt><rdf:li xml:lang="x-default">%[3]s</rdf:li></rdf:Alt></dc:description>
+   <pdf:Keywords>%[4]s</pdf:Keywords>
+   <pdf:Producer>%[10]s</pdf:Producer>
+   <xmp:CreatorTool>%[8]s</xmp:CreatorTool>
+   <xmp:CreateDate>%[5]s</xmp:CreateDate>
+   <xmp:ModifyDate>%[11]s</xmp:ModifyDate>
+   <xmp:MetadataDate>%[11]s</xmp:MetadataDate>
+   <pdfaid:part>3</pdfaid:part>
+   <pdfaid:conformance>B</pdfaid:conformance>
+   <nxr:reportType>%[6]s</nxr:reportType>
+   <nxr:year>%[7]d</nxr:year>
+   <nxr:unit>%[8]s</nxr:unit>
+   <nxr:templateVersion>%[9]s</nxr:templateVersion>
+   <pdfaExtension:schemas>
+    <rdf:Bag>
+     <rdf:li rdf:parseType="Resource">
+      <pdfaSchema:schema>Ningxia Road Report Schema</pdfaSchema:schema>
+      <pdfaSchema:namespaceURI>http://ningxia-road.local/ns/report/1.0/</pdfaSchema:namespaceURI>
+      <pdfaSchema:prefix>nxr</pdfaSchema:prefix>
+      <pdfaSchema:property>
+       <rdf:Seq>
+        <rdf:li rdf:parseType="Resource"><pdfaProperty:name>reportType</pdfaProperty:name><pdfaProperty:valueType>Text</pdfaProperty:valueType><pdfaProperty:category>external</pdfaProperty:category><pdfaProperty:description>报告类型</pdfaProperty:description></rdf:li>
+        <rdf:li rdf:parseType="Resource"><pdfaProperty:name>year</pdfaProperty:name><pdfaProperty:valueType>Integer</pdfaProperty:valueType><pdfaProperty:category>external</pdfaProperty:category><pdfaProperty:description>报告年度</pdfaProperty:description></rdf:li>
+        <rdf:li rdf:parseType="Resource"><pdfaProperty:name>unit</pdfaProperty:name><pdfaProperty:valueType>Text</pdfaProperty:valueType><pdfaProperty:category>external</pdfaProperty:category><pdfaProperty:description>编制单位</pdfaProperty:description></rdf:li>
+        <rdf:li rdf:parseType="Resource"><pdfaProperty:name>templateVersion</pdfaProperty:name><pdfaProperty:valueType>Text</pdfaProperty:valueType><pdfaProperty:category>external</pdfaProperty:category><pdfaProperty:description>模板版本</pdfaProperty:description></rdf:li>
+       </rdf:Seq>
+      </pdfaSchema:property>
+     </rdf:li>
+    </rdf:Bag>
+   </pdfaExtension:schemas>
+  </rdf:Description>
+ </rdf:RDF>
+</x:xmpmeta>
+<?xpacket end="w"?>`
+
+func xmlEscape(s string) string {
+	var buf bytes.Buffer
+	_ = xml.EscapeText(&buf, []byte(s))
+	return buf.String()
+}
+
+// pdfcpu 写出时会把文档信息字典的 Producer、CreationDate、ModDate 改为当前时间，
+// XMP 中的日期先写入等宽占位，写出后按文档信息字典回填，元数据流不压缩，回填不改变对象偏移
+const xmpDateLayout = "2006-01-02T15:04:05-07:00"
+
+var (
+	xmpCreateDateSlot = fmt.Sprintf("%-*s", len(xmpDateLayout), "#CreateDate")
+	xmpModifyDateSlot = fmt.Sprintf("%-*s", len(xmpDateLayout), "#ModifyDate")
+)
+
+// pdfProducer pdfcpu 写入文档信息字典的 Producer
+func pdfProducer() string {
+	return "pdfcpu " + model.VersionStr
+}
+
+func buildXMP(meta archiveMeta) []byte {
+	return []byte(fmt.Sprintf(xmpTemplate,
+		xmlEscape(meta.Title),
+		xmlEscape(meta.Author),
+		xmlEscape(meta.Subject),
+		xmlEscape(strings.Join(meta.Keywords, ", ")),
+		xmpCreateDateSlot,
+		xmlEscape(meta.ReportType),
+		meta.Year,
+		xmlEscape(meta.Unit),
+		xmlEscape(meta.TemplateVersion),
+		xmlEscape(pdfProducer()),
+		xmpModifyDateSlot,
+	))
+}
+
+// infoXMPFields 文档信息字典与 XMP 中必须一致的条目
+var infoXMPFields = []struct{ Info, XMP string }{
+	{"Title", "dc:title"},
+	{"Author", "dc:creator"},
+	{"Subject", "dc:description"},
+	{"Keywords", "pdf:Keywords"},
+	{"Creator", "xmp:CreatorTool"},
+	{"Producer", "pdf:Producer"},
+	{"CreationDate", "xmp:CreateDate"},
+	{"ModDate", "xmp:ModifyDate"},
+}
+
+// readInfoDict 读取文档信息字典中的文本条目
+func readInfoDict(ctx *model.Context) (map[string]string, error) {
+	if ctx.Info == nil {
+		return nil, fmt.Errorf("缺少文档信息字典")
+	}
+	d, err := ctx.DereferenceDict(*ctx.Info)
+	if err != nil || d == nil {
+		return nil, fmt.Errorf("读取文档信息字典失败: %v", err)
+	}
+	info := make(map[string]string, len(d))
+	for key, value := range d {
+		if text, err := ctx.DereferenceText(value); err == nil {
+			info[key] = text
+		}
+	}
+	return info, nil
+}
+
+// xmpValue XMP 中元素的文本值，rdf:Alt/rdf:Seq 取第一项
+func xmpValue(xmp []byte, element string) (string, bool) {
+	_, rest, ok := bytes.Cut(xmp, []byte("<"+element+">"))
+	if !ok {
+		return "", false
+	}
+	value, _, ok := bytes.Cut(rest, []byte("</"+element+">"))
+	if !ok {
+		return "", false
+	}
+	if _, li, found := bytes.Cut(value, []byte("<rdf:li")); found {
+		_, li, _ = bytes.Cut(li, []byte(">"))
+		value, _, _ = bytes.Cut(li, []byte("</rdf:li>"))
+	}
+	var text string
+	if err := xml.Unmarshal([]byte("<v>"+string(value)+"</v>"), &text); err != nil {
+		return "", false
+	}
+	return text, true
+}
+
+// infoXMPMismatches 比较文档信息字典与 XMP，返回不一致的条目
+func infoXMPMismatches(info map[string]string, xmp []byte) []string {
+	var mismatches []string
+	for _, field := range infoXMPFields {
+		infoValue, inInfo := info[field.Info]
+		xmpText, inXMP := xmpValue(xmp, field.XMP)
+		if !inInfo && !inXMP {
+			continue
+		}
+		equal := inInfo && inXMP && infoValue == xmpText
+		if inInfo && inXMP && (field.Info == "CreationDate" || field.Info == "ModDate") {
+			infoTime, ok1 := types.DateTime(infoValue, true)
+			xmpTime, err := time.Parse(time.RFC3339, xmpText)
+			equal = ok1 && err == nil && infoTime.Equal(xmpTime)
+		}
+		if !equal {
+			mismatches = append(mismatches, fmt.Sprintf("%s(%s)与XMP %s(%s)不一致", field.Info, infoValue, field.XMP, xmpText))
+		}
+	}
+	return mismatches
+}
+
+// fillXMPDates 按写出后的文档信息字典回填 XMP 日期
+func fillXMPDates(pdf []byte, cnf *model.Configuration) ([]byte, error) {
+	ctx, err := api.ReadContext(bytes.NewReader(pdf), cnf)
+	if err != nil {
+		return nil, err
+	}
+	info, err := readInfoDict(ctx)
+	if err != nil {
+		return nil, err
+	}
+	for key, slot := range map[string]string{"CreationDate": xmpCreateDateSlot, "ModDate": xmpModifyDateSlot} {
+		t, ok := types.DateTime(info[key], true)
+		if !ok {
+			return nil, fmt.Errorf("文档信息字典 %s 无效: %q", key, info[key])
+		}
+		date := t.Format(xmpDateLayout)
+		if len(date) != len(slot) || !bytes.Contains(pdf, []byte(slot)) {
+			return nil, fmt.Errorf("XMP日期 %s 无法回填", key)
+		}
+		pdf = bytes.ReplaceAll(pdf, []byte(slot), []byte(date))
+	}
+	return pdf, nil
+}
+
+// makeArchivalPDF 写入文档信息字典和 XMP 元数据，生成归档用PDF
+func makeArchivalPDF(pdf []byte, meta archiveMeta) ([]byte, error) {
+	cnf := model.NewDefaultConfiguration()
+	ctx, err := api.ReadValidateAndOptimize(bytes.NewReader(pdf), cnf)
+	if err != nil {
+		return nil, fmt.Errorf("读取PDF失败: %w", err)
+	}
+	if ctx.Encrypt != nil {
+		return nil, fmt.Errorf("加密的PDF不能作为归档文件")
+	}
+
+	properties := map[string]string{
+		"Title":    meta.Title,
+		"Author":   meta.Author,
+		"Subject":  meta.Subject,
+		"Keywords": strings.Join(meta.Keywords, ", "),
+		"Creator":  meta.Unit,
+	}
+	if err = pdfcpu.PropertiesAdd(ctx, properties); err != nil {
+		return nil, fmt.Errorf("写入文档属性失败: %w", err)
+	}
+
+	// 归档规范要求元数据流不能压缩
+	xmp := buildXMP(meta)
+	sd := types.StreamDict{Dict: types.NewDict(), Content: xmp, Raw: xmp}
+	sd.InsertName("Type", "Metadata")
+	sd.InsertName("Subtype", "XML")
+	sd.InsertInt("Length", len(xmp))
+	streamLength := int64(len(xmp))
+	sd.StreamLength = &streamLength
+	ir, err := ctx.IndRefForNewObject(sd)
+	if err != nil {
+		return nil, fmt.Errorf("写入XMP元数据失败: %w", err)
+	}
+	rootDict, err := ctx.Catalog()
+	if err != nil {
+		return nil, fmt.Errorf("读取PDF目录失败: %w", err)
+	}
+	rootDict.Update("Metadata", *ir)
+	if err = addOutputIntent(ctx, rootDict); err != nil {
+		return nil, fmt.Errorf("写入输出意图失败: %w", err)
+	}
+
+	// 写出时 pdfcpu 会在文件尾补全 /ID
+	var out bytes.Buffer
+	if err = api.WriteContext(ctx, &out); err != nil {
+		return nil, fmt.Errorf("写出PDF失败: %w", err)
+	}
+	archived, err := fillXMPDates(out.Bytes(), cnf)
+	if err != nil {
+		return nil, fmt.Errorf("写入XMP日期失败: %w", err)
+	}
+	return archived, nil
+}
+
+// addOutputIntent 写入嵌入 sRGB 特性文件的 GTS_PDFA1 输出意图，替换原有的输出意图
+func addOutputIntent(ctx *model.Context, rootDict types.Dict) error {
+	profile := icc.SRGB()
+	sd := types.StreamDict{Dict: types.NewDict(), Content: profile, Raw: profile}
+	sd.InsertInt("N", 3)
+	sd.InsertInt("Length", len(profile))
+	streamLength := int64(len(profile))
+	sd.StreamLength = &streamLength
+	ir, err := ctx.IndRefForNewObject(sd)
+	if err != nil {
+		return err
+	}
+	intent := types.NewDict()
+	intent.InsertName("Type", "OutputIntent")
+	intent.InsertName("S", "GTS_PDFA1")
+	intent.InsertString("OutputConditionIdentifier", icc.SRGBIdentifier)
+	intent.InsertString("Info", icc.SRGBIdentifier)
+	intent.InsertString("RegistryName", "http://www.color.org")
+	intent.Insert("DestOutputProfile", *ir)
+	rootDict.Update("OutputIntents", types.Array{intent})
+	return nil
+}
+
+// hasPDFAOutputIntent 目录中是否有带嵌入特性文件的 GTS_PDFA1 输出意图
+func hasPDFAOutputIntent(ctx *model.Context, rootDict types.Dict) bool {
+	intents, err := ctx.DereferenceArray(rootDict["OutputIntents"])
+	if err != nil {
+		return false
+	}
+	for _, o := range intents {
+		intent, err := ctx.DereferenceDict(o)
+		if err != nil || intent == nil {
+			continue
+		}
+		if s := intent.NameEntry("S"); s == nil || *s != "GTS_PDFA1" {
+			continue
+		}
+		sd, _, err := ctx.DereferenceStreamDict(intent["DestOutputProfile"])
+		if err == nil && sd != nil && sd.Decode() == nil && len(sd.Content) >= 128 && string(sd.Content[36:40]) == "acsp" {
+			return true
+		}
+	}
+	return false
+}
+
+// checkArchivalConformance 交付前检查归档PDF，返回发现的问题，为空表示通过
+func checkArchivalConformance(pdf []byte) []string {
+	var problems []string
+	cnf := model.NewDefaultConfiguration()
+
+	if err := api.Validate(bytes.NewReader(pdf), cnf); err != nil {
+		problems = append(problems, fmt.Sprintf("PDF结构校验失败: %v", err))
+	}
+
+	info, err := api.PDFInfo(bytes.NewReader(pdf), "", nil, true, cnf)
+	if err != nil {
+		return append(problems, fmt.Sprintf("读取PDF信息失败: %v", err))
+	}
+	if info.Encrypted {
+		problems = append(problems, "PDF已加密")
+	}
+	for _, f := range info.Fonts {
+		if !f.Embedded {
+			problems = append(problems, fmt.Sprintf("字体 %s 未嵌入", f.Name))
+		}
+	}
+	if info.Title == "" || info.Author == "" {
+		problems = append(problems, "文档信息缺少标题或作者")
+	}
+
+	ctx, err := api.ReadAndValidate(bytes.NewReader(pdf), cnf)
+	if err != nil {
+		return append(problems, fmt.Sprintf("读取PDF失败: %v", err))
+	}
+	rootDict, err := ctx.Catalog()
+	if err != nil {
+		return append(problems, fmt.Sprintf("读取PDF目录失败: %v", err))
+	}
+	if len(ctx.ID) != 2 {
+		problems = append(problems, "文件尾缺少 /ID")
+	}
+	if !hasPDFAOutputIntent(ctx, rootDict) {
+		problems = append(problems, "缺少嵌入ICC特性文件的 GTS_PDFA1 输出意图")
+	}
+	sd, _, err := ctx.DereferenceStreamDict(rootDict["Metadata"])
+	if err != nil || sd == nil {
+		return append(problems, "缺少XMP元数据")
+	}
+	if err = sd.Decode(); err != nil {
+		return append(problems, fmt.Sprintf("XMP元数据无法解码: %v", err))
+	}
+	if !bytes.Contains(sd.Content, []byte("<pdfaid:part>3</pdfaid:part>")) {
+		problems = append(problems, "XMP元数据缺少PDF/A标识")
+	}
+	problems = append(problems, embeddedFileProblems(ctx, rootDict)...)
+	docInfo, err := readInfoDict(ctx)
+	if err != nil {
+		return append(problems, err.Error())
+	}
+	for _, mismatch := range infoXMPMismatches(docInfo, sd.Content) {
+		problems = append(problems, "文档信息"+mismatch)
+	}
+	return problems
+}
+
+// embeddedFileProblems 检查嵌入的附件：PDF/A-3 要求注明 MIME 类型和关联关系，并登记在目录的 /AF 数组中
+func embeddedFileProblems(ctx *model.Context, rootDict types.Dict) []string {
+	if err := ctx.LocateNameTree("EmbeddedFiles", false); err != nil {
+		return []string{fmt.Sprintf("读取附件目录失败: %v", err)}
+	}
+	tree := ctx.Names["EmbeddedFiles"]
+	if tree == nil {
+		return nil
+	}
+
+	associated := make(map[int]bool)
+	af, err := ctx.DereferenceArray(rootDict["AF"])
+	if err != nil {
+		return []string{fmt.Sprintf("读取目录 /AF 失败: %v", err)}
+	}
+	for _, o := range af {
+		if ir, ok := o.(types.IndirectRef); ok {
+			associated[ir.ObjectNumber.Value()] = true
+		}
+	}
+
+	var problems []string
+	err = tree.Process(ctx.XRefTable, func(xRefTable *model.XRefTable, id string, o *types.Object) error {
+		if ir, ok := (*o).(types.IndirectRef); !ok || !associated[ir.ObjectNumber.Value()] {
+			problems = append(problems, fmt.Sprintf("附件 %s 未登记在目录 /AF 中", id))
+		}
+		d, err := xRefTable.DereferenceDict(*o)
+		if err != nil || d == nil {
+			problems = append(problems, fmt.Sprintf("附件 %s 的文件说明无法读取", id))
+			return nil
+		}
+		if d.NameEntry("AFRelationship") == nil {
+			problems = append(problems, fmt.Sprintf("附件 %s 缺少 AFRelationship", id))
+		}
+		var sd *types.StreamDict
+		if ef := d.DictEntry("EF"); ef != nil {
+			sd, _, _ = xRefTable.DereferenceStreamDict(ef["F"])
+		}
+		if sd == nil || sd.NameEntry("Subtype") == nil {
+			problems = append(problems, fmt.Sprintf("附件 %s 缺少 MIME 类型", id))
+		}
+		return nil
+	})
+	if err != nil {
+		problems = append(problems, fmt.Sprintf("读取附件失败: %v", err))
+	}
+	return problems
+}
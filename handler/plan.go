@@ -0,0 +1,232 @@
+package handler
+
+import (
+	"errors"
+	"fmt"
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+	"net/http"
+	"ningxia_backend/dao"
+	"ningxia_backend/pkg/logger"
+	"strconv"
+)
+
+// nationalTargetFields 交通部指标中可以设置阶段目标的字段（JSON 字段名）
+func nationalTargetFields() map[string]bool {
+	fields := make(map[string]bool)
+	for key := range settingFields(dao.NationalSetting{}) {
+		if key != "plan" {
+			fields[key] = true
+		}
+	}
+	return fields
+}
+
+func validateTargetValues(values map[string]float64, fields map[string]bool) error {
+	for key, value := range values {
+		if !fields[key] {
+			return fmt.Errorf("未知的指标字段'%s'", key)
+		}
+		if err := validatePercent(key, value); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func validatePlanningPeriod(period *dao.PlanningPeriod) error {
+	if period.StartYear < 2000 || period.EndYear > 2100 {
+		return fmt.Errorf("年份应在 2000 到 2100 之间")
+	}
+	if period.EndYear < period.StartYear {
+		return fmt.Errorf("结束年份不能早于开始年份")
+	}
+
+	var overlap dao.PlanningPeriod
+	err := dao.GetDB().Where("name <> ? AND start_year <= ? AND end_year >= ?", period.Name, period.EndYear, period.StartYear).First(&overlap).Error
+	if err == nil {
+		return fmt.Errorf("与规划期'%s'（%d-%d年）的年份重叠", overlap.Name, overlap.StartYear, overlap.EndYear)
+	}
+	if !errors.Is(err, gorm.ErrRecordNotFound) {
+		return err
+	}
+
+	fields := nationalTargetFields()
+	if err = validateTargetValues(period.Baseline, fields); err != nil {
+		return fmt.Errorf("插值起点: %w", err)
+	}
+	years := make(map[int]bool)
+	for _, milestone := range period.Milestones {
+		if milestone.Year < period.StartYear || milestone.Year > period.EndYear {
+			return fmt.Errorf("阶段目标年份 %d 不在规划期内", milestone.Year)
+		}
+		if years[milestone.Year] {
+			return fmt.Errorf("阶段目标年份 %d 重复", milestone.Year)
+		}
+		years[milestone.Year] = true
+		if err = validateTargetValues(milestone.Values, fields); err != nil {
+			return fmt.Errorf("%d年阶段目标: %w", milestone.Year, err)
+		}
+	}
+	return nil
+}
+
+// periodForYear 年份所在的规划期，未配置时返回 nil
+func periodForYear(year int) (*dao.PlanningPeriod, error) {
+	var period dao.PlanningPeriod
+	err := dao.GetDB().Where("start_year <= ? AND end_year >= ?", year, year).First(&period).Error
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return &period, nil
+}
+
+// milestoneTargets 规划期内某一年度的阶段目标：指定了该年度的以指定值为准，
+// 否则由插值起点线性过渡到规划期目标，规划期最后一年达到目标值
+func milestoneTargets(period *dao.PlanningPeriod, year int, targets map[string]any) (map[string]any, bool) {
+	values := make(map[string]any, len(targets))
+	for key, value := range targets {
+		values[key] = value
+	}
+	changed := false
+	if len(period.Baseline) > 0 && year < period.EndYear {
+		ratio := float64(year-period.StartYear+1) / float64(period.EndYear-period.StartYear+1)
+		for key, base := range period.Baseline {
+			if target, ok := values[key].(float64); ok && target > 0 {
+				values[key] = round2(base + (target-base)*ratio)
+				changed = true
+			}
+		}
+	}
+	for _, milestone := range period.Milestones {
+		if milestone.Year != year {
+			continue
+		}
+		for key, value := range milestone.Values {
+			values[key] = value
+			changed = true
+		}
+	}
+	return values, changed
+}
+
+// nationalSettingForYear 查找年份所在规划期的交通部指标，并换算为该年度的阶段目标。
+// 规划期未配置时 plan 为空；规划期已配置但没有指标时 setting 为 nil
+func nationalSettingForYear(year int) (plan string, setting *dao.NationalSetting, milestone bool, err error) {
+	period, err := periodForYear(year)
+	if err != nil || period == nil {
+		return "", nil, false, err
+	}
+	var national dao.NationalSetting
+	if err = dao.GetDB().Where("plan = ?", period.Name).First(&national).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return period.Name, nil, false, nil
+		}
+		return "", nil, false, err
+	}
+
+	values, milestone := milestoneTargets(period, year, settingFields(national))
+	if !milestone {
+		return period.Name, &national, false, nil
+	}
+	var yearly dao.NationalSetting
+	if err = settingFromFields(values, &yearly); err != nil {
+		return "", nil, false, err
+	}
+	yearly.Model = national.Model
+	return period.Name, &yearly, true, nil
+}
+
+func GetPlanningPeriods(c *gin.Context) {
+	periods := make([]dao.PlanningPeriod, 0)
+	if err := dao.GetDB().Order("start_year").Find(&periods).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "数据库查询失败"})
+		return
+	}
+	c.JSON(http.StatusOK, periods)
+}
+
+func GetPlanningPeriod(c *gin.Context) {
+	name := c.Param("name")
+	var period dao.PlanningPeriod
+	if err := dao.GetDB().Where("name = ?", name).First(&period).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": fmt.Sprintf("未找到规划期'%s'", name)})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "数据库查询失败"})
+		return
+	}
+	c.JSON(http.StatusOK, period)
+}
+
+// SavePlanningPeriod 按名称新增或覆盖规划期
+func SavePlanningPeriod(c *gin.Context) {
+	var period dao.PlanningPeriod
+	if err := c.ShouldBindJSON(&period); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if err := validatePlanningPeriod(&period); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	var existing dao.PlanningPeriod
+	err := dao.GetDB().Where("name = ?", period.Name).First(&existing).Error
+	if err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "数据库查询失败"})
+		return
+	}
+	period.ID, period.CreatedAt = existing.ID, existing.CreatedAt
+	if err = dao.GetDB().Save(&period).Error; err != nil {
+		logger.Logger.Errorf("保存规划期 %s 失败: %v", period.Name, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "保存规划期失败"})
+		return
+	}
+	c.JSON(http.StatusOK, period)
+}
+
+// DeletePlanningPeriod 删除规划期，仍有交通部指标引用时拒绝
+func DeletePlanningPeriod(c *gin.Context) {
+	name := c.Param("name")
+	var count int64
+	if err := dao.GetDB().Model(&dao.NationalSetting{}).Where("plan = ?", name).Count(&count).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "数据库查询失败"})
+		return
+	}
+	if count > 0 {
+		c.JSON(http.StatusConflict, gin.H{"error": fmt.Sprintf("规划期'%s'已配置交通部指标，请先删除指标", name)})
+		return
+	}
+	result := dao.GetDB().Where("name = ?", name).Delete(&dao.PlanningPeriod{})
+	if result.Error != nil {
+		logger.Logger.Errorf("删除规划期 %s 失败: %v", name, result.Error)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "删除规划期失败"})
+		return
+	}
+	if result.RowsAffected == 0 {
+		c.JSON(http.StatusNotFound, gin.H{"error": fmt.Sprintf("未找到规划期'%s'", name)})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": fmt.Sprintf("规划期'%s'已删除", name)})
+}
+
+// GetEffectiveSettings 报告年份适用的省厅指标和交通部阶段目标，与生成报告时冻结的内容一致
+func GetEffectiveSettings(c *gin.Context) {
+	year, err := strconv.Atoi(c.Param("year"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "无效的年份参数"})
+		return
+	}
+	settings, err := takeSettingsSnapshot(year)
+	if err != nil {
+		logger.Logger.Errorf("读取%d年指标配置失败: %v", year, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "读取指标配置失败"})
+		return
+	}
+	c.JSON(http.StatusOK, settings)
+}
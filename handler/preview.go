@@ -0,0 +1,175 @@
+package handler
+
+import (
+	"errors"
+	"fmt"
+	"github.com/gin-gonic/gin"
+	"github.com/xuri/excelize/v2"
+	"gorm.io/gorm"
+	"net/http"
+	"ningxia_backend/dao"
+	"ningxia_backend/pkg/logger"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+const (
+	defaultPreviewRows = 20
+	maxPreviewRows     = 500
+)
+
+// fileTreeNode 数据集文件树节点，目录没有 FileID
+type fileTreeNode struct {
+	Name     string          `json:"name"`
+	Path     string          `json:"path"`
+	IsDir    bool            `json:"isDir"`
+	FileID   uint            `json:"fileId,omitempty"`
+	Role     string          `json:"role,omitempty"`
+	Size     int64           `json:"size,omitempty"`
+	Children []*fileTreeNode `json:"children,omitempty"`
+}
+
+type sheetPreview struct {
+	Name     string     `json:"name"`
+	RowCount int        `json:"rowCount"`
+	Rows     [][]string `json:"rows"`
+}
+
+// buildFileTree 按文件的相对路径组装目录树，ZIP 解压出的文件位于各自的 _extracted 目录下
+func buildFileTree(files []dao.DatasetFile) []*fileTreeNode {
+	root := &fileTreeNode{IsDir: true}
+	for _, file := range files {
+		parts := strings.Split(file.Name, "/")
+		node := root
+		for i, part := range parts {
+			var child *fileTreeNode
+			for _, existing := range node.Children {
+				if existing.Name == part {
+					child = existing
+					break
+				}
+			}
+			if child == nil {
+				child = &fileTreeNode{Name: part, Path: strings.Join(parts[:i+1], "/"), IsDir: true}
+				node.Children = append(node.Children, child)
+			}
+			node = child
+		}
+		node.IsDir = false
+		node.FileID = file.ID
+		node.Role = file.Role
+		node.Size = file.Size
+	}
+	sortFileTree(root)
+	return root.Children
+}
+
+// sortFileTree 目录在前，同类按名称排序
+func sortFileTree(node *fileTreeNode) {
+	slices.SortFunc(node.Children, func(a, b *fileTreeNode) int {
+		if a.IsDir != b.IsDir {
+			if a.IsDir {
+				return -1
+			}
+			return 1
+		}
+		return strings.Compare(a.Name, b.Name)
+	})
+	for _, child := range node.Children {
+		sortFileTree(child)
+	}
+}
+
+// previewExcel 读取工作表名称、行数和前 maxRows 行，sheet 不为空时只读取该工作表
+func previewExcel(path, sheet string, maxRows int) ([]sheetPreview, error) {
+	f, err := excelize.OpenFile(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	sheetNames := f.GetSheetList()
+	if sheet != "" {
+		if !slices.Contains(sheetNames, sheet) {
+			return nil, fmt.Errorf("工作表 '%s' 不存在", sheet)
+		}
+		sheetNames = []string{sheet}
+	}
+
+	previews := make([]sheetPreview, 0, len(sheetNames))
+	for _, name := range sheetNames {
+		rows, err := f.Rows(name)
+		if err != nil {
+			return nil, err
+		}
+		preview := sheetPreview{Name: name, Rows: [][]string{}}
+		for rows.Next() {
+			preview.RowCount++
+			if len(preview.Rows) >= maxRows {
+				continue
+			}
+			cells, err := rows.Columns()
+			if err != nil {
+				rows.Close()
+				return nil, err
+			}
+			preview.Rows = append(preview.Rows, cells)
+		}
+		rows.Close()
+		previews = append(previews, preview)
+	}
+	return previews, nil
+}
+
+// GetDatasetTreeHandler 返回数据集的文件树，便于前端确认上传的ZIP内容
+func GetDatasetTreeHandler(c *gin.Context) {
+	dataset, err := loadDataset(c.Param("id"))
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "数据集不存在"})
+			return
+		}
+		logger.Logger.Errorf("查询数据集失败: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "数据库查询失败"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"datasetId": dataset.ID, "tree": buildFileTree(dataset.Files)})
+}
+
+// PreviewDatasetFileHandler 预览数据集中的Excel文件，rows 指定每个工作表返回的行数
+func PreviewDatasetFileHandler(c *gin.Context) {
+	maxRows := defaultPreviewRows
+	if rowsParam := c.Query("rows"); rowsParam != "" {
+		n, err := strconv.Atoi(rowsParam)
+		if err != nil || n <= 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "rows 必须为正整数"})
+			return
+		}
+		maxRows = min(n, maxPreviewRows)
+	}
+
+	var file dao.DatasetFile
+	if err := dao.GetDB().Where("id = ? AND dataset_id = ?", c.Param("fileId"), c.Param("id")).First(&file).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "文件不存在"})
+			return
+		}
+		logger.Logger.Errorf("查询数据集文件失败: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "数据库查询失败"})
+		return
+	}
+	if ext := strings.ToLower(filepath.Ext(file.Name)); ext != ".xlsx" && ext != ".xlsm" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "仅支持预览 .xlsx 文件"})
+		return
+	}
+
+	sheets, err := previewExcel(file.Path, c.Query("sheet"), maxRows)
+	if err != nil {
+		logger.Logger.Errorf("预览Excel文件 %s 失败: %v", file.Path, err)
+		c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("读取Excel文件失败: %v", err)})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"fileId": file.ID, "name": file.Name, "role": file.Role, "sheets": sheets})
+}
@@ -0,0 +1,112 @@
+package handler
+
+import (
+	"bytes"
+	"fmt"
+	"github.com/gomarkdown/markdown"
+	"ningxia_backend/dao"
+	"path/filepath"
+	"strings"
+	"text/template"
+)
+
+var themeCSSTemplate = template.Must(template.New("theme").Parse(`
+{{- if .FontURL}}
+        @font-face { font-family: "{{.FontFamily}}"; src: url("{{.FontURL}}"); }
+{{- end}}
+        @page { size: {{.PaperSize}} {{.CSSOrientation}}; }
+        body {
+            font-family: "{{.FontFamily}}", sans-serif;
+            font-size: {{.BodyFontSize}}pt;
+            line-height: {{.LineHeight}};
+        }
+        h1 { font-size: {{.H1FontSize}}pt; margin-top: 20pt; margin-bottom: 10pt; }
+        h2 { font-size: {{.H2FontSize}}pt; margin-top: 18pt; margin-bottom: 8pt; }
+        h3 { font-size: {{.H3FontSize}}pt; margin-top: 16pt; margin-bottom: 6pt; }
+        p { font-size: {{.BodyFontSize}}pt; margin-top: 6pt; margin-bottom: 6pt; }
+        img { max-width: 100%; }
+        table {
+             border-collapse: collapse;
+             width: 100%;
+             margin-top: 10pt;
+             margin-bottom: 10pt;
+             font-size: {{.TableFontSize}}pt;
+        }
+        th, td {
+            border: 1px solid {{.TableBorderColor}};
+            padding: {{.TableCellPadding}}pt;
+            text-align: left;
+        }
+        th { background-color: {{.TableHeaderBackground}}; }
+`))
+
+// bundledFontFileURL 内置字体的本地文件地址，供 wkhtmltopdf 加载
+func bundledFontFileURL() (string, error) {
+	absFontFile, err := filepath.Abs(bundledFontFile)
+	if err != nil {
+		return "", fmt.Errorf("无法确定字体文件路径: %w", err)
+	}
+	return "file://" + filepath.ToSlash(absFontFile), nil
+}
+
+// themeCSS 根据样式主题生成报告CSS，使用内置字体时从 fontURL 加载
+func themeCSS(theme *dao.StyleTheme, fontURL string) (string, error) {
+	data := struct {
+		*dao.StyleTheme
+		FontURL        string
+		CSSOrientation string
+	}{StyleTheme: theme, CSSOrientation: strings.ToLower(theme.Orientation)}
+	if theme.FontFamily == bundledFontFamily {
+		data.FontURL = fontURL
+	}
+
+	var buf bytes.Buffer
+	if err := themeCSSTemplate.Execute(&buf, data); err != nil {
+		return "", err
+	}
+	return buf.String(), nil
+}
+
+// buildReportHTML 将 Markdown 报告渲染为带样式的 HTML
+func buildReportHTML(mdContent []byte, theme *dao.StyleTheme, fontURL string) (string, error) {
+	htmlContentString := string(markdown.ToHTML(mdContent, nil, nil))
+
+	css, err := themeCSS(theme, fontURL)
+	if err != nil {
+		return "", err
+	}
+	htmlHeadContent := `
+<head>
+    <meta charset="utf-8">
+    <style>` + css + `
+    </style>
+</head>
+`
+
+	htmlTagIndex := strings.Index(htmlContentString, "<html")
+	if htmlTagIndex != -1 {
+		htmlTagEndIndex := strings.Index(htmlContentString[htmlTagIndex:], ">")
+		if htmlTagEndIndex != -1 {
+			insertIndex := htmlTagIndex + htmlTagEndIndex + 1
+			return htmlContentString[:insertIndex] + "\n" + htmlHeadContent + "\n" + htmlContentString[insertIndex:], nil
+		}
+	}
+	return htmlHeadContent + "\n" + htmlContentString, nil
+}
+
+// wkhtmltopdfArgs 根据样式主题生成纸张、方向和页边距参数
+func wkhtmltopdfArgs(theme *dao.StyleTheme) []string {
+	args := []string{
+		"--page-size", theme.PaperSize,
+		"--orientation", theme.Orientation,
+		"--margin-top", fmt.Sprintf("%gmm", theme.MarginTop),
+		"--margin-right", fmt.Sprintf("%gmm", theme.MarginRight),
+		"--margin-bottom", fmt.Sprintf("%gmm", theme.MarginBottom),
+		"--margin-left", fmt.Sprintf("%gmm", theme.MarginLeft),
+	}
+	if theme.FontFamily == bundledFontFamily {
+		// 只有使用内置字体时需要读取本地字体文件
+		args = append(args, "--enable-local-file-access")
+	}
+	return args
+}
@@ -1,18 +1,400 @@
 package handler
 
 import (
+	"errors"
 	"fmt"
 	"github.com/gin-gonic/gin"
+	"github.com/xuri/excelize/v2"
+	"gorm.io/gorm"
+	"math"
 	"net/http"
 	"ningxia_backend/dao"
+	"ningxia_backend/pkg/conf"
+	"ningxia_backend/pkg/logger"
+	"ningxia_backend/pkg/stake"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
 )
 
+const (
+	RoadDirectionUp   = "up"
+	RoadDirectionDown = "down"
+	RoadDirectionBoth = "both"
+
+	TechGradeExpressway = "高速公路"
+
+	// 模板中的路网里程占位符，路网登记为空时使用配置 network.* 中的数值
+	networkPlaceholderPrefix = "NETWORK_"
+)
+
+var (
+	routeCodeRegexp = regexp.MustCompile(`^[GSXY]\d{1,4}$`)
+	// 路线编号首字母对应的行政等级
+	adminClasses = map[byte]string{'G': "国道", 'S': "省道", 'X': "县道", 'Y': "乡道"}
+	techGrades   = []string{TechGradeExpressway, "一级公路", "二级公路", "三级公路", "四级公路", "等外公路"}
+	// 导入表格和接口中可用的方向写法
+	roadDirections = map[string]string{
+		RoadDirectionUp: RoadDirectionUp, "上行": RoadDirectionUp,
+		RoadDirectionDown: RoadDirectionDown, "下行": RoadDirectionDown,
+		RoadDirectionBoth: RoadDirectionBoth, "双向": RoadDirectionBoth, "全幅": RoadDirectionBoth, "": RoadDirectionBoth,
+	}
+	roadImportColumns = []sheetColumn{
+		{"路线编号", "routeCode"},
+		{"路线名称", "name"},
+		{"技术等级", "techGrade"},
+		{"方向", "direction"},
+		{"起点桩号", "startStake"},
+		{"止点桩号", "endStake"},
+		{"里程", "length"},
+		{"管养单位", "managingUnit"},
+		{"所在地区", "region"},
+	}
+)
+
+// normalizeRoad 统一路线编号和方向写法，补全行政等级和里程，并校验取值
+func normalizeRoad(road *dao.Road) error {
+	road.RouteCode = strings.ToUpper(strings.TrimSpace(road.RouteCode))
+	road.Name = strings.TrimSpace(road.Name)
+	if !routeCodeRegexp.MatchString(road.RouteCode) {
+		return fmt.Errorf("路线编号'%s'无效，应为 G/S/X/Y 加数字", road.RouteCode)
+	}
+	if road.Name == "" {
+		return errors.New("路线名称不能为空")
+	}
+	road.AdminClass = adminClasses[road.RouteCode[0]]
+	if !slices.Contains(techGrades, road.TechGrade) {
+		return fmt.Errorf("技术等级'%s'无效，应为 %s 之一", road.TechGrade, strings.Join(techGrades, "、"))
+	}
+	direction, ok := roadDirections[strings.TrimSpace(road.Direction)]
+	if !ok {
+		return fmt.Errorf("方向'%s'无效，应为上行、下行或双向", road.Direction)
+	}
+	road.Direction = direction
+	if road.StartStake < 0 || road.EndStake <= road.StartStake {
+		return errors.New("止点桩号应大于起点桩号")
+	}
+	if road.Length == 0 {
+		// 跨越断链的路段按实际里程计算
+		chain, err := routeChain(road.RouteCode)
+		if err != nil {
+			return err
+		}
+		segment, err := chain.Segment(stake.FromKm(road.StartStake), stake.FromKm(road.EndStake))
+		if err != nil {
+			return err
+		}
+		road.Length = math.Round(segment.Length()) / 1000
+	}
+	if road.Length < 0 {
+		return errors.New("里程不能为负数")
+	}
+	return nil
+}
+
+// findRoadSegment 查找同一路线、同一方向、相同起点桩号的路段，excludeID 为正在修改的路段
+func findRoadSegment(db *gorm.DB, road *dao.Road, excludeID uint) (*dao.Road, error) {
+	var existing dao.Road
+	err := db.Where("route_code = ? AND direction = ? AND start_stake = ? AND id <> ?", road.RouteCode, road.Direction, road.StartStake, excludeID).
+		First(&existing).Error
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return &existing, nil
+}
+
+// loadRoad 按路径参数 id 获取路段，未找到或出错时直接写出应答
+func loadRoad(c *gin.Context) (*dao.Road, bool) {
+	id, err := strconv.ParseUint(c.Param("id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "无效的路段ID"})
+		return nil, false
+	}
+	var road dao.Road
+	if err = dao.GetDB().First(&road, id).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "路段不存在"})
+			return nil, false
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "数据库查询失败"})
+		return nil, false
+	}
+	return &road, true
+}
+
+// GetRoads 路网登记列表，可按路线编号、行政等级、技术等级、地区和管养单位筛选
 func GetRoads(c *gin.Context) {
-	var roads []dao.Road
-	if err := dao.GetDB().Find(&roads).Error; err != nil {
+	query := dao.GetDB().Order("route_code, direction, start_stake")
+	for param, column := range map[string]string{
+		"routeCode": "route_code", "adminClass": "admin_class", "techGrade": "tech_grade",
+		"region": "region", "managingUnit": "managing_unit",
+	} {
+		if value := c.Query(param); value != "" {
+			query = query.Where(column+" = ?", value)
+		}
+	}
+	roads := make([]dao.Road, 0)
+	if err := query.Find(&roads).Error; err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": fmt.Sprintf("获取路线名称失败: %v", err)})
 		return
 	}
 
 	c.JSON(http.StatusOK, roads)
 }
+
+func GetRoad(c *gin.Context) {
+	if road, ok := loadRoad(c); ok {
+		c.JSON(http.StatusOK, road)
+	}
+}
+
+func CreateRoad(c *gin.Context) {
+	var road dao.Road
+	if err := c.ShouldBindJSON(&road); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	road.ID = 0
+	saveRoad(c, &road)
+}
+
+func UpdateRoad(c *gin.Context) {
+	existing, ok := loadRoad(c)
+	if !ok {
+		return
+	}
+	var road dao.Road
+	if err := c.ShouldBindJSON(&road); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	road.ID, road.CreatedAt = existing.ID, existing.CreatedAt
+	saveRoad(c, &road)
+}
+
+func saveRoad(c *gin.Context, road *dao.Road) {
+	if err := normalizeRoad(road); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	duplicate, err := findRoadSegment(dao.GetDB(), road, road.ID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "数据库查询失败"})
+		return
+	}
+	if duplicate != nil {
+		c.JSON(http.StatusConflict, gin.H{"error": fmt.Sprintf("路段 %s %s 起点 %.3f 已登记", road.RouteCode, road.Direction, road.StartStake)})
+		return
+	}
+	if err = dao.GetDB().Save(road).Error; err != nil {
+		logger.Logger.Errorf("保存路段失败: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "保存路段失败"})
+		return
+	}
+	c.JSON(http.StatusOK, road)
+}
+
+func DeleteRoad(c *gin.Context) {
+	road, ok := loadRoad(c)
+	if !ok {
+		return
+	}
+	if err := dao.GetDB().Delete(road).Error; err != nil {
+		logger.Logger.Errorf("删除路段失败: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "删除路段失败"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": fmt.Sprintf("路段 %s %s 已删除", road.RouteCode, road.Name)})
+}
+
+// parseRoadRow 将导入表格的一行转换为路段
+func parseRoadRow(cells map[string]string) (*dao.Road, error) {
+	road := &dao.Road{
+		RouteCode:    cells["routeCode"],
+		Name:         cells["name"],
+		TechGrade:    cells["techGrade"],
+		Direction:    cells["direction"],
+		ManagingUnit: cells["managingUnit"],
+		Region:       cells["region"],
+	}
+	start, err := stake.Parse(cells["startStake"])
+	if err != nil {
+		return nil, fmt.Errorf("起点%w", err)
+	}
+	end, err := stake.Parse(cells["endStake"])
+	if err != nil {
+		return nil, fmt.Errorf("止点%w", err)
+	}
+	road.StartStake, road.EndStake = start.Km(), end.Km()
+	if length := cells["length"]; length != "" {
+		if road.Length, err = strconv.ParseFloat(length, 64); err != nil {
+			return nil, fmt.Errorf("里程'%s'不是数字", length)
+		}
+	}
+	return road, normalizeRoad(road)
+}
+
+// ImportRoadsHandler 从 xlsx 第一个工作表导入路网登记，同一路线、方向和起点桩号的路段覆盖更新。
+// dryRun=true 时只校验并返回新增和更新数量；任一行有错误则不写入
+func ImportRoadsHandler(c *gin.Context) {
+	dryRun, _ := strconv.ParseBool(c.Query("dryRun"))
+	file, err := c.FormFile("file")
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "请上传路网登记表"})
+		return
+	}
+	src, err := file.Open()
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "读取上传文件失败"})
+		return
+	}
+	defer src.Close()
+	f, err := excelize.OpenReader(src)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "无法解析Excel文件"})
+		return
+	}
+	defer f.Close()
+
+	sheet := f.GetSheetName(0)
+	cellsList, rowNums, err := readSheetColumns(f, sheet, roadImportColumns)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	issues := make([]excelIssue, 0)
+	roads := make([]*dao.Road, 0, len(cellsList))
+	seen := make(map[string]int)
+	created, updated := 0, 0
+	for i, cells := range cellsList {
+		road, err := parseRoadRow(cells)
+		if err != nil {
+			issues = append(issues, excelIssue{Sheet: sheet, Row: rowNums[i], Message: err.Error()})
+			continue
+		}
+		key := fmt.Sprintf("%s/%s/%.3f", road.RouteCode, road.Direction, road.StartStake)
+		if first, ok := seen[key]; ok {
+			issues = append(issues, excelIssue{Sheet: sheet, Row: rowNums[i], Message: fmt.Sprintf("与第 %d 行重复", first)})
+			continue
+		}
+		seen[key] = rowNums[i]
+		existing, err := findRoadSegment(dao.GetDB(), road, 0)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "数据库查询失败"})
+			return
+		}
+		if existing != nil {
+			road.ID, road.CreatedAt = existing.ID, existing.CreatedAt
+			updated++
+		} else {
+			created++
+		}
+		roads = append(roads, road)
+	}
+
+	resp := gin.H{"dryRun": dryRun, "created": created, "updated": updated, "issues": issues, "valid": len(issues) == 0}
+	if dryRun {
+		c.JSON(http.StatusOK, resp)
+		return
+	}
+	if len(issues) > 0 {
+		resp["error"] = "导入文件有错误，未写入任何路段"
+		c.JSON(http.StatusBadRequest, resp)
+		return
+	}
+	err = dao.GetDB().Transaction(func(tx *gorm.DB) error {
+		for _, road := range roads {
+			if err := tx.Save(road).Error; err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		logger.Logger.Errorf("导入路网登记失败: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "导入路网登记失败"})
+		return
+	}
+	resp["message"] = "路网登记导入成功"
+	c.JSON(http.StatusOK, resp)
+}
+
+// networkTotals 路网里程统计，单位 km。分上下行登记的路段只计上行，避免重复计算里程
+type networkTotals struct {
+	TotalKm      float64            `json:"totalKm"`
+	ExpresswayKm float64            `json:"expresswayKm"`
+	TrunkKm      float64            `json:"trunkKm"` // 普通国省干线，不含高速公路
+	RouteCount   int                `json:"routeCount"`
+	ByAdminClass map[string]float64 `json:"byAdminClass"`
+	ByTechGrade  map[string]float64 `json:"byTechGrade"`
+	ByRegion     map[string]float64 `json:"byRegion"`
+}
+
+func computeNetworkTotals() (*networkTotals, error) {
+	var roads []dao.Road
+	if err := dao.GetDB().Where("route_code <> '' AND direction <> ?", RoadDirectionDown).Find(&roads).Error; err != nil {
+		return nil, err
+	}
+	totals := &networkTotals{ByAdminClass: map[string]float64{}, ByTechGrade: map[string]float64{}, ByRegion: map[string]float64{}}
+	routes := make(map[string]bool)
+	for _, road := range roads {
+		routes[road.RouteCode] = true
+		totals.TotalKm += road.Length
+		totals.ByAdminClass[road.AdminClass] += road.Length
+		totals.ByTechGrade[road.TechGrade] += road.Length
+		if road.Region != "" {
+			totals.ByRegion[road.Region] += road.Length
+		}
+		if road.TechGrade == TechGradeExpressway {
+			totals.ExpresswayKm += road.Length
+		} else if road.AdminClass == adminClasses['G'] || road.AdminClass == adminClasses['S'] {
+			totals.TrunkKm += road.Length
+		}
+	}
+	totals.RouteCount = len(routes)
+	return totals, nil
+}
+
+func formatKm(km float64) string {
+	return strconv.FormatFloat(math.Round(km*1000)/1000, 'f', -1, 64)
+}
+
+// networkPlaceholders 模板中的路网里程：NETWORK_TOTAL_KM、NETWORK_EXPRESSWAY_KM、NETWORK_TRUNK_KM、
+// NETWORK_G_KM 等按行政等级的里程，以及 NETWORK_ROUTE_COUNT
+func networkPlaceholders() map[string]string {
+	totals, err := computeNetworkTotals()
+	if err != nil {
+		logger.Logger.Errorf("统计路网里程失败: %v", err)
+		totals = &networkTotals{}
+	}
+	km := func(value float64, fallbackKey string) string {
+		if value == 0 {
+			return conf.Conf.GetString(fallbackKey)
+		}
+		return formatKm(value)
+	}
+	placeholders := map[string]string{
+		networkPlaceholderPrefix + "TOTAL_KM":      formatKm(totals.TotalKm),
+		networkPlaceholderPrefix + "EXPRESSWAY_KM": km(totals.ExpresswayKm, "network.expresswayKm"),
+		networkPlaceholderPrefix + "TRUNK_KM":      km(totals.TrunkKm, "network.trunkKm"),
+		networkPlaceholderPrefix + "ROUTE_COUNT":   strconv.Itoa(totals.RouteCount),
+	}
+	for letter, class := range adminClasses {
+		placeholders[networkPlaceholderPrefix+string(letter)+"_KM"] = formatKm(totals.ByAdminClass[class])
+	}
+	return placeholders
+}
+
+func GetNetworkTotalsHandler(c *gin.Context) {
+	totals, err := computeNetworkTotals()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "数据库查询失败"})
+		return
+	}
+	c.JSON(http.StatusOK, totals)
+}
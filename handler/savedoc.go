@@ -17,11 +17,12 @@ import (
 func SaveDocxHandler(pySuffix string) func(c *gin.Context) {
 	return func(c *gin.Context) {
 		var req struct {
-			Files      []string `json:"files"`
+			DatasetIDs []string `json:"datasetIds"`
 			ReportType string   `json:"reportType"`
 			Mileage    float64  `json:"mileage"`
 			PQI        float64  `json:"pqi"`
 			Timestamp  int64    `json:"timestamp"`
+			CampaignID *uint    `json:"campaignId"` // 为空时沿用数据集所属的抽检批次
 		}
 		if err := c.ShouldBindJSON(&req); err != nil {
 			logger.Logger.Errorf("无效请求: %v", err)
@@ -29,7 +30,46 @@ func SaveDocxHandler(pySuffix string) func(c *gin.Context) {
 			return
 		}
 
-		data, err := calculate(pySuffix, req.ReportType, req.Files, req.PQI, req.Mileage)
+		// 计算结束前清理任务不删除这些数据集
+		release := useDatasets(req.DatasetIDs)
+		defer release()
+		// 输入文件路径由服务器根据数据集解析，不信任客户端提供的路径
+		inputFiles, err := resolveDatasetFiles(req.DatasetIDs)
+		if err != nil {
+			logger.Logger.Errorf("解析数据集失败: %v", err)
+			c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		// 计算前核对路网登记，存在阻止类问题时不生成报告
+		quality, err := checkDataQuality(inputFiles)
+		if err != nil {
+			logger.Logger.Errorf("数据质量检查失败: %v", err)
+			c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": "数据质量检查失败"})
+			return
+		}
+		if quality.Blocking {
+			c.AbortWithStatusJSON(http.StatusUnprocessableEntity, gin.H{"error": "上传数据未通过质量检查", "quality": quality})
+			return
+		}
+
+		campaign, err := reportCampaign(req.CampaignID, req.DatasetIDs)
+		if err != nil {
+			c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		var campaignID *uint
+		if campaign != nil {
+			campaignID = &campaign.ID
+		}
+
+		settings, err := takeSettingsSnapshot(time.Unix(req.Timestamp, 0).Year())
+		if err != nil {
+			logger.Logger.Errorf("读取指标配置失败: %v", err)
+			c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": "读取指标配置失败"})
+			return
+		}
+
+		data, err := calculate(pySuffix, req.ReportType, datasetFilePaths(inputFiles), req.PQI, req.Mileage)
 		if err != nil {
 			c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": "计算失败"})
 			return
@@ -64,16 +104,8 @@ func SaveDocxHandler(pySuffix string) func(c *gin.Context) {
 
 		docxFile := doc.Editable()
 		content := docxFile.GetContent()
-		for key, value := range data {
-			if key != PyRespImagesKey {
-				valStr := fmt.Sprintf("%v", value)
-				if valStr == "" {
-					content = strings.ReplaceAll(content, key, " ")
-				} else {
-					content = strings.ReplaceAll(content, key, valStr)
-				}
-			}
-		}
+		values := templateValues(req.ReportType, data, settings, campaignID)
+		content = fillPlaceholders(content, values)
 		docxFile.SetContent(content)
 
 		// --- 5. 准备报告目录和基础名称 ---
@@ -163,17 +195,7 @@ func SaveDocxHandler(pySuffix string) func(c *gin.Context) {
 				extraContent := extraDocxFile.GetContent()
 
 				// 替换 extra 模板中的文本 (使用相同的数据)
-				for key, value := range data {
-					if key != PyRespImagesKey {
-						valStr := fmt.Sprintf("%v", value)
-						placeholder := key
-						if valStr == "" {
-							extraContent = strings.ReplaceAll(extraContent, placeholder, " ")
-						} else {
-							extraContent = strings.ReplaceAll(extraContent, placeholder, valStr)
-						}
-					}
-				}
+				extraContent = fillPlaceholders(extraContent, values)
 				extraDocxFile.SetContent(extraContent)
 
 				extraImages, extraOk := data[PyRespExtraImagesKey].([]any)
@@ -223,6 +245,7 @@ func SaveDocxHandler(pySuffix string) func(c *gin.Context) {
 		c.JSON(http.StatusOK, gin.H{
 			"message":  "docx报告生成成功",
 			"filename": reportFilename,
+			"quality":  quality,
 		})
 	}
 }
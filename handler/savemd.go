@@ -1,24 +1,30 @@
 package handler
 
 import (
+	"encoding/json"
 	"fmt"
 	"github.com/gin-gonic/gin"
 	"net/http"
-	"net/url"
+	"ningxia_backend/dao"
+	"ningxia_backend/pkg/conf"
 	"ningxia_backend/pkg/logger"
 	"os"
 	"path/filepath"
 	"strings"
+	"time"
 )
 
 func SaveMdHandler(pySuffix string) func(c *gin.Context) {
 	return func(c *gin.Context) {
 		var req struct {
-			Files      []string `json:"files"`
+			DatasetIDs []string `json:"datasetIds"`
 			ReportType string   `json:"reportType"`
 			Mileage    float64  `json:"mileage"`
 			PQI        float64  `json:"pqi"`
 			Timestamp  int64    `json:"timestamp"`
+			Year       int      `json:"year"`
+			Unit       string   `json:"unit"`
+			CampaignID *uint    `json:"campaignId"` // 为空时沿用数据集所属的抽检批次
 		}
 		if err := c.ShouldBindJSON(&req); err != nil {
 			logger.Logger.Errorf("无效请求: %v", err)
@@ -26,7 +32,53 @@ func SaveMdHandler(pySuffix string) func(c *gin.Context) {
 			return
 		}
 
-		data, err := calculate(pySuffix, req.ReportType, req.Files, req.PQI, req.Mileage)
+		// 计算结束前清理任务不删除这些数据集
+		release := useDatasets(req.DatasetIDs)
+		defer release()
+		// 输入文件路径由服务器根据数据集解析，不信任客户端提供的路径
+		inputFiles, err := resolveDatasetFiles(req.DatasetIDs)
+		if err != nil {
+			logger.Logger.Errorf("解析数据集失败: %v", err)
+			c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		// 计算前核对路网登记，存在阻止类问题时不生成报告
+		quality, err := checkDataQuality(inputFiles)
+		if err != nil {
+			logger.Logger.Errorf("数据质量检查失败: %v", err)
+			c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": "数据质量检查失败"})
+			return
+		}
+		if quality.Blocking {
+			c.AbortWithStatusJSON(http.StatusUnprocessableEntity, gin.H{"error": "上传数据未通过质量检查", "quality": quality})
+			return
+		}
+
+		campaign, err := reportCampaign(req.CampaignID, req.DatasetIDs)
+		if err != nil {
+			c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		var campaignID *uint
+		year := req.Year
+		if campaign != nil {
+			campaignID = &campaign.ID
+			if year == 0 {
+				year = campaign.Year
+			}
+		}
+		if year == 0 {
+			year = time.Unix(req.Timestamp, 0).Year()
+		}
+		// 冻结本次使用的指标配置，计算程序和报告登记都使用这份副本
+		settings, err := takeSettingsSnapshot(year)
+		if err != nil {
+			logger.Logger.Errorf("读取指标配置失败: %v", err)
+			c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": "读取指标配置失败"})
+			return
+		}
+
+		data, err := calculate(pySuffix, req.ReportType, datasetFilePaths(inputFiles), req.PQI, req.Mileage)
 		if err != nil {
 			c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": "计算失败"})
 			return
@@ -57,16 +109,7 @@ func SaveMdHandler(pySuffix string) func(c *gin.Context) {
 		}
 		content := string(mdBytes)
 
-		for key, value := range data {
-			if key != PyRespImagesKey {
-				valStr := fmt.Sprintf("%v", value)
-				if valStr == "" {
-					content = strings.ReplaceAll(content, key, " ")
-				} else {
-					content = strings.ReplaceAll(content, key, valStr)
-				}
-			}
-		}
+		content = fillPlaceholders(content, templateValues(req.ReportType, data, settings, campaignID))
 
 		reportBaseName := fmt.Sprintf("%s_%d", ReportNameMap[req.ReportType], req.Timestamp)
 		images, ok := data[PyRespImagesKey].([]any)
@@ -74,8 +117,7 @@ func SaveMdHandler(pySuffix string) func(c *gin.Context) {
 			for _, image := range images {
 				oldImageName := fmt.Sprintf("%s", image)
 				newImageName := fmt.Sprintf("%s/images/%v", reportBaseName, image)
-				imageUrl := fmt.Sprintf("http://127.0.0.1:12345/file?name=%s", url.QueryEscape(newImageName))
-				content = strings.ReplaceAll(content, oldImageName, imageUrl)
+				content = strings.ReplaceAll(content, oldImageName, reportImageLink(newImageName))
 			}
 		}
 
@@ -94,9 +136,53 @@ func SaveMdHandler(pySuffix string) func(c *gin.Context) {
 		}
 
 		logger.Logger.Infof("Markdown报告已生成: %s", reportFileFullName)
+
+		// 保存本次计算结果，导出PDF时可作为附件
+		resultJson, err := json.MarshalIndent(data, "", "  ")
+		if err == nil {
+			err = os.WriteFile(filepath.Join(reportsBaseDir, reportBaseName, reportResultFile), resultJson, 0644)
+		}
+		if err != nil {
+			logger.Logger.Errorf("保存计算结果 (%s) 失败: %v", reportBaseName, err)
+		}
+
+		// 登记报告目录，导出归档PDF时作为元数据来源
+		report := dao.Report{
+			Filename:         reportBaseName,
+			ReportType:       req.ReportType,
+			Year:             year,
+			Unit:             req.Unit,
+			TemplateVersion:  templateVersion(mdBytes),
+			DatasetIDs:       req.DatasetIDs,
+			ContentHash:      contentHash([]byte(content)),
+			Status:           ReportStatusActive,
+			CampaignID:       campaignID,
+			SettingsSnapshot: settings,
+		}
+		if report.Unit == "" {
+			report.Unit = conf.Conf.GetString("report.unit")
+		}
+		if err = dao.GetDB().Where(dao.Report{Filename: reportBaseName}).Assign(report).FirstOrCreate(&report).Error; err != nil {
+			logger.Logger.Errorf("登记报告目录 (%s) 失败: %v", reportBaseName, err)
+		}
+		// 同一批次（未关联批次的报告之间）同类型、同年度、同单位且更早登记的报告视为被本报告修订。
+		// 重新生成旧时间戳的报告时沿用原登记时间，不会把之后的报告标记为被修订
+		revised := dao.GetDB().Model(&dao.Report{}).
+			Where("report_type = ? AND year = ? AND unit = ? AND filename <> ? AND revised_by = ''", report.ReportType, report.Year, report.Unit, reportBaseName).
+			Where("created_at < ?", report.CreatedAt)
+		if campaignID != nil {
+			revised = revised.Where("campaign_id = ?", *campaignID)
+		} else {
+			revised = revised.Where("campaign_id IS NULL")
+		}
+		err = revised.Update("revised_by", reportBaseName).Error
+		if err != nil {
+			logger.Logger.Errorf("标记被修订的旧报告失败: %v", err)
+		}
 		c.JSON(http.StatusOK, gin.H{
 			"message":  "Markdown报告生成成功",
 			"filename": reportFilename,
+			"quality":  quality,
 		})
 	}
 }
@@ -1,35 +1,248 @@
 package handler
 
 import (
+	"encoding/json"
 	"errors"
 	"fmt"
 	"github.com/gin-gonic/gin"
 	"gorm.io/gorm"
 	"net/http"
 	"ningxia_backend/dao"
+	"ningxia_backend/pkg/logger"
 	"strconv"
 )
 
+const (
+	settingKindProvince = "province"
+	settingKindNational = "national"
+
+	settingActionCreate  = "create"
+	settingActionUpdate  = "update"
+	settingActionDelete  = "delete"
+	settingActionRestore = "restore"
+
+	// 修改人取自请求头，未提供时记录客户端地址
+	operatorHeader = "X-Operator"
+)
+
+func validatePercent(name string, value float64) error {
+	if value < 0 || value > 100 {
+		return fmt.Errorf("%s应在 0 到 100 之间", name)
+	}
+	return nil
+}
+
+func validateProvinceSetting(setting *dao.ProvinceSetting) error {
+	if setting.Year < 2000 || setting.Year > 2100 {
+		return fmt.Errorf("年份应在 2000 到 2100 之间")
+	}
+	for _, field := range []struct {
+		name  string
+		value float64
+	}{
+		{"高速公路指标", setting.Expressway}, {"普通国道指标", setting.NationalHighway},
+		{"普通省道指标", setting.ProvincialHighway}, {"农村公路指标", setting.RuralRoad},
+	} {
+		if err := validatePercent(field.name, field.value); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func validateNationalSetting(setting *dao.NationalSetting) error {
+	if err := dao.GetDB().Where("name = ?", setting.Plan).First(&dao.PlanningPeriod{}).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return fmt.Errorf("规划期'%s'不存在，请先配置规划期", setting.Plan)
+		}
+		return err
+	}
+	for _, field := range []struct {
+		name  string
+		value float64
+	}{
+		{"MQI优良率", setting.MQIExcellent}, {"PQI优良率", setting.PQIExcellent},
+		{"桥梁一二类比例", setting.BridgeRate}, {"旧料循环利用率", setting.RecycleRate},
+		{"东部普通国道MQI", setting.NationalMQIEast}, {"中部普通国道MQI", setting.NationalMQICentral},
+		{"西部普通国道MQI", setting.NationalMQIWest}, {"东部普通国道PQI", setting.NationalPQIEast},
+		{"中部普通国道PQI", setting.NationalPQICentral}, {"西部普通国道PQI", setting.NationalPQIWest},
+		{"东部普通省道MQI", setting.ProvincialMQIEast}, {"中部普通省道MQI", setting.ProvincialMQICentral},
+		{"西部普通省道MQI", setting.ProvincialMQIWest}, {"东部普通省道PQI", setting.ProvincialPQIEast},
+		{"中部普通省道PQI", setting.ProvincialPQICentral}, {"西部普通省道PQI", setting.ProvincialPQIWest},
+		{"农村公路MQI", setting.RuralMQI}, {"养护工程比例", setting.MaintenanceRate},
+	} {
+		if err := validatePercent(field.name, field.value); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func settingOperator(c *gin.Context) string {
+	if operator := c.GetHeader(operatorHeader); operator != "" {
+		return operator
+	}
+	return c.ClientIP()
+}
+
+// appendSettingRevision 比较修改前后的配置，有变化时追加一条修改记录。old 为 nil 表示新增，current 为 nil 表示删除
+func appendSettingRevision(tx *gorm.DB, kind, key, operator, action string, restoredFrom int, old, current any) (*dao.SettingRevision, error) {
+	changes := settingChanges(settingFields(old), settingFields(current))
+	if len(changes) == 0 && action != settingActionDelete {
+		return nil, nil
+	}
+
+	var last dao.SettingRevision
+	version := 1
+	err := tx.Where("kind = ? AND setting_key = ?", kind, key).Order("version DESC").First(&last).Error
+	if err == nil {
+		version = last.Version + 1
+	} else if !errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, err
+	}
+
+	revision := &dao.SettingRevision{
+		Kind:         kind,
+		Key:          key,
+		Version:      version,
+		Action:       action,
+		RestoredFrom: restoredFrom,
+		Operator:     operator,
+		Changes:      changes,
+	}
+	if current != nil {
+		revision.Content = settingFields(current)
+	}
+	if err = tx.Create(revision).Error; err != nil {
+		return nil, err
+	}
+	return revision, nil
+}
+
+// upsertProvinceSetting 按年份新增或覆盖省厅配置并记录修改，action 为空时根据是否已存在判断
+func upsertProvinceSetting(db *gorm.DB, setting *dao.ProvinceSetting, operator, action string, restoredFrom int) (revision *dao.SettingRevision, err error) {
+	err = db.Transaction(func(tx *gorm.DB) error {
+		var existing dao.ProvinceSetting
+		var old any
+		err := tx.Where("year = ?", setting.Year).First(&existing).Error
+		if err == nil {
+			old = &existing
+		} else if !errors.Is(err, gorm.ErrRecordNotFound) {
+			return err
+		}
+		if action == "" {
+			action = settingActionCreate
+			if old != nil {
+				action = settingActionUpdate
+			}
+		}
+		setting.Model = existing.Model
+		if err = tx.Save(setting).Error; err != nil {
+			return err
+		}
+		revision, err = appendSettingRevision(tx, settingKindProvince, strconv.Itoa(setting.Year), operator, action, restoredFrom, old, setting)
+		return err
+	})
+	return revision, err
+}
+
+// upsertNationalSetting 按规划名称新增或覆盖交通部配置并记录修改，action 为空时根据是否已存在判断
+func upsertNationalSetting(db *gorm.DB, setting *dao.NationalSetting, operator, action string, restoredFrom int) (revision *dao.SettingRevision, err error) {
+	err = db.Transaction(func(tx *gorm.DB) error {
+		var existing dao.NationalSetting
+		var old any
+		err := tx.Where("plan = ?", setting.Plan).First(&existing).Error
+		if err == nil {
+			old = &existing
+		} else if !errors.Is(err, gorm.ErrRecordNotFound) {
+			return err
+		}
+		if action == "" {
+			action = settingActionCreate
+			if old != nil {
+				action = settingActionUpdate
+			}
+		}
+		setting.Model = existing.Model
+		if err = tx.Save(setting).Error; err != nil {
+			return err
+		}
+		revision, err = appendSettingRevision(tx, settingKindNational, setting.Plan, operator, action, restoredFrom, old, setting)
+		return err
+	})
+	return revision, err
+}
+
+// deleteSetting 删除 where 条件匹配的配置并记录删除，setting 为对应类型的空指针
+func deleteSetting(kind, key, operator string, setting any, where string) error {
+	return dao.GetDB().Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where(where, key).First(setting).Error; err != nil {
+			return err
+		}
+		if err := tx.Unscoped().Delete(setting).Error; err != nil {
+			return err
+		}
+		_, err := appendSettingRevision(tx, kind, key, operator, settingActionDelete, 0, setting, nil)
+		return err
+	})
+}
+
 func SaveProvinceSettings(c *gin.Context) {
 	var setting dao.ProvinceSetting
 	if err := c.ShouldBindJSON(&setting); err != nil {
-		c.JSON(400, gin.H{"error": err.Error()})
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if err := validateProvinceSetting(&setting); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
 	}
 
-	dao.GetDB().Save(&setting)
-	c.JSON(200, gin.H{"message": "省厅指标保存成功"})
+	revision, err := upsertProvinceSetting(dao.GetDB(), &setting, settingOperator(c), "", 0)
+	if err != nil {
+		logger.Logger.Errorf("保存%d年省厅指标失败: %v", setting.Year, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "省厅指标保存失败"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "省厅指标保存成功", "revision": revision})
 }
 
 func SaveNationalSettings(c *gin.Context) {
 	var setting dao.NationalSetting
 	if err := c.ShouldBindJSON(&setting); err != nil {
-		c.JSON(400, gin.H{"error": err.Error()})
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if err := validateNationalSetting(&setting); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
 	}
 
-	dao.GetDB().Save(&setting)
-	c.JSON(200, gin.H{"message": "交通部指标保存成功"})
+	revision, err := upsertNationalSetting(dao.GetDB(), &setting, settingOperator(c), "", 0)
+	if err != nil {
+		logger.Logger.Errorf("保存%s交通部指标失败: %v", setting.Plan, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "交通部指标保存失败"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "交通部指标保存成功", "revision": revision})
+}
+
+func GetProvinceSettings(c *gin.Context) {
+	settings := make([]dao.ProvinceSetting, 0)
+	if err := dao.GetDB().Order("year DESC").Find(&settings).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "数据库查询失败"})
+		return
+	}
+	c.JSON(http.StatusOK, settings)
+}
+
+func GetNationalSettings(c *gin.Context) {
+	settings := make([]dao.NationalSetting, 0)
+	if err := dao.GetDB().Order("plan").Find(&settings).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "数据库查询失败"})
+		return
+	}
+	c.JSON(http.StatusOK, settings)
 }
 
 func GetProvinceSetting(c *gin.Context) {
@@ -68,3 +281,141 @@ func GetNationalSetting(c *gin.Context) {
 
 	c.JSON(http.StatusOK, setting)
 }
+
+func DeleteProvinceSetting(c *gin.Context) {
+	year := c.Param("year")
+	if _, err := strconv.Atoi(year); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "无效的年份参数"})
+		return
+	}
+	if err := deleteSetting(settingKindProvince, year, settingOperator(c), &dao.ProvinceSetting{}, "year = ?"); err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": fmt.Sprintf("未找到%s年的省厅配置", year)})
+			return
+		}
+		logger.Logger.Errorf("删除%s年省厅指标失败: %v", year, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "删除省厅指标失败"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": fmt.Sprintf("%s年省厅指标已删除", year)})
+}
+
+func DeleteNationalSetting(c *gin.Context) {
+	plan := c.Param("plan")
+	if err := deleteSetting(settingKindNational, plan, settingOperator(c), &dao.NationalSetting{}, "plan = ?"); err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": fmt.Sprintf("未找到计划'%s'的交通部配置", plan)})
+			return
+		}
+		logger.Logger.Errorf("删除%s交通部指标失败: %v", plan, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "删除交通部指标失败"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": fmt.Sprintf("%s交通部指标已删除", plan)})
+}
+
+func getSettingHistory(c *gin.Context, kind, key string) {
+	revisions := make([]dao.SettingRevision, 0)
+	if err := dao.GetDB().Where("kind = ? AND setting_key = ?", kind, key).Order("version DESC").Find(&revisions).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "数据库查询失败"})
+		return
+	}
+	c.JSON(http.StatusOK, revisions)
+}
+
+// loadSettingRevision 按路径参数 version 获取修改记录，未找到或出错时直接写出应答
+func loadSettingRevision(c *gin.Context, kind, key string) (*dao.SettingRevision, bool) {
+	version, err := strconv.Atoi(c.Param("version"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "无效的版本号"})
+		return nil, false
+	}
+	var revision dao.SettingRevision
+	err = dao.GetDB().Where("kind = ? AND setting_key = ? AND version = ?", kind, key, version).First(&revision).Error
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": fmt.Sprintf("未找到版本 %d", version)})
+			return nil, false
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "数据库查询失败"})
+		return nil, false
+	}
+	return &revision, true
+}
+
+// settingFromFields 将按 JSON 字段名展开的配置还原为 setting
+func settingFromFields(fields map[string]any, setting any) error {
+	b, err := json.Marshal(fields)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(b, setting)
+}
+
+// restoreContent 将修改记录中的完整配置还原到 setting，删除记录没有可恢复的内容
+func restoreContent(revision *dao.SettingRevision, setting any) error {
+	if revision.Content == nil {
+		return fmt.Errorf("版本 %d 为删除记录，无法恢复", revision.Version)
+	}
+	return settingFromFields(revision.Content, setting)
+}
+
+func GetProvinceSettingHistory(c *gin.Context) {
+	getSettingHistory(c, settingKindProvince, c.Param("year"))
+}
+
+func GetNationalSettingHistory(c *gin.Context) {
+	getSettingHistory(c, settingKindNational, c.Param("plan"))
+}
+
+func GetProvinceSettingVersion(c *gin.Context) {
+	if revision, ok := loadSettingRevision(c, settingKindProvince, c.Param("year")); ok {
+		c.JSON(http.StatusOK, revision)
+	}
+}
+
+func GetNationalSettingVersion(c *gin.Context) {
+	if revision, ok := loadSettingRevision(c, settingKindNational, c.Param("plan")); ok {
+		c.JSON(http.StatusOK, revision)
+	}
+}
+
+// RestoreProvinceSetting 将省厅配置恢复为历史版本的内容，恢复本身也作为一次修改记录
+func RestoreProvinceSetting(c *gin.Context) {
+	revision, ok := loadSettingRevision(c, settingKindProvince, c.Param("year"))
+	if !ok {
+		return
+	}
+	var setting dao.ProvinceSetting
+	if err := restoreContent(revision, &setting); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	current, err := upsertProvinceSetting(dao.GetDB(), &setting, settingOperator(c), settingActionRestore, revision.Version)
+	if err != nil {
+		logger.Logger.Errorf("恢复%d年省厅指标失败: %v", setting.Year, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "恢复省厅指标失败"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": fmt.Sprintf("已恢复到版本 %d", revision.Version), "setting": setting, "revision": current})
+}
+
+// RestoreNationalSetting 将交通部配置恢复为历史版本的内容，恢复本身也作为一次修改记录
+func RestoreNationalSetting(c *gin.Context) {
+	revision, ok := loadSettingRevision(c, settingKindNational, c.Param("plan"))
+	if !ok {
+		return
+	}
+	var setting dao.NationalSetting
+	if err := restoreContent(revision, &setting); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	current, err := upsertNationalSetting(dao.GetDB(), &setting, settingOperator(c), settingActionRestore, revision.Version)
+	if err != nil {
+		logger.Logger.Errorf("恢复%s交通部指标失败: %v", setting.Plan, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "恢复交通部指标失败"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": fmt.Sprintf("已恢复到版本 %d", revision.Version), "setting": setting, "revision": current})
+}
@@ -0,0 +1,367 @@
+package handler
+
+import (
+	"errors"
+	"fmt"
+	"github.com/gin-gonic/gin"
+	"github.com/xuri/excelize/v2"
+	"gorm.io/gorm"
+	"net/http"
+	"net/url"
+	"ningxia_backend/dao"
+	"ningxia_backend/pkg/logger"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// 指标配置表格式：两个工作表，第一行为表头，每行一条配置。表头按名称匹配，列顺序不限
+const (
+	provinceSettingSheet = "省厅指标"
+	nationalSettingSheet = "交通部指标"
+)
+
+var errSettingLayout = errors.New("指标配置表格式有误")
+
+// sheetColumn 表头与 JSON 字段的对应关系，第一列为主键
+type sheetColumn struct {
+	Header string
+	Field  string
+}
+
+var (
+	provinceSettingColumns = []sheetColumn{
+		{"年份", "year"},
+		{"高速公路指标", "expressway"},
+		{"普通国道指标", "nationalHighway"},
+		{"普通省道指标", "provincialHighway"},
+		{"农村公路指标", "ruralRoad"},
+	}
+	nationalSettingColumns = []sheetColumn{
+		{"规划期", "plan"},
+		{"MQI优良率", "mqiExcellent"},
+		{"PQI优良率", "pqiExcellent"},
+		{"桥梁一二类比例", "bridgeRate"},
+		{"旧料循环利用率", "recycleRate"},
+		{"东部普通国道MQI", "nationalMqiEast"},
+		{"中部普通国道MQI", "nationalMqiCentral"},
+		{"西部普通国道MQI", "nationalMqiWest"},
+		{"东部普通国道PQI", "nationalPqiEast"},
+		{"中部普通国道PQI", "nationalPqiCentral"},
+		{"西部普通国道PQI", "nationalPqiWest"},
+		{"东部普通省道MQI", "provincialMqiEast"},
+		{"中部普通省道MQI", "provincialMqiCentral"},
+		{"西部普通省道MQI", "provincialMqiWest"},
+		{"东部普通省道PQI", "provincialPqiEast"},
+		{"中部普通省道PQI", "provincialPqiCentral"},
+		{"西部普通省道PQI", "provincialPqiWest"},
+		{"农村公路MQI", "ruralMqi"},
+		{"养护工程比例", "maintenanceRate"},
+	}
+)
+
+// settingImportRow 导入文件中一行配置与数据库现有配置的比较结果
+type settingImportRow struct {
+	Kind    string              `json:"kind"`
+	Key     string              `json:"key"`
+	Sheet   string              `json:"sheet"`
+	Row     int                 `json:"row"`
+	Action  string              `json:"action"` // create / update / unchanged
+	Changes []dao.SettingChange `json:"changes"`
+
+	province *dao.ProvinceSetting
+	national *dao.NationalSetting
+}
+
+// writeSettingSheet 写出一个工作表，rows 为各配置按 JSON 字段展开的结果
+func writeSettingSheet(f *excelize.File, sheet string, columns []sheetColumn, rows []map[string]any) error {
+	if _, err := f.NewSheet(sheet); err != nil {
+		return err
+	}
+	for i, column := range columns {
+		cell, _ := excelize.CoordinatesToCellName(i+1, 1)
+		if err := f.SetCellValue(sheet, cell, column.Header); err != nil {
+			return err
+		}
+	}
+	for r, row := range rows {
+		for i, column := range columns {
+			cell, _ := excelize.CoordinatesToCellName(i+1, r+2)
+			if err := f.SetCellValue(sheet, cell, row[column.Field]); err != nil {
+				return err
+			}
+		}
+	}
+	return f.SetColWidth(sheet, "A", "Z", 16)
+}
+
+// ExportSettingsHandler 将全部省厅和交通部指标导出为与导入相同格式的 xlsx
+func ExportSettingsHandler(c *gin.Context) {
+	var provinces []dao.ProvinceSetting
+	var nationals []dao.NationalSetting
+	if err := dao.GetDB().Order("year").Find(&provinces).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "数据库查询失败"})
+		return
+	}
+	if err := dao.GetDB().Order("plan").Find(&nationals).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "数据库查询失败"})
+		return
+	}
+
+	provinceRows := make([]map[string]any, len(provinces))
+	for i := range provinces {
+		provinceRows[i] = settingFields(provinces[i])
+	}
+	nationalRows := make([]map[string]any, len(nationals))
+	for i := range nationals {
+		nationalRows[i] = settingFields(nationals[i])
+	}
+
+	f := excelize.NewFile()
+	defer f.Close()
+	err := writeSettingSheet(f, provinceSettingSheet, provinceSettingColumns, provinceRows)
+	if err == nil {
+		err = writeSettingSheet(f, nationalSettingSheet, nationalSettingColumns, nationalRows)
+	}
+	if err == nil {
+		err = f.DeleteSheet("Sheet1")
+	}
+	if err != nil {
+		logger.Logger.Errorf("生成指标配置表失败: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "生成指标配置表失败"})
+		return
+	}
+
+	filename := fmt.Sprintf("指标配置_%s.xlsx", time.Now().Format("20060102"))
+	c.Header("Content-Disposition", fmt.Sprintf("attachment; filename=\"%s\"; filename*=UTF-8''%s", filename, url.QueryEscape(filename)))
+	c.Header("Content-Type", "application/vnd.openxmlformats-officedocument.spreadsheetml.sheet")
+	if err = f.Write(c.Writer); err != nil {
+		logger.Logger.Errorf("写出指标配置表失败: %v", err)
+	}
+}
+
+// readSheetColumns 读取工作表，按表头返回每行的字段取值（空单元格不出现在结果中）及所在行号。工作表不存在时返回 nil
+func readSheetColumns(f *excelize.File, sheet string, columns []sheetColumn) ([]map[string]string, []int, error) {
+	if idx, _ := f.GetSheetIndex(sheet); idx < 0 {
+		return nil, nil, nil
+	}
+	rows, err := f.GetRows(sheet)
+	if err != nil {
+		return nil, nil, err
+	}
+	if len(rows) == 0 {
+		return nil, nil, nil
+	}
+
+	fieldIndex := make(map[string]int)
+	for i, cell := range rows[0] {
+		for _, column := range columns {
+			if normalizeHeader(cell) == normalizeHeader(column.Header) {
+				fieldIndex[column.Field] = i
+			}
+		}
+	}
+	if _, ok := fieldIndex[columns[0].Field]; !ok {
+		return nil, nil, fmt.Errorf("%w: 工作表'%s'缺少'%s'列", errSettingLayout, sheet, columns[0].Header)
+	}
+
+	var values []map[string]string
+	var rowNums []int
+	for r, row := range rows[1:] {
+		value := make(map[string]string)
+		for field, i := range fieldIndex {
+			if i < len(row) && strings.TrimSpace(row[i]) != "" {
+				value[field] = strings.TrimSpace(row[i])
+			}
+		}
+		if len(value) == 0 {
+			continue
+		}
+		values = append(values, value)
+		rowNums = append(rowNums, r+2)
+	}
+	return values, rowNums, nil
+}
+
+// applySettingCells 将表格取值写入配置展开结果，未填写的单元格保留原值
+func applySettingCells(fields map[string]any, cells map[string]string, columns []sheetColumn) error {
+	for _, column := range columns[1:] {
+		cell, ok := cells[column.Field]
+		if !ok {
+			continue
+		}
+		value, err := strconv.ParseFloat(strings.TrimSuffix(cell, "%"), 64)
+		if err != nil {
+			return fmt.Errorf("'%s'列的值'%s'不是数字", column.Header, cell)
+		}
+		fields[column.Field] = value
+	}
+	return nil
+}
+
+// parseSettingsWorkbook 解析导入文件并与数据库现有配置比较，返回每行的比较结果和行级错误
+func parseSettingsWorkbook(f *excelize.File) ([]*settingImportRow, []excelIssue, error) {
+	provinceIdx, _ := f.GetSheetIndex(provinceSettingSheet)
+	nationalIdx, _ := f.GetSheetIndex(nationalSettingSheet)
+	if provinceIdx < 0 && nationalIdx < 0 {
+		return nil, nil, fmt.Errorf("%w: 文件中没有'%s'或'%s'工作表", errSettingLayout, provinceSettingSheet, nationalSettingSheet)
+	}
+	var result []*settingImportRow
+	issues := make([]excelIssue, 0)
+	seen := make(map[string]int)
+	addRow := func(row *settingImportRow, old, current any) {
+		seenKey := row.Kind + "/" + row.Key
+		if first, ok := seen[seenKey]; ok {
+			issues = append(issues, excelIssue{Sheet: row.Sheet, Row: row.Row, Message: fmt.Sprintf("与第 %d 行重复", first)})
+			return
+		}
+		seen[seenKey] = row.Row
+		row.Changes = settingChanges(settingFields(old), settingFields(current))
+		switch {
+		case old == nil:
+			row.Action = settingActionCreate
+		case len(row.Changes) == 0:
+			row.Action = "unchanged"
+		default:
+			row.Action = settingActionUpdate
+		}
+		result = append(result, row)
+	}
+
+	cellsList, rowNums, err := readSheetColumns(f, provinceSettingSheet, provinceSettingColumns)
+	if err != nil {
+		return nil, nil, err
+	}
+	for i, cells := range cellsList {
+		row := &settingImportRow{Kind: settingKindProvince, Key: cells["year"], Sheet: provinceSettingSheet, Row: rowNums[i]}
+		year, err := strconv.Atoi(cells["year"])
+		if err != nil {
+			issues = append(issues, excelIssue{Sheet: row.Sheet, Row: row.Row, Message: fmt.Sprintf("年份'%s'无效", cells["year"])})
+			continue
+		}
+		var existing dao.ProvinceSetting
+		var old any
+		if err = dao.GetDB().Where("year = ?", year).First(&existing).Error; err == nil {
+			old = existing
+		} else if !errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, nil, err
+		}
+		setting := existing
+		fields := settingFields(existing)
+		fields["year"] = year
+		if err = applySettingCells(fields, cells, provinceSettingColumns); err == nil {
+			err = settingFromFields(fields, &setting)
+		}
+		if err == nil {
+			err = validateProvinceSetting(&setting)
+		}
+		if err != nil {
+			issues = append(issues, excelIssue{Sheet: row.Sheet, Row: row.Row, Message: err.Error()})
+			continue
+		}
+		row.province = &setting
+		addRow(row, old, setting)
+	}
+
+	if cellsList, rowNums, err = readSheetColumns(f, nationalSettingSheet, nationalSettingColumns); err != nil {
+		return nil, nil, err
+	}
+	for i, cells := range cellsList {
+		row := &settingImportRow{Kind: settingKindNational, Key: cells["plan"], Sheet: nationalSettingSheet, Row: rowNums[i]}
+		var existing dao.NationalSetting
+		var old any
+		if err = dao.GetDB().Where("plan = ?", row.Key).First(&existing).Error; err == nil {
+			old = existing
+		} else if !errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, nil, err
+		}
+		setting := existing
+		fields := settingFields(existing)
+		fields["plan"] = row.Key
+		if err = applySettingCells(fields, cells, nationalSettingColumns); err == nil {
+			err = settingFromFields(fields, &setting)
+		}
+		if err == nil {
+			err = validateNationalSetting(&setting)
+		}
+		if err != nil {
+			issues = append(issues, excelIssue{Sheet: row.Sheet, Row: row.Row, Message: err.Error()})
+			continue
+		}
+		row.national = &setting
+		addRow(row, old, setting)
+	}
+	return result, issues, nil
+}
+
+// ImportSettingsHandler 从 xlsx 导入指标配置。dryRun=true 时只返回与现有配置的差异；
+// 否则在一个事务中写入全部有变化的行，任一行有错误则不写入。文件中没有的配置保持不变
+func ImportSettingsHandler(c *gin.Context) {
+	dryRun, _ := strconv.ParseBool(c.Query("dryRun"))
+	file, err := c.FormFile("file")
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "请上传指标配置表"})
+		return
+	}
+	src, err := file.Open()
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "读取上传文件失败"})
+		return
+	}
+	defer src.Close()
+	f, err := excelize.OpenReader(src)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "无法解析Excel文件"})
+		return
+	}
+	defer f.Close()
+
+	rows, issues, err := parseSettingsWorkbook(f)
+	if err != nil {
+		if errors.Is(err, errSettingLayout) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		logger.Logger.Errorf("解析指标配置表失败: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "解析指标配置表失败"})
+		return
+	}
+	if rows == nil {
+		rows = []*settingImportRow{}
+	}
+	resp := gin.H{"dryRun": dryRun, "rows": rows, "issues": issues, "valid": len(issues) == 0}
+	if dryRun {
+		c.JSON(http.StatusOK, resp)
+		return
+	}
+	if len(issues) > 0 {
+		resp["error"] = "导入文件有错误，未写入任何配置"
+		c.JSON(http.StatusBadRequest, resp)
+		return
+	}
+
+	operator := settingOperator(c)
+	err = dao.GetDB().Transaction(func(tx *gorm.DB) error {
+		for _, row := range rows {
+			var err error
+			switch {
+			case row.Action == "unchanged":
+			case row.province != nil:
+				_, err = upsertProvinceSetting(tx, row.province, operator, "", 0)
+			case row.national != nil:
+				_, err = upsertNationalSetting(tx, row.national, operator, "", 0)
+			}
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		logger.Logger.Errorf("导入指标配置失败: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "导入指标配置失败"})
+		return
+	}
+	resp["message"] = "指标配置导入成功"
+	c.JSON(http.StatusOK, resp)
+}
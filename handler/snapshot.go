@@ -0,0 +1,119 @@
+package handler
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+	"maps"
+	"net/http"
+	"ningxia_backend/dao"
+	"ningxia_backend/pkg/logger"
+	"os"
+	"slices"
+	"sort"
+	"strings"
+	"time"
+)
+
+// takeSettingsSnapshot 读取年份适用的省厅指标和所在规划期的交通部阶段目标，生成冻结副本
+func takeSettingsSnapshot(year int) (*dao.SettingsSnapshot, error) {
+	snapshot := &dao.SettingsSnapshot{Year: year, TakenAt: time.Now()}
+
+	var province dao.ProvinceSetting
+	err := dao.GetDB().Where("year = ?", year).First(&province).Error
+	if err == nil {
+		snapshot.Province = &province
+	} else if !errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, err
+	}
+
+	if snapshot.Plan, snapshot.National, snapshot.Milestone, err = nationalSettingForYear(year); err != nil {
+		return nil, err
+	}
+	return snapshot, nil
+}
+
+// settingFields 将指标配置按 JSON 字段名展开，用于比较
+func settingFields(setting any) map[string]any {
+	fields := make(map[string]any)
+	if setting == nil {
+		return fields
+	}
+	b, err := json.Marshal(setting)
+	if err != nil || string(b) == "null" {
+		return fields
+	}
+	_ = json.Unmarshal(b, &fields)
+	return fields
+}
+
+// settingChanges 按字段名排序列出两份配置展开结果中取值不同的字段
+func settingChanges(oldFields, currentFields map[string]any) []dao.SettingChange {
+	var changes []dao.SettingChange
+	for _, key := range slices.Sorted(maps.Keys(currentFields)) {
+		if oldValue, ok := oldFields[key]; !ok || oldValue != currentFields[key] {
+			changes = append(changes, dao.SettingChange{Field: key, Old: oldFields[key], New: currentFields[key]})
+		}
+	}
+	for _, key := range slices.Sorted(maps.Keys(oldFields)) {
+		if _, ok := currentFields[key]; !ok {
+			changes = append(changes, dao.SettingChange{Field: key, Old: oldFields[key]})
+		}
+	}
+	return changes
+}
+
+// diffSettings 返回两份配置中取值不同的字段，prefix 用于区分省厅和交通部
+func diffSettings(prefix string, old, current any) []string {
+	var changed []string
+	for _, change := range settingChanges(settingFields(old), settingFields(current)) {
+		changed = append(changed, prefix+"."+change.Field)
+	}
+	sort.Strings(changed)
+	return changed
+}
+
+// GetReportInfoHandler 返回报告的登记信息和生成时的指标配置副本，并标出当前配置与副本不一致的字段
+func GetReportInfoHandler(c *gin.Context) {
+	filename := c.Param("filename")
+	baseName := strings.TrimSuffix(filename, ".md")
+	if _, _, ok := parseReportBaseName(baseName); !ok {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "无效的文件名格式"})
+		return
+	}
+	report, err := loadReportRecord(baseName)
+	if err != nil {
+		if os.IsNotExist(err) {
+			c.JSON(http.StatusNotFound, gin.H{"error": fmt.Sprintf("报告文件 '%s' 未找到", filename)})
+			return
+		}
+		logger.Logger.Errorf("读取报告 %s 登记信息失败: %v", baseName, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "读取报告信息失败"})
+		return
+	}
+
+	resp := gin.H{"report": report}
+	if report.SettingsSnapshot == nil {
+		// 早期生成的报告没有保存配置副本，无法判断
+		resp["snapshotAvailable"] = false
+		c.JSON(http.StatusOK, resp)
+		return
+	}
+
+	current, err := takeSettingsSnapshot(report.SettingsSnapshot.Year)
+	if err != nil {
+		logger.Logger.Errorf("读取当前指标配置失败: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "数据库查询失败"})
+		return
+	}
+	provinceChanges := diffSettings("province", report.SettingsSnapshot.Province, current.Province)
+	nationalChanges := diffSettings("national", report.SettingsSnapshot.National, current.National)
+	resp["snapshotAvailable"] = true
+	resp["currentSettings"] = current
+	resp["provinceChanged"] = len(provinceChanges) > 0
+	resp["nationalChanged"] = len(nationalChanges) > 0
+	resp["changedFields"] = append(append([]string{}, provinceChanges...), nationalChanges...)
+	c.JSON(http.StatusOK, resp)
+}
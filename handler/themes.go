@@ -0,0 +1,221 @@
+package handler
+
+import (
+	"errors"
+	"fmt"
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+	"net/http"
+	"ningxia_backend/dao"
+	"ningxia_backend/pkg/logger"
+	"regexp"
+)
+
+const defaultThemeName = "default"
+
+var (
+	themeColorRegexp = regexp.MustCompile(`^#[0-9a-fA-F]{3}([0-9a-fA-F]{3})?$`)
+	themeFontRegexp  = regexp.MustCompile(`^[\p{Han}\w .\-]+$`)
+)
+
+// builtinTheme 内置默认主题，沿用原导出样式，字体改为随程序分发的方正黑体
+func builtinTheme() *dao.StyleTheme {
+	return &dao.StyleTheme{
+		Name:                  defaultThemeName,
+		FontFamily:            bundledFontFamily,
+		BodyFontSize:          20,
+		LineHeight:            1.5,
+		H1FontSize:            36,
+		H2FontSize:            32,
+		H3FontSize:            28,
+		TableFontSize:         9,
+		TableBorderColor:      "#ddd",
+		TableHeaderBackground: "#f2f2f2",
+		TableCellPadding:      8,
+		MarginTop:             10,
+		MarginRight:           10,
+		MarginBottom:          10,
+		MarginLeft:            10,
+		PaperSize:             "A4",
+		Orientation:           "Portrait",
+	}
+}
+
+func validateTheme(theme *dao.StyleTheme) error {
+	if theme.Name == "" {
+		return errors.New("主题名称不能为空")
+	}
+	if theme.Name == defaultThemeName {
+		return fmt.Errorf("'%s' 为内置主题名称，不能使用", defaultThemeName)
+	}
+	if !themeFontRegexp.MatchString(theme.FontFamily) {
+		return errors.New("字体名称无效")
+	}
+	for name, size := range map[string]float64{
+		"正文字号": theme.BodyFontSize, "一级标题字号": theme.H1FontSize, "二级标题字号": theme.H2FontSize,
+		"三级标题字号": theme.H3FontSize, "表格字号": theme.TableFontSize, "行高": theme.LineHeight,
+	} {
+		if size <= 0 || size > 100 {
+			return fmt.Errorf("%s超出范围", name)
+		}
+	}
+	for name, margin := range map[string]float64{
+		"上边距": theme.MarginTop, "右边距": theme.MarginRight, "下边距": theme.MarginBottom,
+		"左边距": theme.MarginLeft, "单元格内边距": theme.TableCellPadding,
+	} {
+		if margin < 0 || margin > 100 {
+			return fmt.Errorf("%s超出范围", name)
+		}
+	}
+	if !themeColorRegexp.MatchString(theme.TableBorderColor) || !themeColorRegexp.MatchString(theme.TableHeaderBackground) {
+		return errors.New("颜色格式应为 #RGB 或 #RRGGBB")
+	}
+	if theme.PaperSize != "A4" && theme.PaperSize != "A3" {
+		return errors.New("纸张大小只支持 A4 或 A3")
+	}
+	if theme.Orientation != "Portrait" && theme.Orientation != "Landscape" {
+		return errors.New("纸张方向只支持 Portrait 或 Landscape")
+	}
+	return nil
+}
+
+// resolveTheme 按名称获取主题；未指定名称时使用报告类型的默认主题，仍未配置则使用内置主题
+func resolveTheme(name, reportType string) (*dao.StyleTheme, error) {
+	if name == "" && reportType != "" {
+		var reportTheme dao.ReportTheme
+		err := dao.GetDB().Where("report_type = ?", reportType).First(&reportTheme).Error
+		if err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, err
+		}
+		name = reportTheme.ThemeName
+	}
+	if name == "" || name == defaultThemeName {
+		return builtinTheme(), nil
+	}
+
+	var theme dao.StyleTheme
+	if err := dao.GetDB().Where("name = ?", name).First(&theme).Error; err != nil {
+		return nil, err
+	}
+	return &theme, nil
+}
+
+func GetThemes(c *gin.Context) {
+	var themes []dao.StyleTheme
+	if err := dao.GetDB().Order("name").Find(&themes).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "数据库查询失败"})
+		return
+	}
+	c.JSON(http.StatusOK, append([]dao.StyleTheme{*builtinTheme()}, themes...))
+}
+
+func GetTheme(c *gin.Context) {
+	name := c.Param("name")
+	theme, err := resolveTheme(name, "")
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": fmt.Sprintf("未找到主题'%s'", name)})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "数据库查询失败"})
+		return
+	}
+	c.JSON(http.StatusOK, theme)
+}
+
+func SaveTheme(c *gin.Context) {
+	var theme dao.StyleTheme
+	if err := c.ShouldBindJSON(&theme); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if err := validateTheme(&theme); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	var existing dao.StyleTheme
+	err := dao.GetDB().Where("name = ?", theme.Name).First(&existing).Error
+	if err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "数据库查询失败"})
+		return
+	}
+	theme.Model = existing.Model
+	if err = dao.GetDB().Save(&theme).Error; err != nil {
+		logger.Logger.Errorf("保存主题 %s 失败: %v", theme.Name, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "保存主题失败"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "主题保存成功"})
+}
+
+func DeleteTheme(c *gin.Context) {
+	name := c.Param("name")
+	if name == defaultThemeName {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "内置主题不能删除"})
+		return
+	}
+
+	err := dao.GetDB().Transaction(func(tx *gorm.DB) error {
+		result := tx.Unscoped().Where("name = ?", name).Delete(&dao.StyleTheme{})
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			return gorm.ErrRecordNotFound
+		}
+		// 删除后使用该主题的报告类型回落到内置主题
+		return tx.Unscoped().Where("theme_name = ?", name).Delete(&dao.ReportTheme{}).Error
+	})
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": fmt.Sprintf("未找到主题'%s'", name)})
+			return
+		}
+		logger.Logger.Errorf("删除主题 %s 失败: %v", name, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "删除主题失败"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": fmt.Sprintf("主题 %s 删除成功", name)})
+}
+
+func GetReportThemes(c *gin.Context) {
+	var reportThemes []dao.ReportTheme
+	if err := dao.GetDB().Find(&reportThemes).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "数据库查询失败"})
+		return
+	}
+	c.JSON(http.StatusOK, reportThemes)
+}
+
+func SetReportTheme(c *gin.Context) {
+	reportType := c.Param("reportType")
+	if _, ok := ReportNameMap[reportType]; !ok {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "报告类型有误"})
+		return
+	}
+	var req struct {
+		ThemeName string `json:"themeName" binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if _, err := resolveTheme(req.ThemeName, ""); err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": fmt.Sprintf("未找到主题'%s'", req.ThemeName)})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "数据库查询失败"})
+		return
+	}
+
+	reportTheme := dao.ReportTheme{ReportType: reportType, ThemeName: req.ThemeName}
+	err := dao.GetDB().Where(dao.ReportTheme{ReportType: reportType}).Assign(reportTheme).FirstOrCreate(&reportTheme).Error
+	if err != nil {
+		logger.Logger.Errorf("设置 %s 默认主题失败: %v", reportType, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "设置默认主题失败"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "默认主题设置成功"})
+}
@@ -1,88 +1,26 @@
 package handler
 
 import (
-	"archive/zip"
+	"bytes"
+	"cmp"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"golang.org/x/text/encoding/simplifiedchinese"
 	"golang.org/x/text/transform"
 	"io"
-	"log"
+	"maps"
+	"ningxia_backend/dao"
+	"ningxia_backend/pkg/conf"
 	"ningxia_backend/pkg/logger"
 	"os"
-	"path/filepath"
+	"os/exec"
+	"slices"
 	"strconv"
 	"strings"
 	"unicode/utf8"
 )
 
-func unzip(src, dest string) ([]string, error) {
-	var filenames []string
-	r, err := zip.OpenReader(src)
-	if err != nil {
-		logger.Logger.Errorf("打开ZIP文件失败: %v", err)
-		return nil, err
-	}
-	defer r.Close()
-
-	for _, f := range r.File {
-		name := f.Name
-		if f.Flags&0x800 == 0 {
-			decodedName, err := decodeFileName(name)
-			if err != nil {
-				logger.Logger.Errorf("GBK解码失败: %v", err)
-			} else {
-				name = decodedName
-			}
-		}
-
-		fpath := filepath.Join(dest, name)
-		if !strings.HasPrefix(fpath, filepath.Clean(dest)+string(os.PathSeparator)) {
-			errMsg := fmt.Sprintf("非法文件路径: %s", name)
-			log.Println(errMsg)
-			return nil, fmt.Errorf(errMsg)
-		}
-
-		if f.FileInfo().IsDir() {
-			if err = os.MkdirAll(fpath, 0755); err != nil {
-				logger.Logger.Errorf("创建目录失败: %v", err)
-				return nil, err
-			}
-			continue
-		}
-
-		if err := os.MkdirAll(filepath.Dir(fpath), 0755); err != nil {
-			logger.Logger.Errorf("创建父目录失败: %v", err)
-			return nil, err
-		}
-
-		outFile, err := os.OpenFile(fpath, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, f.Mode())
-		if err != nil {
-			logger.Logger.Errorf("创建文件失败: %v", err)
-			return nil, err
-		}
-
-		rc, err := f.Open()
-		if err != nil {
-			logger.Logger.Errorf("打开ZIP条目失败: %v", err)
-			outFile.Close()
-			return nil, err
-		}
-
-		_, err = io.Copy(outFile, rc)
-		outFile.Close()
-		rc.Close()
-		if err != nil {
-			logger.Logger.Errorf("文件写入失败: %v", err)
-			return nil, err
-		}
-
-		filenames = append(filenames, fpath)
-	}
-	return filenames, nil
-}
-
 func decodeFileName(name string) (string, error) {
 	// 先尝试UTF-8
 	if utf8.ValidString(name) {
@@ -115,6 +53,8 @@ func decodeGBK(s string) (string, error) {
 	return string(buf), nil
 }
 
+// calculate 取得计算结果。配置 calc.execute 开启时调用计算程序并解析其标准输出，
+// 否则读取报告目录中已有的 result.json
 func calculate(pySuffix, reportType string, files []string, pqi, mileage float64) (map[string]any, error) {
 	var program string
 	var jsonResultFile string
@@ -138,38 +78,74 @@ func calculate(pySuffix, reportType string, files []string, pqi, mileage float64
 		return nil, errors.New("不支持的报告类型")
 	}
 
-	logger.Logger.Infof("python exe: %s", program)
-	//args := []string{
-	//	"-files", strings.Join(files, " "),
-	//	"-pqi", fmt.Sprintf("%.2f", pqi),
-	//	"-d", fmt.Sprintf("%.2f", mileage),
-	//}
-
-	//cmd := exec.Command(program, args...)
-	//logger.Logger.Infof("execute program: %v", cmd)
-	//output, err := cmd.CombinedOutput()
-	//if err != nil {
-	//	logger.Logger.Errorf("Python执行失败 [%d]: %s\n输出: %s", cmd.ProcessState.ExitCode(), err, output)
-	//	return nil, err
-	//}
 	var data map[string]any
-	//if err = json.Unmarshal(output, &data); err != nil {
-	//	logger.Logger.Errorf("解析结果失败: %v\n原始输出: %s", err, output)
-	//	return nil, err
-	//}
+	if !conf.Conf.GetBool("calc.execute") {
+		js, err := os.ReadFile(jsonResultFile)
+		if err != nil {
+			logger.Logger.Errorf("读取 %s 失败: %v", jsonResultFile, err)
+			return nil, err
+		}
+		if err = json.Unmarshal(js, &data); err != nil {
+			logger.Logger.Errorf("解析结果失败: %v", err)
+			return nil, err
+		}
+		return data, nil
+	}
 
-	js, err := os.ReadFile(jsonResultFile)
+	logger.Logger.Infof("python exe: %s", program)
+	args := []string{
+		"-files", strings.Join(files, " "),
+		"-pqi", fmt.Sprintf("%.2f", pqi),
+		"-d", fmt.Sprintf("%.2f", mileage),
+	}
+	cmd := exec.Command(program, args...)
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	logger.Logger.Infof("execute program: %v", cmd)
+	output, err := cmd.Output()
 	if err != nil {
-		logger.Logger.Errorf("读取 %s 失败: %v", jsonResultFile, err)
+		logger.Logger.Errorf("Python执行失败 [%d]: %s\n输出: %s", cmd.ProcessState.ExitCode(), err, stderr.Bytes())
 		return nil, err
 	}
-	if err = json.Unmarshal(js, &data); err != nil {
-		logger.Logger.Errorf("解析结果失败: %v", err)
+	if stderr.Len() > 0 {
+		logger.Logger.Warnf("计算程序 %s 输出: %s", program, stderr.Bytes())
+	}
+	if err = json.Unmarshal(output, &data); err != nil {
+		logger.Logger.Errorf("解析结果失败: %v\n原始输出: %s", err, output)
 		return nil, err
 	}
 	return data, nil
 }
 
+// templateValues 汇总模板占位符的取值：计算结果（图片列表除外）、指标达标情况、路网里程和批次病害的有效修复率
+func templateValues(reportType string, data map[string]any, settings *dao.SettingsSnapshot, campaignID *uint) map[string]string {
+	values := make(map[string]string, len(data))
+	for key, value := range data {
+		if key != PyRespImagesKey && key != PyRespExtraImagesKey {
+			values[key] = fmt.Sprintf("%v", value)
+		}
+	}
+	maps.Copy(values, compliancePlaceholders(evaluateCompliance(reportType, data, settings)))
+	maps.Copy(values, networkPlaceholders())
+	maps.Copy(values, repairPlaceholders(reportType, campaignID))
+	return values
+}
+
+// fillPlaceholders 替换模板占位符，较长的先替换，避免 PQI 替换掉 PQI2 的一部分
+func fillPlaceholders(content string, values map[string]string) string {
+	keys := slices.SortedFunc(maps.Keys(values), func(a, b string) int {
+		return cmp.Or(cmp.Compare(len(b), len(a)), cmp.Compare(a, b))
+	})
+	for _, key := range keys {
+		value := values[key]
+		if value == "" {
+			value = " "
+		}
+		content = strings.ReplaceAll(content, key, value)
+	}
+	return content
+}
+
 func extractTimestamp(filename string) int64 {
 	lastUnderscore := strings.LastIndex(filename, "_")
 	lastDot := strings.LastIndex(filename, ".")
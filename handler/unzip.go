@@ -4,13 +4,22 @@ import (
 	"errors"
 	"fmt"
 	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
 	"net/http"
+	"ningxia_backend/dao"
 	"ningxia_backend/pkg/logger"
 	"os"
 	"path/filepath"
+	"slices"
 	"strings"
 )
 
+// 表单中未附带文件时，可以用 <字段名>Sha256 引用此前上传过的相同文件，<字段名>Name 指定文件名
+const (
+	formSHA256Suffix = "Sha256"
+	formNameSuffix   = "Name"
+)
+
 func UnzipHandler() func(c *gin.Context) {
 	return func(c *gin.Context) {
 		c.Request.Body = http.MaxBytesReader(c.Writer, c.Request.Body, maxFileSize)
@@ -21,80 +30,122 @@ func UnzipHandler() func(c *gin.Context) {
 			c.JSON(http.StatusInternalServerError, gin.H{"error": "创建临时目录失败"})
 			return
 		}
-		var allProcessedFilePaths []string
-
-		// 前端可能发送的ZIP和Excel文件的表单字段名
-		zipFieldNames := []string{"threeDimensionalDataZip", "cicsDataZip", "previousYearDiseaseZip"}
-		excelFieldNames := []string{"managementDetailFile", "unitLevelDetailFile", "roadConditionFile", "firstInspectionExcel", "secondInspectionExcel", "diseaseDataExcel"}
+		dataset, err := newDataset(requestTempDir)
+		if err != nil {
+			logger.Logger.Errorf("创建数据集失败: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "创建数据集失败"})
+			return
+		}
+		// 可选的 campaignId 表单字段，将数据集归入抽检批次
+		if dataset.CampaignID, err = parseCampaignRef(c.PostForm("campaignId")); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
 
-		// 逐个处理ZIP文件
-		for _, fieldName := range zipFieldNames {
-			file, err := c.FormFile(fieldName)
+		// 逐个处理压缩包和Excel文件，相同内容只保存一份，校验结果随响应返回，不阻止上传
+		rejected := make(map[string][]rejectedEntry)
+		validations := make([]*excelValidation, 0)
+		duplicates := make([]gin.H, 0)
+		for _, fieldName := range slices.Concat(zipFieldNames, excelFieldNames) {
+			isArchive := slices.Contains(zipFieldNames, fieldName)
+			blob, fileName, existed, status, err := receiveFormFile(c, requestTempDir, fieldName, isArchive)
 			if err != nil {
-				if errors.Is(err, http.ErrMissingFile) {
-					continue
-				}
-				logger.Logger.Errorf("获取ZIP文件 '%s' 失败: %v", fieldName, err)
-				c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("处理ZIP文件 '%s' 失败: %v", fieldName, err)})
-				return
-			}
-
-			// 将上传的ZIP文件保存到请求临时目录中
-			tempZipPath := filepath.Join(requestTempDir, file.Filename)
-			if err = c.SaveUploadedFile(file, tempZipPath); err != nil {
-				logger.Logger.Errorf("保存上传的ZIP文件 '%s' 到 '%s' 失败: %v", file.Filename, tempZipPath, err)
-				c.JSON(http.StatusInternalServerError, gin.H{"error": fmt.Sprintf("保存文件 '%s' 失败", file.Filename)})
+				c.JSON(status, gin.H{"error": err.Error()})
 				return
 			}
-
-			// 为解压后的文件创建一个子目录
-			// 例如：data.zip -> data_extracted/
-			baseName := strings.TrimSuffix(file.Filename, filepath.Ext(file.Filename))
-			extractDir := filepath.Join(requestTempDir, baseName+"_extracted")
-			if err = os.MkdirAll(extractDir, 0755); err != nil {
-				logger.Logger.Errorf("为ZIP '%s' 创建解压目录 '%s' 失败: %v", file.Filename, extractDir, err)
-				c.JSON(http.StatusInternalServerError, gin.H{"error": fmt.Sprintf("为文件 '%s' 准备解压环境失败", file.Filename)})
-				return
+			if blob == nil {
+				// 文件是可选的，如果不存在则跳过
+				logger.Logger.Infof("可选的文件 '%s' 未上传", fieldName)
+				continue
 			}
 
-			// 解压ZIP文件
-			unzippedFiles, err := unzip(tempZipPath, extractDir)
+			paths, err := linkBlobIntoDataset(dataset, fieldName, fileName, blob)
 			if err != nil {
-				logger.Logger.Errorf("解压ZIP文件 '%s' 失败: %v", file.Filename, err)
-				c.JSON(http.StatusInternalServerError, gin.H{"error": fmt.Sprintf("解压文件 '%s' 失败", file.Filename)})
+				logger.Logger.Errorf("登记文件 '%s' 失败: %v", fileName, err)
+				c.JSON(http.StatusInternalServerError, gin.H{"error": fmt.Sprintf("登记文件 '%s' 失败", fileName)})
 				return
 			}
-			allProcessedFilePaths = append(allProcessedFilePaths, unzippedFiles...)
-
-			// 解压后删除临时的ZIP文件
-			if err = os.Remove(tempZipPath); err != nil {
-				logger.Logger.Errorf("删除临时ZIP文件 '%s' 失败: %v", tempZipPath, err)
+			if existed {
+				duplicate := blobDuplicate(blob)
+				duplicate["fieldName"] = fieldName
+				duplicates = append(duplicates, duplicate)
+			}
+			if len(blob.Rejected) > 0 {
+				rejected[fieldName] = blob.Rejected
+			}
+			if !isArchive {
+				validations = append(validations, validateExcelFile(fieldName, paths[0]))
 			}
 		}
 
-		// 逐个处理Excel文件
-		for _, fieldName := range excelFieldNames {
-			file, err := c.FormFile(fieldName)
-			if err != nil {
-				if errors.Is(err, http.ErrMissingFile) {
-					// 文件是可选的，如果不存在则跳过
-					logger.Logger.Infof("可选的Excel文件 '%s' 未上传", fieldName)
-					continue
-				}
-				logger.Logger.Errorf("获取Excel文件 '%s' 失败: %v", fieldName, err)
-				c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("处理Excel文件 '%s' 失败: %v", fieldName, err)})
-				return
-			}
+		if err = dao.GetDB().Create(dataset).Error; err != nil {
+			logger.Logger.Errorf("保存数据集失败: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "保存数据集失败"})
+			return
+		}
+		retainBlobs(dataset.Files)
+		importDatasetDiseases(dataset)
+		c.JSON(http.StatusOK, gin.H{
+			"datasetId":  dataset.ID,
+			"files":      dataset.Files,
+			"validation": validations,
+			"rejected":   rejected,
+			"duplicates": duplicates,
+		})
+	}
+}
 
-			// 将上传的Excel文件直接保存到请求临时目录中
-			destExcelPath := filepath.Join(requestTempDir, file.Filename)
-			if err = c.SaveUploadedFile(file, destExcelPath); err != nil {
-				logger.Logger.Errorf("保存上传的Excel文件 '%s' 到 '%s' 失败: %v", file.Filename, destExcelPath, err)
-				c.JSON(http.StatusInternalServerError, gin.H{"error": fmt.Sprintf("保存文件 '%s' 失败", file.Filename)})
-				return
+// receiveFormFile 接收表单字段中的文件并存入内容寻址存储，或按 <字段名>Sha256 引用已上传的文件。
+// 字段未提供时 blob 为 nil；出错时返回应答状态码
+func receiveFormFile(c *gin.Context, requestTempDir, fieldName string, isArchive bool) (blob *dao.Blob, fileName string, existed bool, status int, err error) {
+	file, err := c.FormFile(fieldName)
+	if err != nil {
+		if !errors.Is(err, http.ErrMissingFile) {
+			logger.Logger.Errorf("获取文件 '%s' 失败: %v", fieldName, err)
+			return nil, "", false, http.StatusBadRequest, fmt.Errorf("处理文件 '%s' 失败: %v", fieldName, err)
+		}
+		sha := strings.ToLower(c.PostForm(fieldName + formSHA256Suffix))
+		if sha == "" {
+			return nil, "", false, 0, nil
+		}
+		if blob, err = touchBlob(sha); err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				return nil, "", false, http.StatusBadRequest, fmt.Errorf("字段 '%s' 引用的文件未上传过，请重新发送", fieldName)
 			}
-			allProcessedFilePaths = append(allProcessedFilePaths, destExcelPath)
+			return nil, "", false, http.StatusInternalServerError, fmt.Errorf("数据库查询失败")
+		}
+		fileName = blob.FileName
+		if name := c.PostForm(fieldName + formNameSuffix); name != "" && filepath.Base(filepath.Clean(name)) == name {
+			fileName = name
 		}
-		c.JSON(http.StatusOK, gin.H{"files": allProcessedFilePaths})
+		return blob, fileName, true, 0, nil
+	}
+
+	// 将上传的文件保存到请求临时目录中，计算摘要后移入内容寻址存储
+	tempPath := filepath.Join(requestTempDir, file.Filename)
+	if err = c.SaveUploadedFile(file, tempPath); err != nil {
+		logger.Logger.Errorf("保存上传的文件 '%s' 到 '%s' 失败: %v", file.Filename, tempPath, err)
+		return nil, "", false, http.StatusInternalServerError, fmt.Errorf("保存文件 '%s' 失败", file.Filename)
+	}
+	sha, err := fileSHA256(tempPath)
+	if err != nil {
+		logger.Logger.Errorf("计算文件 %s 摘要失败: %v", tempPath, err)
+		return nil, "", false, http.StatusInternalServerError, fmt.Errorf("保存文件 '%s' 失败", file.Filename)
+	}
+	if blob, existed, err = ingestBlob(tempPath, file.Filename, sha, isArchive); err != nil {
+		logger.Logger.Errorf("文件 '%s' 入库失败: %v", file.Filename, err)
+		if status = extractErrorStatus(err); status == http.StatusInternalServerError {
+			err = fmt.Errorf("保存文件 '%s' 失败", file.Filename)
+		}
+		return nil, "", false, status, err
+	}
+	return blob, file.Filename, existed, 0, nil
+}
+
+// extractErrorStatus 超出解压限制或格式不支持属于客户端问题，其余解压失败按服务器错误处理
+func extractErrorStatus(err error) int {
+	if errors.Is(err, errArchiveLimit) || errors.Is(err, errUnsupportedArchive) {
+		return http.StatusBadRequest
 	}
+	return http.StatusInternalServerError
 }
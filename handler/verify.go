@@ -0,0 +1,119 @@
+package handler
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"github.com/gin-gonic/gin"
+	"github.com/pdfcpu/pdfcpu/pkg/api"
+	"github.com/pdfcpu/pdfcpu/pkg/pdfcpu/model"
+	"github.com/pdfcpu/pdfcpu/pkg/pdfcpu/types"
+	"github.com/skip2/go-qrcode"
+	"gorm.io/gorm"
+	"net/http"
+	"net/url"
+	"ningxia_backend/dao"
+	"os"
+	"strconv"
+)
+
+const (
+	QRPositionCover  = "cover"  // 仅封面右下角
+	QRPositionFooter = "footer" // 每页页脚居中
+	QRPositionNone   = "none"
+)
+
+// verifyURL 生成报告核验地址，包含报告ID和内容摘要。二维码印在纸面上，
+// 只能使用配置的对外地址，未配置 server.publicBaseUrl 时不加盖二维码
+func verifyURL(report *dao.Report) string {
+	return fmt.Sprintf("%s/api/verify/%d?hash=%s", publicBaseURL(), report.ID, url.QueryEscape(report.ContentHash))
+}
+
+// stampVerificationQR 在封面或页脚加盖核验二维码
+func stampVerificationQR(pdf []byte, report *dao.Report, position string) ([]byte, error) {
+	png, err := qrcode.Encode(verifyURL(report), qrcode.Medium, 256)
+	if err != nil {
+		return nil, fmt.Errorf("生成二维码失败: %w", err)
+	}
+
+	var desc string
+	var selectedPages []string
+	switch position {
+	case QRPositionCover:
+		desc = "pos:br, off:-30 30, scale:0.12 rel, rot:0, op:1"
+		selectedPages = []string{"1"}
+	case QRPositionFooter:
+		desc = "pos:bc, off:0 10, scale:0.06 rel, rot:0, op:1"
+	default:
+		return nil, fmt.Errorf("不支持的二维码位置: %s", position)
+	}
+
+	cnf := model.NewDefaultConfiguration()
+	cnf.Unit = types.POINTS
+	wm, err := api.ImageWatermarkForReader(bytes.NewReader(png), desc, true, false, cnf.Unit)
+	if err != nil {
+		return nil, fmt.Errorf("创建二维码图章失败: %w", err)
+	}
+
+	var out bytes.Buffer
+	if err = api.AddWatermarks(bytes.NewReader(pdf), &out, selectedPages, wm, cnf); err != nil {
+		return nil, fmt.Errorf("加盖二维码失败: %w", err)
+	}
+	return out.Bytes(), nil
+}
+
+// VerifyReportHandler 公开的报告核验接口，供扫描二维码后确认报告真伪及是否仍然有效
+func VerifyReportHandler(c *gin.Context) {
+	id, err := strconv.ParseUint(c.Param("id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "无效的报告编号"})
+		return
+	}
+	hash := c.Query("hash")
+
+	var report dao.Report
+	if err = dao.GetDB().First(&report, id).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "未找到该报告，报告可能并非本系统出具"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "数据库查询失败"})
+		return
+	}
+
+	// 报告文件仍在时以文件的当前内容为准，已删除时以登记的摘要为准
+	currentHash := report.ContentHash
+	if content, err := os.ReadFile(reportMdPath(report.Filename)); err == nil {
+		currentHash = contentHash(content)
+	}
+	hashMatch := hash != "" && hash == currentHash
+	withdrawn := report.Status == ReportStatusWithdrawn
+	revised := report.RevisedBy != ""
+
+	var message string
+	switch {
+	case !hashMatch:
+		message = "报告内容与登记不一致，该件可能被篡改或并非最新出具版本"
+	case withdrawn:
+		message = "报告内容一致，但该报告已被撤回"
+	case revised:
+		message = "报告内容一致，但该报告已被新版本修订"
+	default:
+		message = "报告真实有效"
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"id":         report.ID,
+		"filename":   report.Filename,
+		"reportName": ReportNameMap[report.ReportType],
+		"year":       report.Year,
+		"unit":       report.Unit,
+		"issuedAt":   report.CreatedAt,
+		"hashMatch":  hashMatch,
+		"withdrawn":  withdrawn,
+		"revised":    revised,
+		"revisedBy":  report.RevisedBy,
+		"valid":      hashMatch && !withdrawn && !revised,
+		"message":    message,
+	})
+}
@@ -0,0 +1,215 @@
+package handler
+
+import (
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+	"mime"
+	"net"
+	"net/http"
+	"net/url"
+	"ningxia_backend/pkg/conf"
+	"ningxia_backend/pkg/logger"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+// 报告中的图片链接，生成时写入站点根相对地址 /file?name=...，旧报告中是 http://127.0.0.1:12345/file?name=...
+var reportImageLinkRegexp = regexp.MustCompile(`(?:https?://[^/"'\s)]+)?/file\?name=([^"'\s)&]+)`)
+
+// rewriteImageLinks 使用 replace 的返回值替换报告中的每个图片链接，replace 接收解码后的图片相对路径
+func rewriteImageLinks(content string, replace func(name string) (string, error)) (string, error) {
+	var firstErr error
+	result := reportImageLinkRegexp.ReplaceAllStringFunc(content, func(link string) string {
+		escapedName := reportImageLinkRegexp.FindStringSubmatch(link)[1]
+		name, err := url.QueryUnescape(escapedName)
+		if err == nil {
+			var newLink string
+			if newLink, err = replace(name); err == nil {
+				return newLink
+			}
+		}
+		if firstErr == nil {
+			firstErr = err
+		}
+		return link
+	})
+	return result, firstErr
+}
+
+// reportImageLink 报告图片的站点根相对地址，name 为报告目录下的相对路径
+func reportImageLink(name string) string {
+	return "/file?name=" + url.QueryEscape(name)
+}
+
+// resolveImageLinks 解析报告中的图片链接：baseURL 非空时改为该地址下的绝对链接，
+// 为空时内嵌为 data URI，供离线 HTML 和 PDF 转换使用
+func resolveImageLinks(content, baseURL string) (string, error) {
+	return rewriteImageLinks(content, func(name string) (string, error) {
+		if baseURL != "" {
+			return baseURL + reportImageLink(name), nil
+		}
+		imagePath, err := safeReportPath(name)
+		if err != nil {
+			return "", err
+		}
+		return dataURI(imagePath)
+	})
+}
+
+// safeReportPath 拼接报告目录下的相对路径，拒绝跳出报告目录
+func safeReportPath(name string) (string, error) {
+	absReportsBaseDir, err := filepath.Abs(reportsBaseDir)
+	if err != nil {
+		return "", err
+	}
+	absPath, err := filepath.Abs(filepath.Join(reportsBaseDir, name))
+	if err != nil {
+		return "", err
+	}
+	if !strings.HasPrefix(absPath, absReportsBaseDir+string(os.PathSeparator)) {
+		return "", fmt.Errorf("非法文件路径: %s", name)
+	}
+	return absPath, nil
+}
+
+// dataURI 将文件内容编码为 data URI，用于离线 HTML 内嵌资源
+func dataURI(path string) (string, error) {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return "", err
+	}
+	mimeType := mime.TypeByExtension(strings.ToLower(filepath.Ext(path)))
+	if mimeType == "" {
+		mimeType = http.DetectContentType(content)
+	}
+	return fmt.Sprintf("data:%s;base64,%s", mimeType, base64.StdEncoding.EncodeToString(content)), nil
+}
+
+// trustedProxy 直接连接方是否为配置 server.trustedProxies 中的反向代理（IP 或 CIDR）
+func trustedProxy(c *gin.Context) bool {
+	ip := net.ParseIP(c.RemoteIP())
+	if ip == nil {
+		return false
+	}
+	for _, entry := range conf.Conf.GetStringSlice("server.trustedProxies") {
+		if _, network, err := net.ParseCIDR(entry); err == nil {
+			if network.Contains(ip) {
+				return true
+			}
+		} else if proxy := net.ParseIP(entry); proxy != nil && proxy.Equal(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// publicBaseURL 配置的对外访问地址 server.publicBaseUrl，未配置时为空
+func publicBaseURL() string {
+	return strings.TrimRight(conf.Conf.GetString("server.publicBaseUrl"), "/")
+}
+
+// requestBaseURL 对外访问地址：优先使用配置 server.publicBaseUrl；否则按请求推断，
+// 只有来自可信反向代理的请求才采用 X-Forwarded-Proto / X-Forwarded-Host
+func requestBaseURL(c *gin.Context) string {
+	if base := publicBaseURL(); base != "" {
+		return base
+	}
+	scheme := "http"
+	if c.Request.TLS != nil {
+		scheme = "https"
+	}
+	host := c.Request.Host
+	if trustedProxy(c) {
+		if proto := strings.ToLower(c.GetHeader("X-Forwarded-Proto")); proto == "http" || proto == "https" {
+			scheme = proto
+		}
+		if forwardedHost := c.GetHeader("X-Forwarded-Host"); forwardedHost != "" && !strings.ContainsAny(forwardedHost, "/\\@ ") {
+			host = forwardedHost
+		}
+	}
+	return scheme + "://" + host
+}
+
+// ViewHTMLHandler 按样式主题将报告渲染为 HTML；offline=true 时内嵌图片和字体，生成可离线查看的单文件
+func ViewHTMLHandler(c *gin.Context) {
+	filename := c.Param("filename")
+	lastDotIndex := strings.LastIndex(filename, ".")
+	if lastDotIndex == -1 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "无效的文件名格式"})
+		return
+	}
+	baseName := filename[:lastDotIndex]
+	offline := c.Query("offline") == "true"
+
+	mdPath, err := safeReportPath(filepath.Join(baseName, baseName+".md"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "无效的文件名"})
+		return
+	}
+	mdContent, err := os.ReadFile(mdPath)
+	if err != nil {
+		if os.IsNotExist(err) {
+			c.JSON(http.StatusNotFound, gin.H{"error": fmt.Sprintf("报告文件 '%s' 未找到", filename)})
+			return
+		}
+		logger.Logger.Errorf("读取报告 %s 内容失败: %v", mdPath, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "读取报告内容失败"})
+		return
+	}
+
+	reportType, _, _ := parseReportBaseName(baseName)
+	theme, err := resolveTheme(c.Query("theme"), reportType)
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": fmt.Sprintf("未找到主题'%s'", c.Query("theme"))})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "获取样式主题失败"})
+		return
+	}
+
+	baseURL := requestBaseURL(c)
+	fontURL := baseURL + "/api/reports/font"
+	if offline && theme.FontFamily == bundledFontFamily {
+		if fontURL, err = dataURI(bundledFontFile); err != nil {
+			logger.Logger.Errorf("读取内置字体失败: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "读取内置字体失败"})
+			return
+		}
+	}
+
+	html, err := buildReportHTML(mdContent, theme, fontURL)
+	if err != nil {
+		logger.Logger.Errorf("渲染报告 %s 失败: %v", filename, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "渲染报告失败"})
+		return
+	}
+
+	imageBaseURL := baseURL
+	if offline {
+		imageBaseURL = ""
+	}
+	html, err = resolveImageLinks(html, imageBaseURL)
+	if err != nil {
+		// 个别图片缺失时保留原链接，不影响整份报告查看
+		logger.Logger.Warnf("报告 %s 图片链接处理失败: %v", filename, err)
+	}
+
+	page := "<!DOCTYPE html>\n<html lang=\"zh-CN\">\n" + html + "\n</html>\n"
+	if offline {
+		encodedFilename := url.QueryEscape(baseName + ".html")
+		c.Header("Content-Disposition", fmt.Sprintf("attachment; filename*=utf-8''%s", encodedFilename))
+	}
+	c.Data(http.StatusOK, "text/html; charset=utf-8", []byte(page))
+}
+
+// GetBundledFontHandler 提供内置字体，供在线 HTML 报告使用
+func GetBundledFontHandler(c *gin.Context) {
+	c.Header("Cache-Control", "public, max-age=86400")
+	c.File(bundledFontFile)
+}
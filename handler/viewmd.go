@@ -18,5 +18,10 @@ func ViewMarkdownHandler(c *gin.Context) {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "读取报告内容失败"})
 		return
 	}
-	c.Data(http.StatusOK, "text/markdown; charset=utf-8", content)
+	// 图片链接按对外地址解析为绝对链接，下载后的文件也能显示图片
+	resolved, err := resolveImageLinks(string(content), requestBaseURL(c))
+	if err != nil {
+		logger.Logger.Warnf("报告 %s 图片链接处理失败: %v", filename, err)
+	}
+	c.Data(http.StatusOK, "text/markdown; charset=utf-8", []byte(resolved))
 }
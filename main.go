@@ -71,17 +71,42 @@ func main() {
 		}
 	}
 
+	// 后台清理过期的上传目录和临时文件
+	handler.StartJanitor()
+
 	r := gin.Default()
 	config := cors.DefaultConfig()
 	config.AllowOrigins = []string{"*"}
 	config.AllowMethods = []string{"GET", "POST", "PUT", "DELETE", "OPTIONS"}
-	config.AllowHeaders = []string{"Origin", "Content-Type", "Authorization"}
+	config.AllowHeaders = []string{"Origin", "Content-Type", "Authorization", "X-Chunk-SHA256", "X-Operator"}
+	config.ExposeHeaders = []string{"X-Skipped-Attachments"}
 	r.Use(cors.New(config))
 
 	pySuffix := conf.Conf.GetString("pySuffix")
 	// 解压接口
 	r.POST("/api/unzip", handler.UnzipHandler())
 
+	// 分片上传接口：初始化、上传分片、查询进度、完成、取消
+	upload := r.Group("/api/uploads")
+	{
+		upload.POST("", handler.InitUploadHandler)
+		upload.PUT("/:id", handler.UploadChunkHandler)
+		upload.GET("/:id", handler.GetUploadProgressHandler)
+		upload.POST("/:id/complete", handler.CompleteUploadHandler)
+		upload.DELETE("/:id", handler.AbortUploadHandler)
+	}
+
+	// 按 SHA-256 查询文件是否已上传过，已上传的文件无需再次发送
+	r.GET("/api/blobs/:sha256", handler.GetBlobHandler)
+
+	dataset := r.Group("/api/datasets")
+	{
+		dataset.GET("/:id", handler.GetDatasetHandler)
+		dataset.GET("/:id/tree", handler.GetDatasetTreeHandler)
+		dataset.GET("/:id/files/:fileId/preview", handler.PreviewDatasetFileHandler)
+		dataset.GET("/:id/quality", handler.GetDatasetQualityHandler) // 与路网登记核对
+	}
+
 	// 计算接口
 	//r.POST("/api/calculate/docx", handler.SaveDocxHandler(pySuffix))
 	r.POST("/api/calculate/md", handler.SaveMdHandler(pySuffix))
@@ -90,13 +115,29 @@ func main() {
 	{
 		report.GET("list", handler.GetReports)
 		report.GET("/view/:filename", handler.ViewMarkdownHandler) //查看md
+		report.GET("/html/:filename", handler.ViewHTMLHandler)     //查看html，offline=true 下载离线单文件
+		report.GET("/font", handler.GetBundledFontHandler)         //html报告使用的内置字体
 		//report.GET("/download/:filename", handler.DownloadWordHandler)   //下载docx
 		report.GET("/export/:filename", handler.ExportReportHandler)     //下载pdf
 		report.DELETE("/:filename", handler.DeleteReportHandler)         // 删除报告
 		report.GET("/extraExport/:filename", handler.ExtraExportHandler) // 特殊导出：年度指标达标情况
+		report.GET("/info/:filename", handler.GetReportInfoHandler)      // 报告登记信息及生成时的指标配置
+		// 按报告的计算结果和生成时的指标配置评估达标情况
+		report.GET("/compliance/:filename", handler.GetReportComplianceHandler)
+		// PQI 分级地图，format=geojson|kml
+		report.GET("/map/:filename", handler.ExportReportMapHandler)
+	}
+
+	r.POST("/api/compliance", handler.EvaluateComplianceHandler) // 按当前指标配置评估任意计算结果
+
+	storage := r.Group("/api/storage")
+	{
+		storage.GET("/usage", handler.GetStorageUsageHandler) // 磁盘占用
+		storage.POST("/cleanup", handler.CleanupHandler)      // 立即清理
 	}
 
 	r.GET("/file", handler.GetFileHandler)
+	r.GET("/api/verify/:id", handler.VerifyReportHandler) // 报告核验（公开）
 
 	setting := r.Group("/api/settings")
 	{
@@ -104,11 +145,82 @@ func main() {
 		setting.POST("/national", handler.SaveNationalSettings)
 		setting.GET("/province/:year", handler.GetProvinceSetting)
 		setting.GET("/national/:plan", handler.GetNationalSetting)
+		setting.GET("/province", handler.GetProvinceSettings)
+		setting.GET("/national", handler.GetNationalSettings)
+		setting.DELETE("/province/:year", handler.DeleteProvinceSetting)
+		setting.DELETE("/national/:plan", handler.DeleteNationalSetting)
+		// 修改历史：查看全部记录、查看某一版本、恢复到某一版本
+		setting.GET("/province/:year/history", handler.GetProvinceSettingHistory)
+		setting.GET("/national/:plan/history", handler.GetNationalSettingHistory)
+		setting.GET("/province/:year/history/:version", handler.GetProvinceSettingVersion)
+		setting.GET("/national/:plan/history/:version", handler.GetNationalSettingVersion)
+		setting.POST("/province/:year/history/:version/restore", handler.RestoreProvinceSetting)
+		setting.POST("/national/:plan/history/:version/restore", handler.RestoreNationalSetting)
+		setting.GET("/effective/:year", handler.GetEffectiveSettings) // 报告年份适用的省厅指标和交通部阶段目标
+		setting.GET("/export", handler.ExportSettingsHandler)         // 导出全部指标为 xlsx
+		setting.POST("/import", handler.ImportSettingsHandler)        // 从 xlsx 导入，dryRun=true 时只返回差异
+	}
+
+	plan := r.Group("/api/plans")
+	{
+		plan.GET("", handler.GetPlanningPeriods)
+		plan.GET("/:name", handler.GetPlanningPeriod)
+		plan.POST("", handler.SavePlanningPeriod)
+		plan.DELETE("/:name", handler.DeletePlanningPeriod)
+	}
+
+	theme := r.Group("/api/themes")
+	{
+		theme.GET("", handler.GetThemes)
+		theme.GET("/:name", handler.GetTheme)
+		theme.POST("", handler.SaveTheme)
+		theme.DELETE("/:name", handler.DeleteTheme)
+		theme.GET("/defaults", handler.GetReportThemes)
+		theme.PUT("/defaults/:reportType", handler.SetReportTheme)
+	}
+
+	campaign := r.Group("/api/campaigns")
+	{
+		campaign.GET("", handler.GetCampaigns)
+		campaign.GET("/:id", handler.GetCampaign) // 批次视图：数据集、指标配置、报告
+		campaign.POST("", handler.CreateCampaign)
+		campaign.PUT("/:id", handler.UpdateCampaign)
+		campaign.DELETE("/:id", handler.DeleteCampaign)
+		campaign.PUT("/:id/datasets/:datasetId", handler.AttachDatasetToCampaign)
+		campaign.PUT("/:id/reports/:filename", handler.AttachReportToCampaign)
+		campaign.GET("/:id/map", handler.ExportCampaignMapHandler) // PQI 分级地图，format=geojson|kml
+		// 重新导入批次数据集中的病害记录
+		campaign.POST("/:id/diseases/import", handler.ImportCampaignDiseasesHandler)
+		// 与实施前病害比对，返回有效修复率和明细，format=xlsx 时导出比对表
+		campaign.GET("/:id/diseases/comparison", handler.GetCampaignDiseaseComparison)
+	}
+
+	disease := r.Group("/api/diseases")
+	{
+		disease.GET("", handler.GetDiseases)
+		disease.GET("density", handler.GetDiseaseDensity) // 病害密度，groupBy=route|unit
 	}
 
 	road := r.Group("/api/road")
 	{
 		road.GET("list", handler.GetRoads)
+		road.GET("totals", handler.GetNetworkTotalsHandler) // 路网里程统计
+		road.GET("/:id", handler.GetRoad)
+		road.POST("", handler.CreateRoad)
+		road.PUT("/:id", handler.UpdateRoad)
+		road.DELETE("/:id", handler.DeleteRoad)
+		road.POST("import", handler.ImportRoadsHandler) // 从 xlsx 导入，dryRun=true 时只校验
+
+		// 断链
+		road.GET("chains", handler.GetChainBreaks)
+		road.POST("chains", handler.CreateChainBreak)
+		road.DELETE("chains/:id", handler.DeleteChainBreak)
+
+		// 中心线及线性参照
+		road.GET("geometry", handler.GetRoadGeometry)
+		road.POST("geometry/import", handler.ImportRoadGeometryHandler) // GeoJSON 或 Shapefile(zip)，dryRun=true 时只校验
+		road.DELETE("geometry/:id", handler.DeleteRoadGeometry)
+		road.GET("locate", handler.LocateStakeHandler) // 桩号定位坐标
 	}
 
 	if err = r.Run(":12345"); err != nil {
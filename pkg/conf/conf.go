@@ -34,4 +34,28 @@ func setDefaultConfig(v *viper.Viper) {
 	v.SetDefault("log.expire", 3)
 	v.SetDefault("log.limit", 15)
 	v.SetDefault("log.stdout", true)
+	v.SetDefault("report.unit", "宁夏公路管理中心吴忠分中心")
+	v.SetDefault("verify.qrPosition", "none") // 导出PDF默认的核验二维码位置：cover / footer / none
+	// 报告链接和核验二维码使用的对外地址，为空时链接按请求推断、不加盖二维码；
+	// 只有来自 trustedProxies 的请求才采用 X-Forwarded-* 请求头
+	v.SetDefault("server.publicBaseUrl", "")
+	v.SetDefault("server.trustedProxies", []string{})
+	v.SetDefault("unzip.maxTotalSize", 4<<30)
+	v.SetDefault("unzip.maxEntries", 10000)
+	v.SetDefault("unzip.maxEntrySize", 1<<30)
+	v.SetDefault("unzip.maxRatio", 100)
+	v.SetDefault("unzip.maxDepth", 3)
+	v.SetDefault("janitor.interval", "1h")
+	v.SetDefault("janitor.uploadTTL", "72h")
+	v.SetDefault("janitor.pdfTTL", "24h")
+	v.SetDefault("calc.execute", false)       // 开启后调用计算程序，否则读取报告目录中的 result.json
+	v.SetDefault("compliance.region", "west") // 东/中/西部区域指标按此选取
+	// 路网登记中没有对应路段时模板使用的里程，km
+	v.SetDefault("network.expresswayKm", "4231.54")
+	v.SetDefault("network.trunkKm", "1751.861")
+	// 前后两次病害匹配：桩号容差（米）、车道号容差、面积增大比例超过该值视为加重、可互相匹配的病害类别
+	v.SetDefault("diseaseMatch.stakeTolerance", 20)
+	v.SetDefault("diseaseMatch.laneTolerance", 0)
+	v.SetDefault("diseaseMatch.areaGrowth", 0.2)
+	v.SetDefault("diseaseMatch.compatible", []string{"pothole,ravelling", "rutting,shoving,subsidence"})
 }
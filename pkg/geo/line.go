@@ -0,0 +1,111 @@
+// Package geo 处理路线中心线几何：按 WGS84 经纬度计算长度，并按沿线距离插值定位和截取线段
+package geo
+
+import (
+	"errors"
+	"math"
+)
+
+// earthRadius WGS84 平均半径（米）
+const earthRadius = 6371008.8
+
+// Point 经纬度坐标 [经度, 纬度]，与 GeoJSON 的坐标顺序一致
+type Point [2]float64
+
+func (p Point) Lon() float64 { return p[0] }
+func (p Point) Lat() float64 { return p[1] }
+
+// Valid 坐标是否在经纬度取值范围内，用于发现误用投影坐标的数据
+func (p Point) Valid() bool {
+	return p[0] >= -180 && p[0] <= 180 && p[1] >= -90 && p[1] <= 90
+}
+
+// Distance 两点间的球面距离（米）
+func Distance(a, b Point) float64 {
+	lat1, lat2 := a.Lat()*math.Pi/180, b.Lat()*math.Pi/180
+	dLat := lat2 - lat1
+	dLon := (b.Lon() - a.Lon()) * math.Pi / 180
+	h := math.Sin(dLat/2)*math.Sin(dLat/2) + math.Cos(lat1)*math.Cos(lat2)*math.Sin(dLon/2)*math.Sin(dLon/2)
+	return 2 * earthRadius * math.Asin(math.Min(1, math.Sqrt(h)))
+}
+
+var ErrInvalidLine = errors.New("线要素至少需要两个不同的坐标点")
+
+// Line 折线，点序与路线前进方向（桩号增大方向）一致
+type Line []Point
+
+// Length 折线长度（米）
+func (l Line) Length() float64 {
+	total := 0.0
+	for i := 1; i < len(l); i++ {
+		total += Distance(l[i-1], l[i])
+	}
+	return total
+}
+
+// Validate 检查坐标范围和点数
+func (l Line) Validate() error {
+	for _, p := range l {
+		if !p.Valid() {
+			return errors.New("坐标超出经纬度范围，请使用 WGS84 经纬度坐标")
+		}
+	}
+	if len(l) < 2 || l.Length() == 0 {
+		return ErrInvalidLine
+	}
+	return nil
+}
+
+// Reverse 反转点序
+func (l Line) Reverse() Line {
+	reversed := make(Line, len(l))
+	for i, p := range l {
+		reversed[len(l)-1-i] = p
+	}
+	return reversed
+}
+
+func lerp(a, b Point, t float64) Point {
+	return Point{a[0] + (b[0]-a[0])*t, a[1] + (b[1]-a[1])*t}
+}
+
+// Interpolate 沿线距起点 distance 米处的坐标，超出范围时取端点
+func (l Line) Interpolate(distance float64) Point {
+	if len(l) == 0 {
+		return Point{}
+	}
+	if distance <= 0 {
+		return l[0]
+	}
+	walked := 0.0
+	for i := 1; i < len(l); i++ {
+		step := Distance(l[i-1], l[i])
+		if step > 0 && walked+step >= distance {
+			return lerp(l[i-1], l[i], (distance-walked)/step)
+		}
+		walked += step
+	}
+	return l[len(l)-1]
+}
+
+// Substring 截取沿线距离 from 到 to（米）之间的部分，from 大于 to 时结果与原点序相反
+func (l Line) Substring(from, to float64) Line {
+	if from > to {
+		return l.Substring(to, from).Reverse()
+	}
+	if len(l) == 0 {
+		return nil
+	}
+	sub := Line{l.Interpolate(from)}
+	walked := 0.0
+	for i := 1; i < len(l); i++ {
+		walked += Distance(l[i-1], l[i])
+		if walked >= to {
+			break
+		}
+		if walked > from {
+			sub = append(sub, l[i])
+		}
+	}
+	return append(sub, l.Interpolate(to))
+}
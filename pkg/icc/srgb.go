@@ -0,0 +1,19 @@
+// Package icc 提供归档PDF输出意图使用的 sRGB ICC 特性文件
+package icc
+
+import (
+	_ "embed"
+)
+
+// SRGBIdentifier 输出意图的 OutputConditionIdentifier
+const SRGBIdentifier = "sRGB IEC61966-2.1"
+
+// srgbProfile IEC 61966-2.1 标准 sRGB 特性文件（ICC v2.1，3144 字节）
+//
+//go:embed sRGB_IEC61966-2.1.icc
+var srgbProfile []byte
+
+// SRGB sRGB 特性文件内容
+func SRGB() []byte {
+	return srgbProfile
+}
@@ -0,0 +1,108 @@
+package stake
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+)
+
+// Break 断链：桩号走到 Back 后改从 Ahead 起算。Ahead 小于 Back 为长链（部分桩号重复出现），
+// 大于 Back 为短链（部分桩号不存在）
+type Break struct {
+	Back  Stake `json:"back"`
+	Ahead Stake `json:"ahead"`
+}
+
+// Length 断链长度，正数为长链，负数为短链
+func (b Break) Length() Stake {
+	return b.Back - b.Ahead
+}
+
+func (b Break) String() string {
+	return fmt.Sprintf("%s=%s", b.Back, b.Ahead)
+}
+
+var (
+	ErrChainGap   = errors.New("桩号位于短链跳过的范围内")
+	ErrChainOrder = errors.New("断链顺序有误")
+)
+
+// Chain 一条路线的断链表，用于在桩号和自起点量起的实际里程（米）之间换算
+type Chain struct {
+	breaks []Break
+	// zones[i] 为第 i 段桩号连续区间的起点桩号、终点桩号和起点对应的实际里程
+	zones []zone
+}
+
+type zone struct {
+	start, end Stake
+	offset     float64
+}
+
+// NewChain 按路线前进方向给出的断链建立断链表，没有断链时各桩号的实际里程就是其米数
+func NewChain(breaks []Break) (*Chain, error) {
+	c := &Chain{breaks: append([]Break(nil), breaks...)}
+	start, offset := Stake(0), 0.0
+	for i, b := range c.breaks {
+		if b.Back <= start {
+			return nil, fmt.Errorf("%w: 第 %d 处断链 %s 的断前桩号应大于 %s", ErrChainOrder, i+1, b, start)
+		}
+		c.zones = append(c.zones, zone{start: start, end: b.Back, offset: offset})
+		offset += float64(b.Back - start)
+		start = b.Ahead
+	}
+	c.zones = append(c.zones, zone{start: start, end: Stake(1e12), offset: offset})
+	return c, nil
+}
+
+// Breaks 断链列表
+func (c *Chain) Breaks() []Break {
+	return append([]Break(nil), c.breaks...)
+}
+
+// Distance 桩号自路线起点的实际里程（米）。长链范围内的桩号出现两次，取第一次出现的位置；
+// 短链跳过的桩号返回 ErrChainGap
+func (c *Chain) Distance(s Stake) (float64, error) {
+	for _, z := range c.zones {
+		if s >= z.start && s <= z.end {
+			return z.offset + float64(s-z.start), nil
+		}
+	}
+	return 0, fmt.Errorf("%w: %s", ErrChainGap, s)
+}
+
+// Distances 桩号可能对应的全部实际里程，长链范围内的桩号有两个结果
+func (c *Chain) Distances(s Stake) []float64 {
+	var distances []float64
+	for _, z := range c.zones {
+		if s >= z.start && s <= z.end {
+			distances = append(distances, z.offset+float64(s-z.start))
+		}
+	}
+	return distances
+}
+
+// StakeAt 实际里程处的桩号，是 Distance 的逆运算
+func (c *Chain) StakeAt(distance float64) Stake {
+	i := sort.Search(len(c.zones), func(i int) bool {
+		return c.zones[i].offset > distance
+	}) - 1
+	if i < 0 {
+		return round(Stake(distance))
+	}
+	z := c.zones[i]
+	return round(z.start + Stake(distance-z.offset))
+}
+
+// Segment 将桩号区间换算为实际里程区间
+func (c *Chain) Segment(from, to Stake) (Segment, error) {
+	start, err := c.Distance(from)
+	if err != nil {
+		return Segment{}, err
+	}
+	end, err := c.Distance(to)
+	if err != nil {
+		return Segment{}, err
+	}
+	return NewSegment(Stake(start), Stake(end)), nil
+}
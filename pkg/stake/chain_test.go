@@ -0,0 +1,180 @@
+package stake
+
+import (
+	"errors"
+	"slices"
+	"testing"
+)
+
+// longChain K10+000=K9+800：K9+800~K10+000 的桩号出现两次
+// shortChain K5+000=K5+300：K5+000~K5+300 之间的桩号不存在
+var (
+	longChain  = []Break{{Back: 10000, Ahead: 9800}}
+	shortChain = []Break{{Back: 5000, Ahead: 5300}}
+	mixedChain = []Break{{Back: 5000, Ahead: 5300}, {Back: 10000, Ahead: 9800}}
+)
+
+func mustChain(t *testing.T, breaks []Break) *Chain {
+	t.Helper()
+	c, err := NewChain(breaks)
+	if err != nil {
+		t.Fatalf("NewChain(%v) error: %v", breaks, err)
+	}
+	return c
+}
+
+func TestBreakLength(t *testing.T) {
+	if got := longChain[0].Length(); got != 200 {
+		t.Errorf("long chain length = %v, want 200", float64(got))
+	}
+	if got := shortChain[0].Length(); got != -300 {
+		t.Errorf("short chain length = %v, want -300", float64(got))
+	}
+}
+
+func TestNewChainOrder(t *testing.T) {
+	tests := []struct {
+		name   string
+		breaks []Break
+	}{
+		{"back at zero", []Break{{Back: 0, Ahead: 100}}},
+		{"back before previous ahead", []Break{{Back: 5000, Ahead: 5300}, {Back: 5200, Ahead: 5100}}},
+		{"back equal to previous ahead", []Break{{Back: 5000, Ahead: 5300}, {Back: 5300, Ahead: 5400}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := NewChain(tt.breaks); !errors.Is(err, ErrChainOrder) {
+				t.Errorf("NewChain(%v) error = %v, want ErrChainOrder", tt.breaks, err)
+			}
+		})
+	}
+}
+
+func TestDistance(t *testing.T) {
+	tests := []struct {
+		name   string
+		breaks []Break
+		stake  Stake
+		want   float64
+	}{
+		{"no breaks", nil, 12345, 12345},
+		{"before long chain", longChain, 9000, 9000},
+		{"long chain duplicate takes first", longChain, 9900, 9900},
+		{"long chain back", longChain, 10000, 10000},
+		{"after long chain", longChain, 10500, 10700},
+		{"short chain back", shortChain, 5000, 5000},
+		{"short chain ahead", shortChain, 5300, 5000},
+		{"after short chain", shortChain, 6000, 5700},
+		{"after both", mixedChain, 9900, 9600},
+		{"after both past long chain", mixedChain, 10500, 10400},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := mustChain(t, tt.breaks).Distance(tt.stake)
+			if err != nil {
+				t.Fatalf("Distance(%s) error: %v", tt.stake, err)
+			}
+			if got != tt.want {
+				t.Errorf("Distance(%s) = %v, want %v", tt.stake, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestDistanceShortChainGap(t *testing.T) {
+	c := mustChain(t, shortChain)
+	for _, s := range []Stake{5000.5, 5100, 5299.9} {
+		if _, err := c.Distance(s); !errors.Is(err, ErrChainGap) {
+			t.Errorf("Distance(%s) error = %v, want ErrChainGap", s, err)
+		}
+		if got := c.Distances(s); len(got) != 0 {
+			t.Errorf("Distances(%s) = %v, want none", s, got)
+		}
+	}
+}
+
+func TestDistancesLongChain(t *testing.T) {
+	c := mustChain(t, longChain)
+	tests := []struct {
+		stake Stake
+		want  []float64
+	}{
+		{9000, []float64{9000}},
+		{9800, []float64{9800, 10000}},
+		{9900, []float64{9900, 10100}},
+		{10000, []float64{10000, 10200}},
+		{10500, []float64{10700}},
+	}
+	for _, tt := range tests {
+		if got := c.Distances(tt.stake); !slices.Equal(got, tt.want) {
+			t.Errorf("Distances(%s) = %v, want %v", tt.stake, got, tt.want)
+		}
+	}
+}
+
+func TestStakeAt(t *testing.T) {
+	tests := []struct {
+		name     string
+		breaks   []Break
+		distance float64
+		want     Stake
+	}{
+		{"no breaks", nil, 1234.5, 1234.5},
+		{"before long chain", longChain, 9900, 9900},
+		{"second pass of long chain", longChain, 10100, 9900},
+		{"after long chain", longChain, 10700, 10500},
+		{"short chain jumps to ahead", shortChain, 5000, 5300},
+		{"after short chain", shortChain, 5700, 6000},
+		{"negative distance", longChain, -10, -10},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := mustChain(t, tt.breaks).StakeAt(tt.distance); got != tt.want {
+				t.Errorf("StakeAt(%v) = %s, want %s", tt.distance, got, tt.want)
+			}
+		})
+	}
+}
+
+// StakeAt 是 Distance 的逆运算：断链端点以外的桩号换算后原样返回，
+// 任一里程换算出的桩号都能换算回该里程（长链重复桩号取其中之一）
+func TestStakeAtInverse(t *testing.T) {
+	for _, breaks := range [][]Break{nil, longChain, shortChain, mixedChain} {
+		c := mustChain(t, breaks)
+		for s := Stake(0); s <= 12000; s += 37.5 {
+			d, err := c.Distance(s)
+			if errors.Is(err, ErrChainGap) {
+				continue
+			}
+			if err != nil {
+				t.Fatalf("%v: Distance(%s) error: %v", breaks, s, err)
+			}
+			if slices.ContainsFunc(breaks, func(b Break) bool { return s == b.Back || s == b.Ahead }) {
+				continue
+			}
+			if got := c.StakeAt(d); got != s {
+				t.Errorf("%v: StakeAt(Distance(%s)) = %s", breaks, s, got)
+			}
+		}
+		for d := 0.0; d <= 12000; d += 37.5 {
+			s := c.StakeAt(d)
+			if !slices.Contains(c.Distances(s), d) {
+				t.Errorf("%v: StakeAt(%v) = %s, Distances = %v", breaks, d, s, c.Distances(s))
+			}
+		}
+	}
+}
+
+func TestChainSegment(t *testing.T) {
+	c := mustChain(t, mixedChain)
+	got, err := c.Segment(10500, 4000)
+	if err != nil {
+		t.Fatalf("Segment error: %v", err)
+	}
+	if want := (Segment{Start: 4000, End: 10400}); got != want {
+		t.Errorf("Segment(K10+500, K4+000) = %v, want %v", got, want)
+	}
+	if _, err = c.Segment(4000, 5100); !errors.Is(err, ErrChainGap) {
+		t.Errorf("Segment into short chain error = %v, want ErrChainGap", err)
+	}
+}
@@ -0,0 +1,130 @@
+package stake
+
+import (
+	"fmt"
+	"math"
+	"sort"
+)
+
+// Segment 路段 [Start, End)，Start 不大于 End。存在断链的路线应先用 Chain 换算为实际里程
+type Segment struct {
+	Start Stake `json:"start"`
+	End   Stake `json:"end"`
+}
+
+// NewSegment 由两个端点建立路段，下行数据起点桩号大于终点桩号时自动调换
+func NewSegment(a, b Stake) Segment {
+	if a > b {
+		a, b = b, a
+	}
+	return Segment{Start: a, End: b}
+}
+
+// Length 路段长度（米）
+func (s Segment) Length() float64 {
+	return float64(s.End - s.Start)
+}
+
+func (s Segment) String() string {
+	return fmt.Sprintf("%s~%s", s.Start, s.End)
+}
+
+// Contains 路段是否完整包含 other
+func (s Segment) Contains(other Segment) bool {
+	return other.Start >= s.Start && other.End <= s.End
+}
+
+// Overlap 两个路段的重叠部分，仅端点相接不算重叠
+func Overlap(a, b Segment) (Segment, bool) {
+	start, end := max(a.Start, b.Start), min(a.End, b.End)
+	if start >= end {
+		return Segment{}, false
+	}
+	return Segment{Start: start, End: end}, true
+}
+
+// Gap 两个不重叠路段之间的间隙，重叠或首尾相接时没有间隙
+func Gap(a, b Segment) (Segment, bool) {
+	if a.Start > b.Start {
+		a, b = b, a
+	}
+	if b.Start <= a.End {
+		return Segment{}, false
+	}
+	return Segment{Start: a.End, End: b.Start}, true
+}
+
+// Union 合并重叠或首尾相接的路段，结果按起点排序
+func Union(segments []Segment) []Segment {
+	if len(segments) == 0 {
+		return nil
+	}
+	sorted := append([]Segment(nil), segments...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i].Start < sorted[j].Start })
+	merged := []Segment{sorted[0]}
+	for _, s := range sorted[1:] {
+		last := &merged[len(merged)-1]
+		if s.Start <= last.End {
+			last.End = max(last.End, s.End)
+		} else {
+			merged = append(merged, s)
+		}
+	}
+	return merged
+}
+
+// Gaps 路段集合在 within 范围内未覆盖的部分
+func Gaps(segments []Segment, within Segment) []Segment {
+	var gaps []Segment
+	cursor := within.Start
+	for _, s := range Union(segments) {
+		if s.End <= cursor {
+			continue
+		}
+		if s.Start >= within.End {
+			break
+		}
+		if s.Start > cursor {
+			gaps = append(gaps, Segment{Start: cursor, End: s.Start})
+		}
+		cursor = s.End
+	}
+	if cursor < within.End {
+		gaps = append(gaps, Segment{Start: cursor, End: within.End})
+	}
+	return gaps
+}
+
+// Overlaps 路段集合中两两重叠的部分，用于发现重复录入的数据
+func Overlaps(segments []Segment) []Segment {
+	sorted := append([]Segment(nil), segments...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i].Start < sorted[j].Start })
+	var overlaps []Segment
+	for i := range sorted {
+		for j := i + 1; j < len(sorted) && sorted[j].Start < sorted[i].End; j++ {
+			if o, ok := Overlap(sorted[i], sorted[j]); ok {
+				overlaps = append(overlaps, o)
+			}
+		}
+	}
+	return overlaps
+}
+
+// Split 按整单元切分路段，切分点落在 unit 的整数倍上，首尾单元可能不足一个单元长度。
+// 例如 K1+050~K1+320 按 100 米切分为 K1+050~K1+100、K1+100~K1+200、K1+200~K1+300、K1+300~K1+320
+func Split(s Segment, unit Stake) []Segment {
+	if unit <= 0 || s.Length() <= 0 {
+		return nil
+	}
+	var units []Segment
+	start := s.Start
+	for start < s.End {
+		end := round(Stake(math.Floor(float64(start/unit)+1) * float64(unit)))
+		if end > s.End {
+			end = s.End
+		}
+		units = append(units, Segment{Start: start, End: end})
+		start = end
+	}
+	return units
+}
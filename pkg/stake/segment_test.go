@@ -0,0 +1,122 @@
+package stake
+
+import (
+	"slices"
+	"testing"
+)
+
+func seg(start, end Stake) Segment {
+	return Segment{Start: start, End: end}
+}
+
+func TestNewSegment(t *testing.T) {
+	if got := NewSegment(2000, 1000); got != seg(1000, 2000) {
+		t.Errorf("NewSegment(2000, 1000) = %v", got)
+	}
+	if got := NewSegment(1000, 2000).Length(); got != 1000 {
+		t.Errorf("Length = %v, want 1000", got)
+	}
+}
+
+func TestOverlapAndGap(t *testing.T) {
+	tests := []struct {
+		name        string
+		a, b        Segment
+		overlap     Segment
+		overlapping bool
+		gap         Segment
+		hasGap      bool
+	}{
+		{"overlapping", seg(0, 500), seg(300, 800), seg(300, 500), true, Segment{}, false},
+		{"contained", seg(0, 1000), seg(200, 300), seg(200, 300), true, Segment{}, false},
+		{"touching", seg(0, 500), seg(500, 800), Segment{}, false, Segment{}, false},
+		{"apart", seg(0, 500), seg(700, 800), Segment{}, false, seg(500, 700), true},
+		{"apart reversed", seg(700, 800), seg(0, 500), Segment{}, false, seg(500, 700), true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got, ok := Overlap(tt.a, tt.b); ok != tt.overlapping || got != tt.overlap {
+				t.Errorf("Overlap = %v, %v, want %v, %v", got, ok, tt.overlap, tt.overlapping)
+			}
+			if got, ok := Gap(tt.a, tt.b); ok != tt.hasGap || got != tt.gap {
+				t.Errorf("Gap = %v, %v, want %v, %v", got, ok, tt.gap, tt.hasGap)
+			}
+		})
+	}
+}
+
+func TestUnion(t *testing.T) {
+	got := Union([]Segment{seg(800, 900), seg(0, 300), seg(300, 500), seg(450, 600)})
+	if want := []Segment{seg(0, 600), seg(800, 900)}; !slices.Equal(got, want) {
+		t.Errorf("Union = %v, want %v", got, want)
+	}
+	if got := Union(nil); got != nil {
+		t.Errorf("Union(nil) = %v, want nil", got)
+	}
+}
+
+func TestGaps(t *testing.T) {
+	tests := []struct {
+		name     string
+		segments []Segment
+		within   Segment
+		want     []Segment
+	}{
+		{"fully covered", []Segment{seg(0, 600), seg(500, 1000)}, seg(0, 1000), nil},
+		{"middle gap", []Segment{seg(0, 300), seg(700, 1000)}, seg(0, 1000), []Segment{seg(300, 700)}},
+		{"gaps at both ends", []Segment{seg(200, 800)}, seg(0, 1000), []Segment{seg(0, 200), seg(800, 1000)}},
+		{"segments outside range", []Segment{seg(-500, 100), seg(900, 1500)}, seg(0, 1000), []Segment{seg(100, 900)}},
+		{"nothing covered", nil, seg(0, 1000), []Segment{seg(0, 1000)}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := Gaps(tt.segments, tt.within); !slices.Equal(got, tt.want) {
+				t.Errorf("Gaps = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestOverlaps(t *testing.T) {
+	tests := []struct {
+		name     string
+		segments []Segment
+		want     []Segment
+	}{
+		{"none", []Segment{seg(0, 100), seg(100, 200), seg(300, 400)}, nil},
+		{"pair", []Segment{seg(150, 300), seg(0, 200)}, []Segment{seg(150, 200)}},
+		{"duplicate", []Segment{seg(0, 100), seg(0, 100)}, []Segment{seg(0, 100)}},
+		{"one spans several", []Segment{seg(0, 1000), seg(100, 200), seg(500, 600)}, []Segment{seg(100, 200), seg(500, 600)}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := Overlaps(tt.segments); !slices.Equal(got, tt.want) {
+				t.Errorf("Overlaps = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSplit(t *testing.T) {
+	tests := []struct {
+		name    string
+		segment Segment
+		unit    Stake
+		want    []Segment
+	}{
+		{"partial units at both ends", seg(1050, 1320), Unit100m, []Segment{seg(1050, 1100), seg(1100, 1200), seg(1200, 1300), seg(1300, 1320)}},
+		{"aligned to boundaries", seg(1000, 3000), Unit1km, []Segment{seg(1000, 2000), seg(2000, 3000)}},
+		{"inside one unit", seg(1210, 1290), Unit100m, []Segment{seg(1210, 1290)}},
+		{"ends on boundary", seg(950, 1000), Unit100m, []Segment{seg(950, 1000)}},
+		{"fractional stakes", seg(99.5, 200.25), Unit100m, []Segment{seg(99.5, 100), seg(100, 200), seg(200, 200.25)}},
+		{"empty segment", seg(500, 500), Unit100m, nil},
+		{"invalid unit", seg(0, 500), 0, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := Split(tt.segment, tt.unit); !slices.Equal(got, tt.want) {
+				t.Errorf("Split(%v, %v) = %v, want %v", tt.segment, float64(tt.unit), got, tt.want)
+			}
+		})
+	}
+}
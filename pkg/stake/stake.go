@@ -0,0 +1,85 @@
+// Package stake 解析和格式化公路桩号，处理断链，并提供路段的重叠、间隙、合并和切分运算。
+// 桩号统一以米表示，K123+456.7 即 123456.7 米
+package stake
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// Stake 桩号对应的米数
+type Stake float64
+
+// 常用的评定单元长度
+const (
+	Unit100m Stake = 100
+	Unit1km  Stake = 1000
+)
+
+// 允许的前缀：K，以及分幅桩号常用的 ZK（左幅）、YK（右幅）
+var stakeRegexp = regexp.MustCompile(`^(?:[ZY]?K)?(\d+)\+(\d+(?:\.\d+)?)$`)
+
+var ErrInvalid = errors.New("桩号格式有误")
+
+// Parse 解析 K123+456、K123+456.7、123+456 形式的桩号；不带 + 的数字按公里数解析，如 123.456。
+// 结果按毫米取整
+func Parse(s string) (Stake, error) {
+	s = strings.ToUpper(strings.ReplaceAll(strings.TrimSpace(s), " ", ""))
+	if m := stakeRegexp.FindStringSubmatch(s); m != nil {
+		km, err := strconv.ParseFloat(m[1], 64)
+		if err != nil {
+			return 0, fmt.Errorf("%w: %s", ErrInvalid, s)
+		}
+		metres, err := strconv.ParseFloat(m[2], 64)
+		if err != nil || metres >= 1000 {
+			return 0, fmt.Errorf("%w: %s 的米数应小于 1000", ErrInvalid, s)
+		}
+		return round(Stake(km*1000 + metres)), nil
+	}
+	// 带 + 却不符合 K+ 格式的一律视为错误，避免 +500 被当作 500 公里
+	km, err := strconv.ParseFloat(strings.TrimPrefix(s, "K"), 64)
+	if err != nil || strings.Contains(s, "+") || km < 0 || math.IsInf(km, 0) || math.IsNaN(km) {
+		return 0, fmt.Errorf("%w: %s", ErrInvalid, s)
+	}
+	return round(Stake(km * 1000)), nil
+}
+
+// FromKm 由公里数得到桩号
+func FromKm(km float64) Stake {
+	return round(Stake(km * 1000))
+}
+
+func round(s Stake) Stake {
+	return Stake(math.Round(float64(s)*1000) / 1000)
+}
+
+// Meters 桩号的米数
+func (s Stake) Meters() float64 {
+	return float64(s)
+}
+
+// Km 桩号的公里数
+func (s Stake) Km() float64 {
+	return float64(s) / 1000
+}
+
+// String 格式化为 K123+456，米数有小数时保留，如 K123+456.7
+func (s Stake) String() string {
+	s = round(s)
+	sign := ""
+	if s < 0 {
+		sign, s = "-", -s
+	}
+	km := math.Floor(float64(s) / 1000)
+	metres := float64(s) - km*1000
+	whole := math.Floor(metres)
+	out := fmt.Sprintf("%sK%d+%03d", sign, int64(km), int64(whole))
+	if frac := math.Round((metres-whole)*1000) / 1000; frac > 0 {
+		out += strings.TrimPrefix(strconv.FormatFloat(frac, 'f', -1, 64), "0")
+	}
+	return out
+}
@@ -0,0 +1,78 @@
+package stake
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		in   string
+		want Stake
+	}{
+		{"K123+456", 123456},
+		{"k1+050.5", 1050.5},
+		{"ZK10+000", 10000},
+		{"YK3+200", 3200},
+		{"123+456", 123456},
+		{" K 1 + 002 ", 1002},
+		{"K0+999.999", 999.999},
+		{"K1+000.0004", 1000},
+		{"12.345", 12345},
+		{"K7", 7000},
+	}
+	for _, tt := range tests {
+		t.Run(tt.in, func(t *testing.T) {
+			got, err := Parse(tt.in)
+			if err != nil {
+				t.Fatalf("Parse(%q) error: %v", tt.in, err)
+			}
+			if got != tt.want {
+				t.Errorf("Parse(%q) = %v, want %v", tt.in, float64(got), float64(tt.want))
+			}
+		})
+	}
+}
+
+func TestParseInvalid(t *testing.T) {
+	for _, in := range []string{"", "K1+1000", "K1+1000.5", "K1+", "+500", "K1+-5", "AK1+000", "abc", "-1", "NaN", "Inf"} {
+		t.Run(in, func(t *testing.T) {
+			if got, err := Parse(in); !errors.Is(err, ErrInvalid) {
+				t.Errorf("Parse(%q) = %v, %v, want ErrInvalid", in, float64(got), err)
+			}
+		})
+	}
+}
+
+func TestString(t *testing.T) {
+	tests := []struct {
+		in   Stake
+		want string
+	}{
+		{0, "K0+000"},
+		{123456, "K123+456"},
+		{1050.5, "K1+050.5"},
+		{1050.125, "K1+050.125"},
+		{999.9996, "K1+000"},
+		{-1500, "-K1+500"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.want, func(t *testing.T) {
+			if got := tt.in.String(); got != tt.want {
+				t.Errorf("Stake(%v).String() = %q, want %q", float64(tt.in), got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseStringRoundTrip(t *testing.T) {
+	for _, in := range []string{"K0+000", "K1+050.5", "K123+456", "K2000+001.25"} {
+		s, err := Parse(in)
+		if err != nil {
+			t.Fatalf("Parse(%q) error: %v", in, err)
+		}
+		if got := s.String(); got != in {
+			t.Errorf("Parse(%q).String() = %q", in, got)
+		}
+	}
+}